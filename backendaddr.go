@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// withConnTrace logs the address of the backend connection each request is
+// sent on, which tells apart the instances behind a DNS name with several
+// addresses. Through -upstream-proxy this is the proxy's address.
+func (d *dumper) withConnTrace(req *http.Request) *http.Request {
+	logger := d.at(levelInfo, d.loggerFor(req.Context()))
+	ex := exchangeFrom(req.Context())
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			addr := info.Conn.RemoteAddr().String()
+			if ex != nil {
+				ex.backendAddr = addr
+			}
+			if info.Reused {
+				logger.Printf("Backend connection: %s (from %s, reused, idle %s)", addr, info.Conn.LocalAddr(), info.IdleTime)
+			} else {
+				logger.Printf("Backend connection: %s (from %s, new)", addr, info.Conn.LocalAddr())
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// describeTransport summarizes the backend connection settings for the
+// startup log, so that the defaults timing results depend on are visible
+func describeTransport(t *http.Transport, dialTimeout time.Duration) string {
+	limit := func(d time.Duration) string {
+		if d == 0 {
+			return "none"
+		}
+		return d.String()
+	}
+	keepAlive := fmt.Sprintf("keep-alive, up to %d idle (%d per host) for %s", t.MaxIdleConns, t.MaxIdleConnsPerHost, limit(t.IdleConnTimeout))
+	if t.DisableKeepAlives {
+		keepAlive = "one per request, keep-alive disabled"
+	}
+	return fmt.Sprintf("%s; dial timeout %s, response header timeout %s", keepAlive, limit(dialTimeout), limit(t.ResponseHeaderTimeout))
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setListenBacklog is only supported on unix systems
+func setListenBacklog(net.Listener, int) error {
+	return errors.New("setting the listen backlog is not supported on this platform")
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetListenBacklogKeepsTheListenerWorking(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := setListenBacklog(ln, 16); err != nil {
+		t.Fatalf("setListenBacklog: %v", err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := <-accepted; err != nil {
+		t.Errorf("Accept after setting the backlog: %v", err)
+	}
+}
+
+func TestSetListenBacklogNeedsATCPListener(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "s"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	if err := setListenBacklog(ln, 16); err == nil {
+		t.Error("setListenBacklog accepted a unix listener")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bodyTag finds the opening <body> tag of an HTML document
+var bodyTag = regexp.MustCompile(`(?i)<body(\s[^>]*)?>`)
+
+// htmlBanner returns the snippet -html-banner inserts after <body>
+func htmlBanner(text string) []byte {
+	return []byte(`<div style="position:sticky;top:0;z-index:2147483647;padding:4px 8px;background:#fc0;color:#000;font:bold 13px sans-serif;text-align:center">` + html.EscapeString(text) + `</div>`)
+}
+
+// injectBanner inserts banner after the <body> tag of text/html responses.
+// The body is decoded and, when it was gzipped, compressed again; responses
+// in another content encoding are passed through.
+func (d *dumper) injectBanner(resp *http.Response, banner []byte) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	logger := d.loggerFor(resp.Request.Context())
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "" && encoding != "identity" && encoding != "gzip" && encoding != "x-gzip" {
+		d.at(levelDebug, logger).Printf("HTML banner not injected: unsupported Content-Encoding %q", encoding)
+		return
+	}
+	raw, err := readScriptBody(&resp.Body)
+	if err != nil {
+		logger.Printf("Error reading response body, HTML banner not injected: %v", err)
+		return
+	}
+	body := raw
+	if encoding == "gzip" || encoding == "x-gzip" {
+		if body, err = gunzip(raw); err != nil {
+			logger.Printf("Error decompressing response body, HTML banner not injected: %v", err)
+			return
+		}
+	}
+	loc := bodyTag.FindIndex(body)
+	if loc == nil {
+		d.at(levelDebug, logger).Printf("HTML banner not injected: no <body> tag")
+		return
+	}
+	out := make([]byte, 0, len(body)+len(banner))
+	out = append(append(append(out, body[:loc[1]]...), banner...), body[loc[1]:]...)
+	if encoding == "gzip" || encoding == "x-gzip" {
+		if out, err = gzipBytes(out); err != nil {
+			logger.Printf("Error compressing response body, HTML banner not injected: %v", err)
+			return
+		}
+	}
+	resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	resp.Body = readCloser{bytes.NewReader(out), http.NoBody}
+	resp.ContentLength = int64(len(out))
+	resp.TransferEncoding = nil
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if _, err := w.Write(b); err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	return out.Bytes(), nil
+}
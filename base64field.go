@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// parseJSONPaths splits a comma-separated list of dotted paths such as
+// "data.blob,items.*.payload"; "*" matches any object key or array index
+func parseJSONPaths(list string) [][]string {
+	var paths [][]string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, strings.Split(p, "."))
+		}
+	}
+	return paths
+}
+
+// logBase64Fields logs the decoded value of the -decode-base64-fields paths
+// of a JSON body. Values that are not valid base64 are skipped.
+func (d *dumper) logBase64Fields(logger *log.Logger, body []byte, contentType string) {
+	if len(d.base64Fields) == 0 || !debugproxy.IsJSON(contentType) {
+		return
+	}
+	doc, ok := parseJSONDoc(body)
+	if !ok {
+		return
+	}
+	for _, path := range d.base64Fields {
+		walkJSONPath(doc, path, "", func(at string, v any) {
+			s, ok := v.(string)
+			if !ok {
+				return
+			}
+			decoded, ok := decodeBase64(s)
+			if !ok {
+				return
+			}
+			if utf8.Valid(decoded) && !bytes.ContainsFunc(decoded, isControl) {
+				logger.Printf("----- BASE64 FIELD %s (%d bytes) -----\n%s", at, len(decoded), decoded)
+			} else {
+				logger.Printf("----- BASE64 FIELD %s (%d bytes) -----\n%s", at, len(decoded), hex.Dump(decoded))
+			}
+		})
+	}
+}
+
+// walkJSONPath calls visit with every value of doc at path
+func walkJSONPath(doc any, path []string, at string, visit func(at string, v any)) {
+	if len(path) == 0 {
+		visit(at, doc)
+		return
+	}
+	key, rest := path[0], path[1:]
+	join := func(k string) string {
+		if at == "" {
+			return k
+		}
+		return at + "." + k
+	}
+	switch v := doc.(type) {
+	case map[string]any:
+		if key == "*" {
+			for k, child := range v {
+				walkJSONPath(child, rest, join(k), visit)
+			}
+		} else if child, ok := v[key]; ok {
+			walkJSONPath(child, rest, join(key), visit)
+		}
+	case []any:
+		for i, child := range v {
+			if key == "*" || key == strconv.Itoa(i) {
+				walkJSONPath(child, rest, join(strconv.Itoa(i)), visit)
+			}
+		}
+	}
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not
+func decodeBase64(s string) ([]byte, bool) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\n' && r != '\r' && r != '\t' || r == 0x7f
+}
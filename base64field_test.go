@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"testing"
+)
+
+func TestLogBase64Fields(t *testing.T) {
+	d := &dumper{base64Fields: parseJSONPaths("data.blob, items.*.payload,missing.path")}
+	body := []byte(`{"data":{"blob":"aGVsbG8="},"items":[{"payload":"AAEC"},{"payload":"not base64!"},{"payload":7}]}`)
+	var logs bytes.Buffer
+	d.logBase64Fields(log.New(&logs, "", 0), body, "application/json; charset=utf-8")
+	want := "----- BASE64 FIELD data.blob (5 bytes) -----\nhello\n" +
+		"----- BASE64 FIELD items.0.payload (3 bytes) -----\n00000000  00 01 02                                          |...|\n"
+	if logs.String() != want {
+		t.Errorf("log:\n%q\nwant:\n%q", logs.String(), want)
+	}
+}
+
+func TestLogBase64FieldsSkipsOtherBodies(t *testing.T) {
+	d := &dumper{base64Fields: parseJSONPaths("blob")}
+	var logs bytes.Buffer
+	d.logBase64Fields(log.New(&logs, "", 0), []byte(`{"blob":"aGVsbG8="}`), "text/plain")
+	d.logBase64Fields(log.New(&logs, "", 0), []byte(`{"blob":`), "application/json")
+	if logs.Len() > 0 {
+		t.Errorf("logged for a non-JSON or broken body:\n%s", logs.String())
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	for _, s := range []string{"aGk/Pz8=", "aGk/Pz8", "aGk_Pz8=", "aGk_Pz8"} {
+		if got, ok := decodeBase64(s); !ok || string(got) != "hi???" {
+			t.Errorf("decodeBase64(%q) = %q, %v, want %q", s, got, ok, "hi???")
+		}
+	}
+	if _, ok := decodeBase64("not base64!"); ok {
+		t.Error("decodeBase64 accepted invalid base64")
+	}
+}
+
+func TestParseJSONPaths(t *testing.T) {
+	got := parseJSONPaths(" a.b , ,items.*.c")
+	want := [][]string{{"a", "b"}, {"items", "*", "c"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("parseJSONPaths = %q, want %q", got, want)
+	}
+}
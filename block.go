@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// pathBlocklist holds the -block-paths patterns
+type pathBlocklist []*regexp.Regexp
+
+// parsePathBlocklist parses the regexps matched against the request path,
+// one per flag value since a regexp may contain commas
+func parsePathBlocklist(patterns []string) (pathBlocklist, error) {
+	var list pathBlocklist
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		list = append(list, re)
+	}
+	return list, nil
+}
+
+// match returns the first pattern matching path, or nil
+func (l pathBlocklist) match(path string) *regexp.Regexp {
+	for _, re := range l {
+		if re.MatchString(path) {
+			return re
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPathBlocklist(t *testing.T) {
+	list, err := parsePathBlocklist([]string{`^/v{1,2}/admin`, `\.php$`, ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("parsed %d patterns, want 2", len(list))
+	}
+	for path, want := range map[string]string{
+		"/v/admin":       `^/v{1,2}/admin`,
+		"/vv/admin/keys": `^/v{1,2}/admin`,
+		"/index.php":     `\.php$`,
+		"/vvv/admin":     "",
+		"/api/items":     "",
+	} {
+		got := ""
+		if re := list.match(path); re != nil {
+			got = re.String()
+		}
+		if got != want {
+			t.Errorf("match(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := parsePathBlocklist([]string{"("}); err == nil {
+		t.Error("an invalid regexp was accepted")
+	}
+}
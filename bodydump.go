@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// bodyExtensions picks the extension of saved bodies for the common types
+// where mime.ExtensionsByType has several or none
+var bodyExtensions = map[string]string{
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"text/xml":                 ".xml",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"text/css":                 ".css",
+	"text/csv":                 ".csv",
+	"text/javascript":          ".js",
+	"application/javascript":   ".js",
+	"application/x-ndjson":     ".ndjson",
+	"application/grpc":         ".grpc",
+	"application/octet-stream": ".bin",
+	"application/pdf":          ".pdf",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/svg+xml":            ".svg",
+}
+
+// bodyDumper writes the bodies of exchanges to files in a directory,
+// <exchange id>-<request|response><ext>, for -dump-dir; the log then only
+// names the file
+type bodyDumper struct {
+	dir string
+	// seq names the bodies of requests outside an exchange
+	seq atomic.Uint64
+}
+
+func newBodyDumper(dir string) (*bodyDumper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &bodyDumper{dir: dir}, nil
+}
+
+// bodyExtension returns the file extension for a Content-Type
+func bodyExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := bodyExtensions[mediaType]; ok {
+		return ext
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return ".json"
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return ".xml"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// save writes the body of exchange ex (nil outside one) going in direction
+// label, REQUEST or RESPONSE, and returns the path of the file
+func (b *bodyDumper) save(ex *exchange, label string, body []byte, contentType string) (string, error) {
+	var id uint64
+	if ex != nil {
+		id = ex.id
+	} else {
+		id = b.seq.Add(1)
+	}
+	name := fmt.Sprintf("%06d-%s%s", id, strings.ToLower(label), bodyExtension(contentType))
+	if ex == nil {
+		name = "x" + name
+	}
+	path := filepath.Join(b.dir, name)
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// bufferBudget bounds the body bytes buffered for logging across all
+// concurrent exchanges, and per body. A buffered body holds its bytes from
+// the moment it is read until the proxy closes it after forwarding; bodies
+// that would exceed either bound are streamed instead.
+type bufferBudget struct {
+	// max bounds the bytes held by all bodies, perBody those of one body;
+	// 0 means no bound
+	max     int64
+	perBody int64
+	used    atomic.Int64
+}
+
+// take reads body into memory, reserving its bytes, while it fits in the
+// budget. size is the declared length, -1 when unknown. When the body does
+// not fit, nothing stays reserved, rest replays the bytes read so far
+// followed by the unread remainder, and over names the flag of the bound.
+func (b *bufferBudget) take(body io.ReadCloser, size int64) (data []byte, rest io.ReadCloser, over string, err error) {
+	if b.perBody > 0 && size > b.perBody {
+		return nil, body, "-skip-body-over", nil
+	}
+	if b.max > 0 && size > b.max-b.used.Load() {
+		return nil, body, "-max-buffered-bytes", nil
+	}
+	var buf bytes.Buffer
+	chunk := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(chunk)
+		if n > 0 {
+			buf.Write(chunk[:n])
+			over := ""
+			if b.used.Add(int64(n)) > b.max && b.max > 0 {
+				over = "-max-buffered-bytes"
+			} else if b.perBody > 0 && int64(buf.Len()) > b.perBody {
+				over = "-skip-body-over"
+			}
+			if over != "" {
+				b.used.Add(-int64(buf.Len()))
+				return nil, readCloser{io.MultiReader(&buf, body), body}, over, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			body.Close()
+			return buf.Bytes(), nil, "", nil
+		}
+		if err != nil {
+			b.used.Add(-int64(buf.Len()))
+			body.Close()
+			return nil, nil, "", err
+		}
+	}
+}
+
+// releaseOnClose gives n reserved bytes back once rc is closed. A nil
+// budget returns rc as is.
+func (b *bufferBudget) releaseOnClose(rc io.ReadCloser, n int64) io.ReadCloser {
+	if b == nil {
+		return rc
+	}
+	return &budgetBody{ReadCloser: rc, release: func() { b.used.Add(-n) }}
+}
+
+type budgetBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *budgetBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// overBudget streams a body that did not fit in the budget, logging only its
+// size; over is the flag of the bound it exceeded
+func overBudget(rest io.ReadCloser, logger *log.Logger, label, over string, onEOF func()) io.ReadCloser {
+	logger.Printf("----- %s BODY over %s, streaming without logging it -----", label, over)
+	return &streamLoggingBody{rc: rest, logger: logger, label: label, onEOF: onEOF, summaryOnly: true}
+}
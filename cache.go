@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// cacheHeaders are the response headers summarized by -cache-headers
+var cacheHeaders = []string{"Cache-Control", "ETag", "Last-Modified", "Age", "Expires", "Vary", "Pragma"}
+
+// logCacheHeaders logs the caching headers of a response on one line
+func logCacheHeaders(logger *log.Logger, h http.Header) {
+	var parts []string
+	for _, name := range cacheHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			parts = append(parts, name+"="+strings.Join(values, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		logger.Printf("Cache: no caching headers")
+		return
+	}
+	logger.Printf("Cache: %s", strings.Join(parts, " | "))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// canarySplit picks the share of requests routed to the canary target
+type canarySplit struct {
+	percent float64
+	mu      sync.Mutex
+	rng     *rand.Rand
+}
+
+func newCanarySplit(percent float64, seed uint64) *canarySplit {
+	return &canarySplit{percent: percent, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (c *canarySplit) pick() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64()*100 < c.percent
+}
+
+// canaryDirector routes the exchanges picked for the canary with canary and
+// the others with primary
+func canaryDirector(primary, canary func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		if ex := exchangeFrom(req.Context()); ex != nil && ex.canary {
+			canary(req)
+			return
+		}
+		primary(req)
+	}
+}
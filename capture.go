@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// capturedExchange is a request/response pair kept in memory for inspection.
+// The dump functions fill it in as the exchange progresses, possibly from
+// the transport's goroutines, so access goes through mu.
+type capturedExchange struct {
+	mu       sync.Mutex
+	id       uint64
+	start    time.Time
+	duration time.Duration
+	method   string
+	url      string
+	// proto and responseProto are the HTTP versions of the client request
+	// and of the backend response
+	proto         string
+	responseProto string
+	tags          string
+	// redact is the exchange's own redactor, nil for the global one
+	redact         *debugproxy.Redactor
+	status         int
+	err            string
+	requestHeader  http.Header
+	requestBody    []byte
+	responseHeader http.Header
+	responseBody   []byte
+	// streamed is set when the response body was streamed and not kept
+	streamed bool
+	// requestTrailer and responseTrailer are the trailers sent after the
+	// bodies, and interim the 1xx responses received before the final one
+	requestTrailer  http.Header
+	responseTrailer http.Header
+	interim         []interimResponse
+}
+
+// interimResponse is a 1xx response, such as 100 Continue or 103 Early Hints
+type interimResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+}
+
+// redactor returns the redactor masking the secrets of the exchange
+func (c *capturedExchange) redactor(d *dumper) *debugproxy.Redactor {
+	if c.redact != nil {
+		return c.redact
+	}
+	return d.redact.Load()
+}
+
+func (c *capturedExchange) setRequest(h http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requestHeader = h.Clone()
+	c.requestBody = body
+}
+
+func (c *capturedExchange) setResponse(proto string, h http.Header, body []byte, streamed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responseProto = proto
+	c.responseHeader = h.Clone()
+	c.responseBody = body
+	c.streamed = streamed
+}
+
+func (c *capturedExchange) setTrailers(request, response http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if request != nil {
+		c.requestTrailer = request.Clone()
+	}
+	if response != nil {
+		c.responseTrailer = response.Clone()
+	}
+}
+
+func (c *capturedExchange) addInterim(status int, h http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interim = append(c.interim, interimResponse{Status: status, Header: h.Clone()})
+}
+
+func (c *capturedExchange) setError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err.Error()
+}
+
+func (c *capturedExchange) finish(status int, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.duration = duration
+}
+
+// captureStore keeps the last size completed exchanges in a ring buffer
+type captureStore struct {
+	mu   sync.Mutex
+	ring []*capturedExchange
+	next int
+	full bool
+}
+
+func newCaptureStore(size int) *captureStore {
+	return &captureStore{ring: make([]*capturedExchange, size)}
+}
+
+// add stores a completed exchange, evicting the oldest one when full
+func (s *captureStore) add(c *capturedExchange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ring[s.next] = c
+	s.next = (s.next + 1) % len(s.ring)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// list returns the stored exchanges, oldest first
+func (s *captureStore) list() []*capturedExchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]*capturedExchange(nil), s.ring[:s.next]...)
+	}
+	out := make([]*capturedExchange, 0, len(s.ring))
+	out = append(out, s.ring[s.next:]...)
+	return append(out, s.ring[:s.next]...)
+}
+
+// get returns the stored exchange with the given id, or nil
+func (s *captureStore) get(id uint64) *capturedExchange {
+	for _, c := range s.list() {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// clear drops the stored exchanges, returning how many there were
+func (s *captureStore) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.next
+	if s.full {
+		n = len(s.ring)
+	}
+	clear(s.ring)
+	s.next, s.full = 0, false
+	return n
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// headerCasing returns the header names of a raw request head whose casing
+// differs from the canonical form Go keys them by, mapped canonical name to
+// name as received
+func headerCasing(head []byte) map[string]string {
+	lines := bytes.Split(head, []byte("\r\n"))
+	casing := make(map[string]string)
+	for _, line := range lines[1:] {
+		name, _, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		received := strings.TrimSpace(string(name))
+		if canonical := textproto.CanonicalMIMEHeaderKey(received); canonical != received {
+			casing[canonical] = received
+		}
+	}
+	return casing
+}
+
+// logHeaderCasing logs the header names the client sent in another casing
+// than the one the request is forwarded with
+func logHeaderCasing(logger *log.Logger, casing map[string]string, preserve bool) {
+	if len(casing) == 0 {
+		logger.Printf("----- HEADER CASING: all header names received in canonical form -----")
+		return
+	}
+	sent := "as sent"
+	if preserve {
+		sent = "canonical, sent as received with -preserve-header-case"
+	}
+	names := make([]string, 0, len(casing))
+	for canonical := range casing {
+		names = append(names, canonical)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, canonical := range names {
+		b.WriteString(casing[canonical] + " -> " + canonical + "\n")
+	}
+	logger.Printf("----- HEADER CASING (as received -> %s) -----\n%s", sent, b.String())
+}
+
+// preserveHeaderCase renames the headers of req back to the casing the
+// client used. HTTP/1 transports write header names as they are keyed, so
+// the backend gets them as received; HTTP/2 lowercases them anyway. The
+// header map is copied so the canonical one stays in use by the proxy.
+func preserveHeaderCase(req *http.Request, casing map[string]string) {
+	if len(casing) == 0 {
+		return
+	}
+	h := req.Header.Clone()
+	for canonical, received := range casing {
+		if values, ok := h[canonical]; ok {
+			delete(h, canonical)
+			h[received] = values
+		}
+	}
+	req.Header = h
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// isCBOR reports whether contentType is application/cbor (or a +cbor suffix type)
+func isCBOR(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/cbor" || strings.HasSuffix(mediaType, "+cbor")
+}
+
+// formatCBOR renders a CBOR body as indented JSON for logging, falling back
+// to a hex dump when the body is not valid CBOR
+func formatCBOR(body []byte) []byte {
+	var v any
+	if err := cbor.Unmarshal(body, &v); err != nil {
+		return []byte(fmt.Sprintf("(invalid CBOR: %v)\n%s", err, hex.Dump(body)))
+	}
+	out, err := json.MarshalIndent(cborToJSON(v), "", "  ")
+	if err != nil {
+		return []byte(hex.Dump(body))
+	}
+	return out
+}
+
+// cborToJSON converts decoded CBOR values into values encoding/json can
+// marshal: map keys become strings, byte strings become h'..' hex and tags
+// are kept as {"tag": n, "value": ...}
+func cborToJSON(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = cborToJSON(val)
+		}
+		return m
+	case []any:
+		for i := range v {
+			v[i] = cborToJSON(v[i])
+		}
+		return v
+	case []byte:
+		return "h'" + hex.EncodeToString(v) + "'"
+	case cbor.Tag:
+		return map[string]any{"tag": v.Number, "value": cborToJSON(v.Content)}
+	default:
+		return v
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatCBOR(t *testing.T) {
+	// {"temp": 21.5, "id": 7, "raw": h'0102', "ts": 100("x")} with 21.5 as
+	// a half-precision float and an unregistered tag
+	payload := []byte{
+		0xa4,
+		0x64, 't', 'e', 'm', 'p', 0xf9, 0x4d, 0x60,
+		0x62, 'i', 'd', 0x07,
+		0x63, 'r', 'a', 'w', 0x42, 0x01, 0x02,
+		0x62, 't', 's', 0xd8, 0x64, 0x61, 'x',
+	}
+	const want = `{
+  "id": 7,
+  "raw": "h'0102'",
+  "temp": 21.5,
+  "ts": {
+    "tag": 100,
+    "value": "x"
+  }
+}`
+	if got := string(formatCBOR(payload)); got != want {
+		t.Errorf("formatCBOR =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatCBORFallsBackToHex(t *testing.T) {
+	// a map announcing two pairs with only one
+	payload := []byte{0xa2, 0x61, 'a', 0x01}
+	got := string(formatCBOR(payload))
+	if !strings.HasPrefix(got, "(invalid CBOR: ") || !strings.Contains(got, "a2 61 61 01") {
+		t.Errorf("formatCBOR of an invalid payload =\n%s\nwant the error and a hex dump", got)
+	}
+}
+
+func TestIsCBOR(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/cbor":            true,
+		"application/senml+cbor; x=1": true,
+		"application/json":            false,
+		"":                            false,
+	} {
+		if got := isCBOR(contentType); got != want {
+			t.Errorf("isCBOR(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestCBORBodyIsForwardedAsIsAndLoggedAsJSON(t *testing.T) {
+	payload := []byte{0xa1, 0x62, 'o', 'k', 0xf5} // {"ok": true}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/cbor")
+		_, _ = w.Write(payload)
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL)
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if !bytes.Equal(got, payload) {
+		t.Errorf("client got % x, want % x", got, payload)
+	}
+	if !strings.Contains(logs.String(), "{\n  \"ok\": true\n}") {
+		t.Errorf("log is missing the body as JSON:\n%s", logs)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forcedStatus answers requests whose path matches re with status
+type forcedStatus struct {
+	re     *regexp.Regexp
+	status int
+}
+
+// parseForcedStatus parses a -force-status rule, regexp=status
+func parseForcedStatus(spec string) (forcedStatus, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return forcedStatus{}, fmt.Errorf("%q: want regexp=status", spec)
+	}
+	status, err := strconv.Atoi(spec[i+1:])
+	if err != nil || status < 100 || status > 999 {
+		return forcedStatus{}, fmt.Errorf("%q: invalid status", spec)
+	}
+	re, err := regexp.Compile(spec[:i])
+	if err != nil {
+		return forcedStatus{}, fmt.Errorf("%q: %v", spec, err)
+	}
+	return forcedStatus{re: re, status: status}, nil
+}
+
+// faultInjector slows requests down and answers some of them with an error
+// instead of forwarding them, to see how a client copes with a slow or
+// flaky backend
+type faultInjector struct {
+	delay      time.Duration
+	jitter     time.Duration
+	failRate   float64
+	failStatus int
+	forced     []forcedStatus
+	mu         sync.Mutex
+	rng        *rand.Rand
+}
+
+func newFaultInjector(seed uint64) *faultInjector {
+	return &faultInjector{rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// wait holds a request back for the delay plus up to jitter more, returning
+// the time waited; it gives up when ctx is done
+func (f *faultInjector) wait(ctx context.Context) (time.Duration, error) {
+	delay := f.delay
+	if f.jitter > 0 {
+		f.mu.Lock()
+		delay += time.Duration(f.rng.Int64N(int64(f.jitter) + 1))
+		f.mu.Unlock()
+	}
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// status returns the status to answer a request for path with instead of
+// forwarding it, and why, or 0 to forward it
+func (f *faultInjector) status(path string) (int, string) {
+	for _, r := range f.forced {
+		if r.re.MatchString(path) {
+			return r.status, fmt.Sprintf("path matches -force-status %q", r.re)
+		}
+	}
+	if f.failRate > 0 {
+		f.mu.Lock()
+		fail := f.rng.Float64() < f.failRate
+		f.mu.Unlock()
+		if fail {
+			return f.failStatus, fmt.Sprintf("picked by -fail-rate %g", f.failRate)
+		}
+	}
+	return 0, ""
+}
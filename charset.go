@@ -0,0 +1,34 @@
+package main
+
+import (
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// transcodeToUTF8 converts body from the charset declared in contentType to
+// UTF-8, for logging only. Bodies without a charset, already in UTF-8, or in
+// an unknown charset are returned as is.
+func transcodeToUTF8(body []byte, contentType string) []byte {
+	if len(body) == 0 || contentType == "" {
+		return body
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return body
+	}
+	charset := strings.ToLower(params["charset"])
+	if charset == "" || charset == "utf-8" || charset == "utf8" {
+		return body
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return body
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
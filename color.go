@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+var (
+	// colorMarker matches the ----- NAME ----- lines starting dumps
+	colorMarker = regexp.MustCompile(`----- .*-----$`)
+	// colorSummary matches the method and status of one-line summaries,
+	// #1 GET /path -> 200 OK
+	colorSummary = regexp.MustCompile(`(#\S+ )([A-Z]+)( \S+ -> )(\d{3})\b`)
+	// colorRequestLine and colorStatusLine match the first line of dumps
+	colorRequestLine = regexp.MustCompile(`^([A-Z]+)( \S+ HTTP/[\d.]+)$`)
+	colorStatusLine  = regexp.MustCompile(`^(HTTP/[\d.]+ )(\d{3})(.*)$`)
+)
+
+// useColor reports whether -color turns colors on for out: always, never,
+// or auto for a terminal without NO_COLOR set
+func useColor(mode string, out io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		f, ok := out.(*os.File)
+		if !ok || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid -color %q, expected auto, always or never", mode)
+}
+
+func statusColor(status string) string {
+	switch status[0] {
+	case '2':
+		return ansiGreen
+	case '3':
+		return ansiCyan
+	case '4':
+		return ansiYellow
+	case '5':
+		return ansiRed
+	}
+	return ansiMagenta
+}
+
+func methodColor(method string) string {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return ansiBlue
+	case "POST", "PUT", "PATCH":
+		return ansiYellow
+	case "DELETE":
+		return ansiRed
+	}
+	return ansiMagenta
+}
+
+// colorWriter colors log messages for a terminal: dump markers, methods and
+// statuses by class, dimmed header lines and highlighted JSON bodies. The
+// log package issues one Write per message, so each is colored as a whole.
+type colorWriter struct {
+	w io.Writer
+}
+
+func (c *colorWriter) Write(p []byte) (int, error) {
+	lines := strings.SplitAfter(string(p), "\n")
+	first := strings.TrimSuffix(lines[0], "\n")
+	var out strings.Builder
+	if m := colorMarker.FindStringIndex(first); m != nil {
+		out.WriteString(first[:m[0]] + ansiBold + ansiCyan + first[m[0]:] + ansiReset + "\n")
+		rest := strings.Join(lines[1:], "")
+		switch {
+		case strings.Contains(first, "HEADERS") || strings.Contains(first, "TRAILERS"):
+			colorHead(&out, lines[1:])
+		case strings.Contains(first, " BODY") && looksLikeJSON(rest):
+			colorJSON(&out, rest)
+		default:
+			out.WriteString(rest)
+		}
+	} else {
+		out.WriteString(colorSummary.ReplaceAllStringFunc(lines[0], func(s string) string {
+			m := colorSummary.FindStringSubmatch(s)
+			return m[1] + methodColor(m[2]) + m[2] + ansiReset + m[3] + statusColor(m[4]) + m[4] + ansiReset
+		}))
+		out.WriteString(strings.Join(lines[1:], ""))
+	}
+	if _, err := io.WriteString(c.w, out.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// colorHead colors a request or status line and dims the header lines
+func colorHead(out *strings.Builder, lines []string) {
+	for i, line := range lines {
+		text, nl := strings.CutSuffix(line, "\n")
+		// dumps end their lines with CRLF
+		text, cr := strings.CutSuffix(text, "\r")
+		switch {
+		case text == "":
+		case i == 0 && colorRequestLine.MatchString(text):
+			m := colorRequestLine.FindStringSubmatch(text)
+			text = ansiBold + methodColor(m[1]) + m[1] + ansiReset + ansiBold + m[2] + ansiReset
+		case i == 0 && colorStatusLine.MatchString(text):
+			m := colorStatusLine.FindStringSubmatch(text)
+			text = ansiBold + m[1] + statusColor(m[2]) + m[2] + m[3] + ansiReset
+		default:
+			text = ansiDim + text + ansiReset
+		}
+		out.WriteString(text)
+		if cr {
+			out.WriteString("\r")
+		}
+		if nl {
+			out.WriteString("\n")
+		}
+	}
+}
+
+func looksLikeJSON(body string) bool {
+	body = strings.TrimSpace(body)
+	return strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[")
+}
+
+// colorJSON highlights JSON text token by token: keys, strings, numbers and
+// literals. It does not validate, so cut or malformed JSON is colored as far
+// as it goes.
+func colorJSON(out *strings.Builder, body string) {
+	b := []byte(body)
+	for i := 0; i < len(b); {
+		switch ch := b[i]; {
+		case ch == '"':
+			j := i + 1
+			for j < len(b) && b[j] != '"' {
+				if b[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(b))
+			color := ansiGreen
+			// a key is followed by a colon
+			if k := bytes.IndexFunc(b[j:], func(r rune) bool { return r != ' ' && r != '\t' }); k >= 0 && b[j+k] == ':' {
+				color = ansiBlue
+			}
+			out.WriteString(color + body[i:j] + ansiReset)
+			i = j
+		case ch == '-' || ch >= '0' && ch <= '9':
+			j := i + 1
+			for j < len(b) && strings.IndexByte("0123456789.eE+-", b[j]) >= 0 {
+				j++
+			}
+			out.WriteString(ansiYellow + body[i:j] + ansiReset)
+			i = j
+		case ch == 't' || ch == 'f' || ch == 'n':
+			j := i
+			for j < len(b) && b[j] >= 'a' && b[j] <= 'z' {
+				j++
+			}
+			out.WriteString(ansiMagenta + body[i:j] + ansiReset)
+			i = j
+		default:
+			out.WriteByte(ch)
+			i++
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// bodyBuffered reports whether req's body is held in memory and may be read
+// again, which is not the case for bodies logged while they are sent or
+// held for a 100 Continue
+func bodyBuffered(req *http.Request) bool {
+	switch req.Body.(type) {
+	case *continueBody, *streamLoggingBody:
+		return false
+	}
+	return true
+}
+
+// compressRequest gzips the body of req for -compress-request. Requests
+// without a body, with a Content-Encoding already, or with a body that is
+// not buffered are sent as they are.
+func (d *dumper) compressRequest(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" || !bodyBuffered(req) {
+		return
+	}
+	logger := d.at(levelDebug, d.loggerFor(req.Context()))
+	raw, err := readScriptBody(&req.Body)
+	if err != nil {
+		return
+	}
+	compressed, err := gzipBytes(raw)
+	if err != nil {
+		d.loggerFor(req.Context()).Printf("Error compressing request body, sent uncompressed: %v", err)
+		return
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Length", strconv.Itoa(len(compressed)))
+	req.Body = readCloser{bytes.NewReader(compressed), http.NoBody}
+	req.ContentLength = int64(len(compressed))
+	req.TransferEncoding = nil
+	logger.Printf("----- REQUEST BODY COMPRESSED for the backend (gzip: %d bytes -> %d sent) -----", len(raw), len(compressed))
+}
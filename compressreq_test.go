@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressRequest(t *testing.T) {
+	const body = `{"name": "widget", "tags": ["a", "b", "c"]}`
+	type received struct {
+		encoding string
+		length   int64
+		body     []byte
+	}
+	got := make(chan received, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Get("Content-Encoding"), r.ContentLength, raw}
+	}))
+	defer backend.Close()
+
+	for _, tc := range []struct {
+		name, encoding string
+		compressed     bool
+	}{
+		{"plain", "", true},
+		{"already encoded", "br", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, logs := newTestDumper()
+			d.compressRequests = true
+			proxy := startProxy(t, d, backend.URL)
+			req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/items", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			proxy.Close()
+
+			r := <-got
+			if !tc.compressed {
+				if r.encoding != tc.encoding || string(r.body) != body {
+					t.Errorf("backend got %q encoded %q, want the body as sent", r.body, r.encoding)
+				}
+				if strings.Contains(logs.String(), "COMPRESSED") {
+					t.Errorf("an encoded body was compressed again:\n%s", logs)
+				}
+				return
+			}
+			if r.encoding != "gzip" || r.length != int64(len(r.body)) {
+				t.Errorf("backend got Content-Encoding %q and Content-Length %d for %d bytes", r.encoding, r.length, len(r.body))
+			}
+			if plain, err := gunzip(r.body); err != nil || string(plain) != body {
+				t.Errorf("backend body gunzips to %q (%v), want %q", plain, err, body)
+			}
+			want := "----- REQUEST BODY COMPRESSED for the backend (gzip: " + strconv.Itoa(len(body)) + " bytes -> " + strconv.Itoa(len(r.body)) + " sent) -----"
+			for _, w := range []string{"----- REQUEST BODY -----\n" + body, want} {
+				if !strings.Contains(logs.String(), w) {
+					t.Errorf("log is missing %q:\n%s", w, logs)
+				}
+			}
+		})
+	}
+}
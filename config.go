@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configAliases are the -config names of the one-letter flags
+var configAliases = map[string]string{
+	"listen":    "l",
+	"target":    "t",
+	"log-level": "v",
+}
+
+// liveFlags are the flags a -config reload applies while the proxy runs;
+// changes to the others, the target and listeners included, are only picked
+// up on restart
+var liveFlags = []string{
+	"route",
+	"match-path", "exclude-path", "match-method", "match-status",
+	"rewrite", "rewrite-file",
+	"redact-headers", "redact-json",
+}
+
+// readConfigFile reads a -config file: a YAML (or JSON) mapping of flag
+// names, without the dash, to their values, with lists for repeatable flags
+func readConfigFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	cfg := map[string][]string{}
+	for key, value := range doc {
+		name := key
+		if alias, ok := configAliases[key]; ok {
+			name = alias
+		}
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		switch v := value.(type) {
+		case []any:
+			if _, ok := f.Value.(*stringList); !ok {
+				return nil, fmt.Errorf("%s: %s takes a single value, not a list", path, key)
+			}
+			for _, item := range v {
+				cfg[name] = append(cfg[name], fmt.Sprint(item))
+			}
+		case map[string]any:
+			return nil, fmt.Errorf("%s: %s takes a value, not a mapping", path, key)
+		case nil:
+			cfg[name] = []string{""}
+		default:
+			cfg[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return cfg, nil
+}
+
+// setFlag sets a flag to values, emptying a repeatable flag first
+func setFlag(name string, values []string) error {
+	f := flag.Lookup(name)
+	if list, ok := f.Value.(*stringList); ok {
+		*list = nil
+	}
+	for _, v := range values {
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", v, name, err)
+		}
+	}
+	return nil
+}
+
+// configReloader applies a -config file over the flags: flags given on the
+// command line win over the file. The file is read again on reload, or by
+// watch when it changes; the liveFlags are then set again, from the file or
+// back to their defaults, and apply is called to use them. A file that fails
+// to load or apply is logged and the current settings stay in use.
+type configReloader struct {
+	path    string
+	logger  *log.Logger
+	apply   func() error
+	cmdline map[string]bool
+
+	mu      sync.Mutex
+	cfg     map[string][]string
+	modTime time.Time
+}
+
+// newConfigReloader sets the flags from path; call it after flag.Parse, and
+// set logger and apply before reloading
+func newConfigReloader(path string) (*configReloader, error) {
+	r := &configReloader{path: path, cmdline: map[string]bool{}}
+	flag.Visit(func(f *flag.Flag) { r.cmdline[f.Name] = true })
+	cfg, modTime, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg)) {
+		if r.cmdline[name] {
+			continue
+		}
+		if err := setFlag(name, cfg[name]); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	r.cfg, r.modTime = cfg, modTime
+	return r, nil
+}
+
+func (r *configReloader) read() (map[string][]string, time.Time, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cfg, err := readConfigFile(r.path)
+	return cfg, info.ModTime(), err
+}
+
+// reload reads the file again and applies its live flags
+func (r *configReloader) reload(reason string) {
+	if err := r.load(); err != nil {
+		r.logger.Printf("Error reloading %s (%s), keeping the current settings: %v", r.path, reason, err)
+	}
+}
+
+func (r *configReloader) load() error {
+	cfg, modTime, err := r.read()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// a broken file is retried once it changes again, not on every tick
+	r.modTime = modTime
+	if err != nil {
+		return err
+	}
+	names := slices.Sorted(maps.Keys(cfg))
+	for name := range r.cfg {
+		if _, ok := cfg[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if !slices.Contains(liveFlags, name) && !r.cmdline[name] && !slices.Equal(cfg[name], r.cfg[name]) {
+			r.logger.Printf("Config: -%s changed in %s, restart the proxy to apply it", name, r.path)
+		}
+	}
+	for _, name := range liveFlags {
+		if r.cmdline[name] {
+			continue
+		}
+		values, ok := cfg[name]
+		if !ok {
+			values = []string{flag.Lookup(name).DefValue}
+			if _, repeatable := flag.Lookup(name).Value.(*stringList); repeatable {
+				values = nil
+			}
+		}
+		if err := setFlag(name, values); err != nil {
+			return err
+		}
+	}
+	if err := r.apply(); err != nil {
+		return err
+	}
+	r.cfg = cfg
+	r.logger.Printf("Reloaded %s", r.path)
+	return nil
+}
+
+// watch reloads the file whenever it changes, checking every
+// certPollInterval
+func (r *configReloader) watch() {
+	for range time.Tick(certPollInterval) {
+		r.poll()
+	}
+}
+
+// poll reloads the file when its modification time changed since it was
+// last read
+func (r *configReloader) poll() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	changed := !info.ModTime().Equal(r.modTime)
+	r.mu.Unlock()
+	if changed {
+		r.reload("file changed")
+	}
+}
+
+// liveRoutes are the -route rules, swapped by -config reloads
+type liveRoutes struct {
+	atomic.Pointer[pathRoutes]
+}
+
+// director sends requests to the target of their current route, and the
+// others through director
+func (l *liveRoutes) director(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		l.Load().director(director)(req)
+	}
+}
+
+// match returns the current route serving r, nil when the request goes to -t
+func (l *liveRoutes) match(r *http.Request) *pathRoute {
+	return l.Load().match(r)
+}
+
+// liveRewrites are the -rewrite rules, swapped by -config reloads
+type liveRewrites struct {
+	atomic.Pointer[rewriteRules]
+}
+
+// director applies the current request rules after director
+func (l *liveRewrites) director(director func(*http.Request), d *dumper) func(*http.Request) {
+	return func(req *http.Request) {
+		l.Load().director(director, d)(req)
+	}
+}
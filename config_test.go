@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configFlags registers the live flags and -t on a fresh command line, as
+// main does, restoring the real one when the test ends
+func configFlags(t *testing.T, args ...string) (routes *stringList, matchStatus, target *string) {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("http-debug-proxy", flag.ContinueOnError)
+	routes = &stringList{}
+	var matchPaths, excludePaths, rewrites stringList
+	flag.Var(routes, "route", "")
+	flag.Var(&matchPaths, "match-path", "")
+	flag.Var(&excludePaths, "exclude-path", "")
+	flag.Var(&rewrites, "rewrite", "")
+	for _, name := range []string{"match-method", "rewrite-file", "redact-headers", "redact-json"} {
+		flag.String(name, "", "")
+	}
+	matchStatus = flag.String("match-status", "", "")
+	target = flag.String("t", "http://localhost:8181", "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return routes, matchStatus, target
+}
+
+// writeConfig replaces the config file, moving its modification time on so
+// that poll sees the change
+func writeConfig(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigReloadAppliesLiveFlags(t *testing.T) {
+	routes, matchStatus, target := configFlags(t, "-match-status=5xx")
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	writeConfig(t, path, "target: http://localhost:8181\nroute:\n  - /auth=http://localhost:8282\nmatch-status: 4xx\n", time.Hour)
+	config, err := newConfigReloader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	var applied []string
+	config.logger = log.New(&logs, "", 0)
+	config.apply = func() error {
+		applied = append(applied, routes.String())
+		return nil
+	}
+	if routes.String() != "/auth=http://localhost:8282" || *matchStatus != "5xx" {
+		t.Fatalf("loaded -route %q and -match-status %q, want the file's route and the command line's status", routes, *matchStatus)
+	}
+
+	// unchanged: nothing to apply
+	config.poll()
+	if len(applied) != 0 {
+		t.Fatalf("an unchanged file was applied %d times", len(applied))
+	}
+
+	writeConfig(t, path, "target: http://localhost:9999\nroute:\n  - /billing=http://localhost:8383\nmatch-status: 2xx\n", time.Minute)
+	config.poll()
+	if strings.Join(applied, "\n") != "/billing=http://localhost:8383" {
+		t.Errorf("applied %q, want the new route once", applied)
+	}
+	if *matchStatus != "5xx" {
+		t.Errorf("-match-status %q, want the command line's 5xx to win over the file", *matchStatus)
+	}
+	if *target != "http://localhost:8181" {
+		t.Errorf("-t was reloaded to %q", *target)
+	}
+	for _, want := range []string{"Config: -t changed in " + path + ", restart the proxy to apply it", "Reloaded " + path} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs.String())
+		}
+	}
+
+	// a route removed from the file goes back to none
+	writeConfig(t, path, "target: http://localhost:9999\n", time.Second)
+	config.reload("SIGHUP")
+	if len(*routes) != 0 || len(applied) != 2 {
+		t.Errorf("after removing the route, -route is %q with %d applies", routes, len(applied))
+	}
+
+	// a broken file keeps the current settings
+	writeConfig(t, path, "route: [unterminated\n", 0)
+	config.reload("SIGHUP")
+	if len(applied) != 2 || !strings.Contains(logs.String(), "Error reloading "+path+" (SIGHUP), keeping the current settings") {
+		t.Errorf("a broken file was applied, or its error not logged:\n%s", logs.String())
+	}
+}
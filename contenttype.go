@@ -0,0 +1,40 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// contentTypeAllowlist is the -accept-content-types list. An entry ending
+// in "/" or "/*" matches every subtype, others the exact media type.
+type contentTypeAllowlist []string
+
+func parseContentTypeAllowlist(s string) contentTypeAllowlist {
+	var list contentTypeAllowlist
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			list = append(list, strings.TrimSuffix(t, "*"))
+		}
+	}
+	return list
+}
+
+// allows reports whether req may be forwarded. Requests without a body are
+// always allowed; ones with a body need an allowed Content-Type.
+func (l contentTypeAllowlist) allows(req *http.Request) bool {
+	if len(l) == 0 || req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range l {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// parseInjectCookies parses the name=value cookies of -inject-cookie
+func parseInjectCookies(specs []string) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	for _, spec := range specs {
+		parsed, err := http.ParseCookie(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		cookies = append(cookies, parsed...)
+	}
+	return cookies, nil
+}
+
+// cookieDirector adds cookies to forwarded requests. AddCookie merges them
+// with any Cookie header sent by the client.
+func cookieDirector(director func(*http.Request), cookies []*http.Cookie) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+	}
+}
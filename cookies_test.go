@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+func TestInjectedCookiesMergeWithTheClientCookies(t *testing.T) {
+	cookies, err := parseInjectCookies([]string{"session=abc", "debug=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = strings.Join(r.Header.Values("Cookie"), "; ")
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL, func(p *debugproxy.Options) {
+		p.Director = cookieDirector(p.Director, cookies)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/", nil)
+	req.Header.Set("Cookie", "theme=dark")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+
+	const want = "theme=dark; session=abc; debug=1"
+	if got != want {
+		t.Errorf("backend got Cookie %q, want %q", got, want)
+	}
+	if !strings.Contains(logs.String(), "Cookie: "+want) {
+		t.Errorf("log is missing the merged Cookie header:\n%s", logs)
+	}
+}
+
+func TestParseInjectCookiesRejectsInvalidCookies(t *testing.T) {
+	if _, err := parseInjectCookies([]string{"no-value"}); err == nil {
+		t.Error("parseInjectCookies accepted a cookie without a value")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// curlSkippedHeaders are left out of curl commands: curl sets the framing
+// headers itself for the decoded body, and sending the request through the
+// proxy again adds the forwarding ones back
+var curlSkippedHeaders = []string{
+	"Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection",
+	"Via", "Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto",
+	"Accept-Encoding",
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// curl returns a shell command sending the captured request again, to the
+// proxy, with its headers as redact shows them and its decoded body. Binary
+// bodies are piped in with printf.
+func (c *capturedExchange) curl(redact *debugproxy.Redactor) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	args := []string{"curl"}
+	switch {
+	case c.method == http.MethodHead:
+		args = append(args, "--head")
+	case c.method == http.MethodGet && len(c.requestBody) == 0, c.method == http.MethodPost && len(c.requestBody) > 0:
+	default:
+		args = append(args, "-X "+shellQuote(c.method))
+	}
+	args = append(args, shellQuote(c.url))
+	header := redact.Header(c.requestHeader)
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		if slices.Contains(curlSkippedHeaders, name) {
+			continue
+		}
+		for _, value := range header[name] {
+			args = append(args, "-H "+shellQuote(name+": "+value))
+		}
+	}
+	if header.Get("Accept-Encoding") != "" {
+		args = append(args, "--compressed")
+	}
+	var stdin string
+	if len(c.requestBody) > 0 {
+		if utf8.Valid(c.requestBody) && bytes.IndexByte(c.requestBody, 0) < 0 {
+			args = append(args, "--data-binary "+shellQuote(string(c.requestBody)))
+		} else {
+			var octal strings.Builder
+			for _, b := range c.requestBody {
+				fmt.Fprintf(&octal, `\%03o`, b)
+			}
+			stdin = "printf " + shellQuote(octal.String()) + " | "
+			args = append(args, "--data-binary @-")
+		}
+	}
+	return stdin + strings.Join(args, " \\\n  ")
+}
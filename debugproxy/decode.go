@@ -0,0 +1,111 @@
+package debugproxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentDecoders undo a single content coding
+var contentDecoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"x-gzip":  func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"deflate": newDeflateReader,
+	"br":      func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+	"zstd":    newZstdReader,
+}
+
+// newZstdReader reads "zstd" bodies; RFC 9659 caps the window at 8MB so
+// decoding does not need more memory than that
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// newDeflateReader reads "deflate" bodies, which should be zlib wrapped but
+// are often sent as a raw deflate stream
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		return zr, nil
+	}
+	return flate.NewReader(bytes.NewReader(data)), nil
+}
+
+// DecodeContentEncoding undoes the codings listed in a Content-Encoding value
+// such as "gzip, br", last applied first. When a coding is unknown or fails
+// to decode, the last successfully decoded form is returned.
+func DecodeContentEncoding(body []byte, encoding string) []byte {
+	decoded, _ := DecodeContentEncodingErr(body, encoding)
+	return decoded
+}
+
+// DecodeContentEncodingErr is DecodeContentEncoding also returning why a
+// known coding failed to decode; unknown codings are not an error
+func DecodeContentEncodingErr(body []byte, encoding string) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+		newReader, ok := contentDecoders[coding]
+		if !ok {
+			return body, nil
+		}
+		r, err := newReader(bytes.NewReader(body))
+		if err != nil {
+			return body, fmt.Errorf("%s decode failed: %w", coding, err)
+		}
+		decoded, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return body, fmt.Errorf("%s decode failed: %w", coding, err)
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+// gzipMagic and zstdMagic start every gzip and zstd stream; brotli has none
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// SniffEncoding guesses the content encoding of a body from its magic bytes
+func SniffEncoding(body []byte) string {
+	switch {
+	case bytes.HasPrefix(body, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(body, zstdMagic):
+		return "zstd"
+	}
+	return ""
+}
+
+// CompressionNote describes how much a decoded body shrank on the wire,
+// e.g. " (gzip: 1200 bytes -> 5400 decoded, 4.50x)"; it is empty for bodies
+// that were not decoded. An empty encoding is one found by SniffEncoding.
+func CompressionNote(raw, decoded []byte, encoding string) string {
+	if len(raw) == 0 || bytes.Equal(raw, decoded) {
+		return ""
+	}
+	if encoding == "" {
+		encoding = "compressed"
+	}
+	return fmt.Sprintf(" (%s: %d bytes -> %d decoded, %.2fx)", strings.ToLower(encoding), len(raw), len(decoded), float64(len(decoded))/float64(len(raw)))
+}
@@ -0,0 +1,111 @@
+package debugproxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const plain = `{"hello":"decoded"}`
+
+func encode(t *testing.T, data []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := newWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	return encode(t, data, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+}
+
+func brotlied(t *testing.T, data []byte) []byte {
+	return encode(t, data, func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) })
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	zlibbed := encode(t, []byte(plain), func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	rawDeflate := encode(t, []byte(plain), func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+	zstded := encode(t, []byte(plain), func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w)
+		return zw
+	})
+	onlyGzipped := gzipped(t, []byte(plain))
+	for _, tc := range []struct {
+		name     string
+		body     []byte
+		encoding string
+		want     []byte
+		wantErr  bool
+	}{
+		{"none", []byte(plain), "", []byte(plain), false},
+		{"identity", []byte(plain), "identity", []byte(plain), false},
+		{"gzip", onlyGzipped, "gzip", []byte(plain), false},
+		{"gzip twice", gzipped(t, onlyGzipped), "gzip, gzip", []byte(plain), false},
+		{"gzip then br", brotlied(t, onlyGzipped), "gzip, br", []byte(plain), false},
+		// br is undone first and fails, leaving the body as received
+		{"gzip labelled gzip, br", onlyGzipped, "gzip, br", onlyGzipped, true},
+		{"zlib deflate", zlibbed, "deflate", []byte(plain), false},
+		{"raw deflate", rawDeflate, "deflate", []byte(plain), false},
+		{"zstd", zstded, "ZSTD", []byte(plain), false},
+		{"unknown coding", onlyGzipped, "compress", onlyGzipped, false},
+		{"broken gzip", []byte("not gzip"), "gzip", []byte("not gzip"), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeContentEncodingErr(tc.body, tc.encoding)
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("decoded %q, want %q", got, tc.want)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("error %v, want error %v", err, tc.wantErr)
+			}
+			if got := DecodeContentEncoding(tc.body, tc.encoding); !bytes.Equal(got, tc.want) {
+				t.Errorf("DecodeContentEncoding %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeKeepsTheLastDecodedForm(t *testing.T) {
+	// the outer gzip decodes, the inner layer labelled br is really plain
+	body := gzipped(t, []byte(plain))
+	got, err := DecodeContentEncodingErr(body, "br, gzip")
+	if err == nil || string(got) != plain {
+		t.Errorf("decoded %q (%v), want %q with the br error", got, err, plain)
+	}
+}
+
+func TestSniffEncoding(t *testing.T) {
+	zstded := encode(t, []byte(plain), func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w)
+		return zw
+	})
+	for name, tc := range map[string]struct {
+		body []byte
+		want string
+	}{
+		"gzip":  {gzipped(t, []byte(plain)), "gzip"},
+		"zstd":  {zstded, "zstd"},
+		"plain": {[]byte(plain), ""},
+		"empty": {nil, ""},
+	} {
+		if got := SniffEncoding(tc.body); got != tc.want {
+			t.Errorf("SniffEncoding(%s) = %q, want %q", name, got, tc.want)
+		}
+	}
+}
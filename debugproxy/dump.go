@@ -0,0 +1,68 @@
+package debugproxy
+
+import (
+	"log"
+	"net/http/httputil"
+	"strconv"
+)
+
+// Dumper logs the exchanges of a Proxy. DumpRequest is called once
+// OnRequest ran, right before the request is sent, and DumpResponse once
+// OnResponse ran, before ModifyResponse. Either may replace the body of the
+// message with one that logs it as it is read.
+type Dumper interface {
+	DumpRequest(*Exchange)
+	DumpResponse(*Exchange)
+}
+
+// bodyRead is how the Proxy read a message body, for the default Dumper
+type bodyRead struct {
+	// raw is the body as sent, when kept is set
+	raw  []byte
+	kept bool
+	// none is set for a request without a body
+	none bool
+}
+
+// logDumper is the default Dumper. It logs the heads, with the secrets of
+// redact masked, and the bodies the Proxy kept, with the labels of the
+// http-debug-proxy command and each message starting with the exchange ID.
+type logDumper struct {
+	logger  *log.Logger
+	redact  *Redactor
+	maxBody int64
+}
+
+func (d *logDumper) logf(ex *Exchange, format string, args ...any) {
+	d.logger.Printf("[#%d] "+format, append([]any{ex.ID}, args...)...)
+}
+
+func (d *logDumper) DumpRequest(ex *Exchange) {
+	if head, err := httputil.DumpRequest(d.redact.Request(ex.Request), false); err == nil {
+		d.logf(ex, "----- REQUEST HEADERS-----\n%s", head)
+	}
+	if !ex.requestRead.none {
+		d.logBody(ex, "REQUEST", ex.requestRead, ex.RequestBody, ex.Request.Header.Get("Content-Encoding"), ex.Request.Header.Get("Content-Type"))
+	}
+}
+
+func (d *logDumper) DumpResponse(ex *Exchange) {
+	if head, err := httputil.DumpResponse(d.redact.Response(ex.Response), false); err == nil {
+		after := strconv.FormatFloat(float64(ex.Duration.Microseconds())/1000, 'f', 3, 64)
+		d.logf(ex, "----- RESPONSE HEADERS (after %sms) -----\n%s", after, head)
+	}
+	d.logBody(ex, "RESPONSE", ex.responseRead, ex.ResponseBody, ex.Response.Header.Get("Content-Encoding"), ex.Response.Header.Get("Content-Type"))
+}
+
+// logBody logs a decoded body, or its size when it was not kept
+func (d *logDumper) logBody(ex *Exchange, label string, read bodyRead, decoded []byte, encoding, contentType string) {
+	switch {
+	case !read.kept && d.maxBody < 0:
+		d.logf(ex, "----- %s BODY (streamed, not logged) -----", label)
+	case !read.kept:
+		d.logf(ex, "----- %s BODY (over %d bytes, not logged) -----", label, d.maxBody)
+	case len(read.raw) == 0:
+	default:
+		d.logf(ex, "----- %s BODY%s -----\n%s", label, CompressionNote(read.raw, decoded, encoding), d.redact.JSONBody(decoded, contentType))
+	}
+}
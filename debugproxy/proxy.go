@@ -0,0 +1,267 @@
+// Package debugproxy is the core of http-debug-proxy as a library: a reverse
+// proxy that dumps every exchange, request and response headers and bodies
+// decoded from their Content-Encoding with secrets masked by a Redactor, and
+// hands each exchange to hooks, so test servers can embed the dumping and
+// capture programmatically. The command plugs its own Dumper into the same
+// Proxy.
+//
+//	p, err := debugproxy.New(debugproxy.Options{
+//		Target: target,
+//		OnResponse: func(ex *debugproxy.Exchange) error {
+//			ex.Response.Header.Set("X-Seen", "1")
+//			return nil
+//		},
+//	})
+//	http.ListenAndServe(":9191", p)
+package debugproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxBody is the Options.MaxBody used when it is 0
+const DefaultMaxBody = 10 << 20
+
+// Exchange is a request going through the proxy and, once it came back, its
+// response. Hooks may change the request before it is forwarded and the
+// status and headers of the response before it is returned; the bodies are
+// for inspection.
+type Exchange struct {
+	// ID numbers the exchanges of a Proxy from 1
+	ID    uint64
+	Start time.Time
+	// Request is the request forwarded to the target
+	Request *http.Request
+	// RequestBody is the request body decoded from its Content-Encoding,
+	// nil when there is none, it is larger than Options.MaxBody or bodies
+	// stream through
+	RequestBody []byte
+	// Response is nil until the target answered, and when it failed
+	Response *http.Response
+	// ResponseBody is the decoded response body, as RequestBody
+	ResponseBody []byte
+	// Err is why the exchange failed, set when OnDone is called
+	Err error
+	// Duration is the time until the response was read, set for OnDone
+	Duration time.Duration
+
+	requestRead, responseRead bodyRead
+}
+
+// Options configure a Proxy
+type Options struct {
+	// Target is the URL requests are forwarded to; required unless Director
+	// is set
+	Target *url.URL
+	// Transport sends the requests to Target; http.DefaultTransport when nil
+	Transport http.RoundTripper
+	// Logger receives the dumps of the default Dumper and the errors;
+	// log.Default() when nil
+	Logger *log.Logger
+	// Redactor masks secrets in the dumps of the default Dumper; nil masks
+	// the DefaultRedactedHeaders
+	Redactor *Redactor
+	// Dumper logs the exchanges in place of the default, which logs the
+	// heads and the bodies kept to Logger
+	Dumper Dumper
+	// NoDump turns the dumps off, leaving the hooks
+	NoDump bool
+	// MaxBody bounds the bodies kept and logged; larger ones stream through
+	// and are only counted (DefaultMaxBody when 0). Below 0, no body is
+	// read: they all stream through, for a Dumper that reads them itself.
+	MaxBody int64
+	// Director, when set, rewrites the request for the target in place of
+	// the default, which sends it to Target with the X-Forwarded headers
+	Director func(*http.Request)
+	// ModifyResponse, when set, is called with the response once the hooks
+	// ran and it was dumped. An error is handled as a failed exchange.
+	ModifyResponse func(*http.Response) error
+	// ErrorHandler answers the client when the exchange failed, once
+	// Exchange.Err is set; the default logs the error and answers 502
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+	// FlushInterval is how often the response is flushed to the client
+	// while it is copied, as for httputil.ReverseProxy
+	FlushInterval time.Duration
+	// OnRequest is called before the request is forwarded. An error answers
+	// the client with 502 instead.
+	OnRequest func(*Exchange) error
+	// OnResponse is called with the response before it is returned. An error
+	// answers the client with 502 instead.
+	OnResponse func(*Exchange) error
+	// OnDone is called once the exchange is over, also when it failed
+	OnDone func(*Exchange)
+}
+
+// Proxy is an http.Handler forwarding to Options.Target
+type Proxy struct {
+	opts Options
+	rp   *httputil.ReverseProxy
+	seq  atomic.Uint64
+	// dumper is nil with NoDump
+	dumper Dumper
+}
+
+type exchangeKey struct{}
+
+// New returns a Proxy for opts
+func New(opts Options) (*Proxy, error) {
+	if opts.Director == nil && (opts.Target == nil || opts.Target.Scheme == "" || opts.Target.Host == "") {
+		return nil, errors.New("debugproxy: Options.Target must be an absolute URL")
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+	if opts.MaxBody == 0 {
+		opts.MaxBody = DefaultMaxBody
+	}
+	if opts.Redactor == nil {
+		r := ParseRedactor(DefaultRedactedHeaders, "")
+		opts.Redactor = &r
+	}
+	p := &Proxy{opts: opts, dumper: opts.Dumper}
+	if p.dumper == nil {
+		p.dumper = &logDumper{logger: opts.Logger, redact: opts.Redactor, maxBody: opts.MaxBody}
+	}
+	if opts.NoDump {
+		p.dumper = nil
+	}
+	p.rp = &httputil.ReverseProxy{
+		Director:       opts.Director,
+		Transport:      &transport{p: p},
+		ModifyResponse: opts.ModifyResponse,
+		FlushInterval:  opts.FlushInterval,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if ex, ok := r.Context().Value(exchangeKey{}).(*Exchange); ok {
+				ex.Err = err
+			}
+			if opts.ErrorHandler != nil {
+				opts.ErrorHandler(w, r, err)
+				return
+			}
+			p.logf("Error forwarding %s %s: %v", r.Method, r.URL.RequestURI(), err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	if opts.Director == nil {
+		p.rp.Rewrite = func(r *httputil.ProxyRequest) {
+			r.SetURL(opts.Target)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		}
+	}
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ex := &Exchange{ID: p.seq.Add(1), Start: time.Now()}
+	defer func() {
+		if p.opts.OnDone != nil {
+			if ex.Duration == 0 {
+				ex.Duration = time.Since(ex.Start)
+			}
+			p.opts.OnDone(ex)
+		}
+	}()
+	p.rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex)))
+}
+
+func (p *Proxy) logf(format string, args ...any) {
+	if !p.opts.NoDump {
+		p.opts.Logger.Printf(format, args...)
+	}
+}
+
+// readBody reads up to MaxBody of body. It returns the bytes read and the
+// body to forward in its place, which still streams the rest of a larger
+// one; kept is false in that case, and when MaxBody is below 0.
+func (p *Proxy) readBody(body io.ReadCloser) (data []byte, rest io.ReadCloser, kept bool, err error) {
+	if p.opts.MaxBody < 0 {
+		return nil, body, false, nil
+	}
+	data, err = io.ReadAll(io.LimitReader(body, p.opts.MaxBody+1))
+	if err != nil {
+		body.Close()
+		return nil, nil, false, err
+	}
+	if int64(len(data)) > p.opts.MaxBody {
+		return nil, readCloser{io.MultiReader(bytes.NewReader(data), body), body}, false, nil
+	}
+	body.Close()
+	return data, io.NopCloser(bytes.NewReader(data)), true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// transport dumps the exchange and runs the hooks around the round trip
+type transport struct {
+	p *Proxy
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.p
+	ex, _ := req.Context().Value(exchangeKey{}).(*Exchange)
+	if ex == nil {
+		return p.opts.Transport.RoundTrip(req)
+	}
+	ex.Request = req
+	ex.requestRead.none = req.Body == nil || req.Body == http.NoBody
+	if !ex.requestRead.none {
+		raw, body, kept, err := p.readBody(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+		req.Body, ex.requestRead.raw, ex.requestRead.kept = body, raw, kept
+		if kept {
+			ex.RequestBody = DecodeContentEncoding(raw, req.Header.Get("Content-Encoding"))
+		}
+	}
+	if p.opts.OnRequest != nil {
+		if err := p.opts.OnRequest(ex); err != nil {
+			return nil, fmt.Errorf("OnRequest: %w", err)
+		}
+	}
+	if p.dumper != nil {
+		p.dumper.DumpRequest(ex)
+	}
+	resp, err := p.opts.Transport.RoundTrip(ex.Request)
+	if err != nil {
+		return nil, err
+	}
+	ex.Response = resp
+	raw, body, kept, err := p.readBody(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	resp.Body, ex.responseRead.raw, ex.responseRead.kept = body, raw, kept
+	if kept {
+		ex.ResponseBody = DecodeContentEncoding(raw, resp.Header.Get("Content-Encoding"))
+	}
+	ex.Duration = time.Since(ex.Start)
+	if p.opts.OnResponse != nil {
+		if err := p.opts.OnResponse(ex); err != nil {
+			resp.Body.Close()
+			ex.Response = nil
+			return nil, fmt.Errorf("OnResponse: %w", err)
+		}
+	}
+	if p.dumper != nil {
+		p.dumper.DumpResponse(ex)
+	}
+	return ex.Response, nil
+}
@@ -0,0 +1,354 @@
+package debugproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startProxy serves a Proxy for opts in front of backend, logging into the
+// returned buffer unless opts has a Logger. Close the server before reading
+// the log.
+func startProxy(t *testing.T, backend string, opts Options) (*httptest.Server, *bytes.Buffer) {
+	t.Helper()
+	target, err := url.Parse(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	opts.Target = target
+	if opts.Logger == nil {
+		opts.Logger = log.New(&logs, "", 0)
+	}
+	p, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	return srv, &logs
+}
+
+func TestHooksSeeDecodedBodies(t *testing.T) {
+	var gotHook string
+	var gotBody []byte
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHook = r.Header.Get("X-Hook")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipped(t, []byte(`{"answer":"decoded"}`)))
+	}))
+	defer backend.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	var done *Exchange
+	proxy, logs := startProxy(t, backend.URL, Options{
+		OnRequest: func(ex *Exchange) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "request "+string(ex.RequestBody))
+			ex.Request.Header.Set("X-Hook", "set by OnRequest")
+			return nil
+		},
+		OnResponse: func(ex *Exchange) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "response "+string(ex.ResponseBody))
+			ex.Response.Header.Set("X-Seen", "1")
+			return nil
+		},
+		OnDone: func(ex *Exchange) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, "done")
+			done = ex
+		},
+	})
+
+	sent := gzipped(t, []byte(plain))
+	req, err := http.NewRequest(http.MethodPost, proxy.URL+"/items", bytes.NewReader(sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"request " + plain, `response {"answer":"decoded"}`, "done"}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hooks were called as\n%s\nwant\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	if gotHook != "set by OnRequest" {
+		t.Errorf("backend got X-Hook %q", gotHook)
+	}
+	if !bytes.Equal(gotBody, sent) {
+		t.Error("backend did not get the request body as sent")
+	}
+	if resp.Header.Get("X-Seen") != "1" {
+		t.Error("the OnResponse header did not reach the client")
+	}
+	if got := DecodeContentEncoding(raw, resp.Header.Get("Content-Encoding")); string(got) != `{"answer":"decoded"}` {
+		t.Errorf("client got body %q", got)
+	}
+	if done == nil || done.ID != 1 || done.Err != nil || done.Duration <= 0 || done.Response == nil {
+		t.Errorf("OnDone got %+v", done)
+	}
+	for _, s := range []string{
+		"[#1] ----- REQUEST HEADERS-----\nPOST /items HTTP/1.1",
+		"[#1] ----- REQUEST BODY (gzip: ",
+		plain,
+		"[#1] ----- RESPONSE HEADERS (after ",
+		`{"answer":"decoded"}`,
+	} {
+		if !strings.Contains(logs.String(), s) {
+			t.Errorf("log is missing %q:\n%s", s, logs)
+		}
+	}
+}
+
+func TestHookErrorsAnswer502(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     func(error) Options
+		contacts bool
+	}{
+		{"OnRequest", func(err error) Options { return Options{OnRequest: func(*Exchange) error { return err }} }, false},
+		{"OnResponse", func(err error) Options { return Options{OnResponse: func(*Exchange) error { return err }} }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contacted := false
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contacted = true
+			}))
+			defer backend.Close()
+			refused := errors.New("refused by the hook")
+			opts := tc.opts(refused)
+			var doneErr error
+			opts.OnDone = func(ex *Exchange) { doneErr = ex.Err }
+			proxy, _ := startProxy(t, backend.URL, opts)
+
+			resp, err := http.Get(proxy.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			proxy.Close()
+
+			if resp.StatusCode != http.StatusBadGateway {
+				t.Errorf("status %d, want 502", resp.StatusCode)
+			}
+			if contacted != tc.contacts {
+				t.Errorf("backend contacted: %v, want %v", contacted, tc.contacts)
+			}
+			if !errors.Is(doneErr, refused) {
+				t.Errorf("OnDone got Err %v, want the hook's", doneErr)
+			}
+		})
+	}
+}
+
+func TestDumpsAreRedacted(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=s3cr3t")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"user":"ann","token":"t0k3n"}`)
+	}))
+	defer backend.Close()
+	for _, tc := range []struct {
+		name     string
+		redactor *Redactor
+		hidden   []string
+		shown    []string
+	}{
+		{"default", nil, []string{"Bearer k3y", "session=s3cr3t"}, []string{"Authorization: [REDACTED]", "X-Api-Key: k3y", "t0k3n"}},
+		{"custom", &Redactor{}, nil, []string{"Bearer k3y", "session=s3cr3t"}},
+		{"fields", redactorFor("X-Api-Key", "token"), []string{"X-Api-Key: k3y", "t0k3n"}, []string{`"token":"[REDACTED]"`, "Bearer k3y"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, logs := startProxy(t, backend.URL, Options{Redactor: tc.redactor})
+			req, err := http.NewRequest(http.MethodGet, proxy.URL+"/me", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer k3y")
+			req.Header.Set("X-Api-Key", "k3y")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			proxy.Close()
+
+			for _, s := range tc.hidden {
+				if strings.Contains(logs.String(), s) {
+					t.Errorf("log has %q:\n%s", s, logs)
+				}
+			}
+			for _, s := range tc.shown {
+				if !strings.Contains(logs.String(), s) {
+					t.Errorf("log is missing %q:\n%s", s, logs)
+				}
+			}
+		})
+	}
+}
+
+func redactorFor(headers, fields string) *Redactor {
+	r := ParseRedactor(headers, fields)
+	return &r
+}
+
+// orderDumper records when it is called among the hooks
+type orderDumper struct {
+	calls *[]string
+}
+
+func (d orderDumper) DumpRequest(ex *Exchange) {
+	*d.calls = append(*d.calls, "dump request "+ex.Request.Header.Get("X-Hook"))
+}
+
+func (d orderDumper) DumpResponse(ex *Exchange) {
+	*d.calls = append(*d.calls, "dump response "+ex.Response.Header.Get("X-Hook"))
+}
+
+func TestCustomDumper(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	var calls []string
+	proxy, logs := startProxy(t, backend.URL, Options{
+		Dumper: orderDumper{&calls},
+		OnRequest: func(ex *Exchange) error {
+			calls = append(calls, "request")
+			ex.Request.Header.Set("X-Hook", "set")
+			return nil
+		},
+		OnResponse: func(ex *Exchange) error {
+			calls = append(calls, "response")
+			ex.Response.Header.Set("X-Hook", "set")
+			return nil
+		},
+		ModifyResponse: func(*http.Response) error {
+			calls = append(calls, "modify")
+			return nil
+		},
+	})
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+
+	want := []string{"request", "dump request set", "response", "dump response set", "modify"}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("called as\n%s\nwant\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	if logs.Len() != 0 {
+		t.Errorf("the default Dumper logged with a custom one set:\n%s", logs)
+	}
+}
+
+func TestStreamingWithADirector(t *testing.T) {
+	var gotPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	var hooked *Exchange
+	proxy, logs := startProxy(t, backend.URL, Options{
+		MaxBody: -1,
+		Director: func(r *http.Request) {
+			r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+			r.URL.Path = "/v2" + r.URL.Path
+		},
+		OnResponse: func(ex *Exchange) error {
+			hooked = ex
+			return nil
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Set("X-Modified", "1")
+			return nil
+		},
+	})
+	resp, err := http.Post(proxy.URL+"/items", "text/plain", strings.NewReader("streamed body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if gotPath != "/v2/items" {
+		t.Errorf("backend got path %q, want the Director's /v2/items", gotPath)
+	}
+	if string(body) != "streamed body" || resp.Header.Get("X-Modified") != "1" {
+		t.Errorf("client got %q, X-Modified %q", body, resp.Header.Get("X-Modified"))
+	}
+	if hooked == nil || hooked.RequestBody != nil || hooked.ResponseBody != nil {
+		t.Errorf("OnResponse got %+v, want an exchange without bodies", hooked)
+	}
+	for _, s := range []string{"----- REQUEST BODY (streamed, not logged) -----", "----- RESPONSE BODY (streamed, not logged) -----"} {
+		if !strings.Contains(logs.String(), s) {
+			t.Errorf("log is missing %q:\n%s", s, logs)
+		}
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var exErr error
+	proxy, _ := startProxy(t, "http://127.0.0.1:1", Options{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if ex, ok := r.Context().Value(exchangeKey{}).(*Exchange); ok {
+				exErr = ex.Err
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	})
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want the ErrorHandler's 503", resp.StatusCode)
+	}
+	if exErr == nil {
+		t.Error("Exchange.Err was not set before ErrorHandler")
+	}
+}
+
+func TestNewRequiresATarget(t *testing.T) {
+	for _, target := range []*url.URL{nil, {Path: "/relative"}} {
+		if _, err := New(Options{Target: target}); err == nil {
+			t.Errorf("New accepted Target %v", target)
+		}
+	}
+	if _, err := New(Options{Director: func(*http.Request) {}}); err != nil {
+		t.Errorf("New with a Director and no Target: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package debugproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// DefaultRedactedHeaders are the headers masked when Options.Redactor is nil,
+// and by the command unless -redact-headers is given
+const DefaultRedactedHeaders = "Authorization,Proxy-Authorization,Cookie,Set-Cookie"
+
+// Redacted replaces secret values in the dumps
+const Redacted = "[REDACTED]"
+
+// Redactor masks the values of secret headers, and of secret fields of
+// JSON bodies, in the dumps. Forwarded traffic is never changed. A zero
+// Redactor leaves everything as it is.
+type Redactor struct {
+	// headers holds canonical header names
+	headers map[string]bool
+	// fields holds lower case JSON field names, matched at any depth
+	fields map[string]bool
+}
+
+// ParseRedactor returns a Redactor for comma-separated lists of header
+// names and JSON field names
+func ParseRedactor(headers, fields string) Redactor {
+	var r Redactor
+	for _, name := range strings.Split(headers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if r.headers == nil {
+				r.headers = map[string]bool{}
+			}
+			r.headers[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	for _, name := range strings.Split(fields, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if r.fields == nil {
+				r.fields = map[string]bool{}
+			}
+			r.fields[strings.ToLower(name)] = true
+		}
+	}
+	return r
+}
+
+// With returns a redactor masking the secrets of both r and o
+func (r Redactor) With(o Redactor) *Redactor {
+	m := Redactor{headers: maps.Clone(r.headers), fields: maps.Clone(r.fields)}
+	for name := range o.headers {
+		if m.headers == nil {
+			m.headers = map[string]bool{}
+		}
+		m.headers[name] = true
+	}
+	for name := range o.fields {
+		if m.fields == nil {
+			m.fields = map[string]bool{}
+		}
+		m.fields[name] = true
+	}
+	return &m
+}
+
+// MasksHeader reports whether the values of the header name are masked
+func (r Redactor) MasksHeader(name string) bool {
+	return r.headers[http.CanonicalHeaderKey(name)]
+}
+
+// MasksField reports whether the values of the JSON field name are masked
+func (r Redactor) MasksField(name string) bool {
+	return r.fields[strings.ToLower(name)]
+}
+
+// Header returns h, or a copy of it with the values of secret headers
+// masked when it has any
+func (r Redactor) Header(h http.Header) http.Header {
+	var masked http.Header
+	for name, values := range h {
+		if !r.headers[name] {
+			continue
+		}
+		if masked == nil {
+			masked = h.Clone()
+		}
+		masked[name] = make([]string, len(values))
+		for i := range values {
+			masked[name][i] = Redacted
+		}
+	}
+	if masked == nil {
+		return h
+	}
+	return masked
+}
+
+// Request returns a shallow copy of req with secret headers masked, for
+// dumping the request head
+func (r Redactor) Request(req *http.Request) *http.Request {
+	if len(r.headers) == 0 {
+		return req
+	}
+	c := *req
+	c.Header = r.Header(req.Header)
+	return &c
+}
+
+// Response returns a shallow copy of resp with secret headers masked
+func (r Redactor) Response(resp *http.Response) *http.Response {
+	if len(r.headers) == 0 {
+		return resp
+	}
+	c := *resp
+	c.Header = r.Header(resp.Header)
+	return &c
+}
+
+// Head masks secret headers in a verbatim request head
+func (r Redactor) Head(head []byte) []byte {
+	if len(r.headers) == 0 {
+		return head
+	}
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	scanner.Buffer(nil, len(head)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, _, ok := strings.Cut(line, ":"); ok && r.headers[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] {
+			line = name + ": " + Redacted
+		}
+		out.WriteString(line + "\r\n")
+	}
+	return out.Bytes()
+}
+
+// JSONBody masks the values of secret fields of a JSON body, keeping the
+// order of the fields. A body without any, or that is not JSON, is
+// returned as is.
+func (r Redactor) JSONBody(body []byte, contentType string) []byte {
+	if len(r.fields) == 0 || !IsJSON(contentType) {
+		return body
+	}
+	var out bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	changed, err := r.jsonValue(&out, dec)
+	if err != nil || !changed {
+		return body
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		// trailing data, such as a second document
+		return body
+	}
+	return out.Bytes()
+}
+
+// jsonValue copies the next value of dec to out, masking secret fields in
+// it, and reports whether it masked any
+func (r Redactor) jsonValue(out *bytes.Buffer, dec *json.Decoder) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return false, writeJSONToken(out, tok)
+	}
+	changed := false
+	out.WriteRune(rune(delim))
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			name, _ := key.(string)
+			if err := writeJSONToken(out, name); err != nil {
+				return false, err
+			}
+			out.WriteByte(':')
+			if r.fields[strings.ToLower(name)] {
+				var skipped json.RawMessage
+				if err := dec.Decode(&skipped); err != nil {
+					return false, err
+				}
+				out.WriteString(`"` + Redacted + `"`)
+				changed = true
+				continue
+			}
+		}
+		c, err := r.jsonValue(out, dec)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || c
+	}
+	end, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	out.WriteRune(rune(end.(json.Delim)))
+	return changed, nil
+}
+
+// IsJSON reports whether a content type carries a JSON document
+func IsJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// writeJSONToken writes a scalar as JSON, leaving <, > and & as they are
+func writeJSONToken(out *bytes.Buffer, v any) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	out.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+	return nil
+}
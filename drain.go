@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// requestTracker counts the requests being handled, so shutdown can report
+// them and wait for their dumps to be written before exports are flushed
+type requestTracker struct {
+	wg sync.WaitGroup
+	n  atomic.Int64
+}
+
+func (t *requestTracker) begin() {
+	t.wg.Add(1)
+	t.n.Add(1)
+}
+
+func (t *requestTracker) end() {
+	t.n.Add(-1)
+	t.wg.Done()
+}
+
+// wait blocks until no request is handled, reporting false if ctx ends first
+func (t *requestTracker) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// streamTracker keeps the long-lived exchanges, streamed responses and
+// upgraded connections, so shutdown can wait for them and then close the
+// ones still open. Upgraded connections are hijacked from the server, so
+// http.Server.Shutdown does not wait for them on its own.
+type streamTracker struct {
+	mu     sync.Mutex
+	active map[*exchange]io.Closer
+	// changed is closed and replaced whenever an exchange ends
+	changed chan struct{}
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{active: map[*exchange]io.Closer{}, changed: make(chan struct{})}
+}
+
+// add tracks ex until remove; closing c ends the exchange
+func (t *streamTracker) add(ex *exchange, c io.Closer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[ex] = c
+}
+
+func (t *streamTracker) remove(ex *exchange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.active[ex]; ok {
+		delete(t.active, ex)
+		close(t.changed)
+		t.changed = make(chan struct{})
+	}
+}
+
+// wait blocks until no exchange is tracked, reporting false if ctx ends first
+func (t *streamTracker) wait(ctx context.Context) bool {
+	for {
+		t.mu.Lock()
+		n, changed := len(t.active), t.changed
+		t.mu.Unlock()
+		if n == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// closeAll force-closes the tracked exchanges, logging each of them
+func (t *streamTracker) closeAll(logger *log.Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ex, c := range t.active {
+		logger.Printf("Drain timeout: closing streaming exchange #%s %s %s, open for %s", ex.idString(), ex.method, ex.clientURI, time.Since(ex.start).Round(time.Millisecond))
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// dropper picks the share of responses whose connection is cut abruptly
+type dropper struct {
+	rate float64
+	mu   sync.Mutex
+	rng  *rand.Rand
+}
+
+func newDropper(rate float64, seed uint64) *dropper {
+	return &dropper{rate: rate, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (d *dropper) pick() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rng.Float64() < d.rate
+}
+
+// dropWriter sends half of the first body write to the client, then aborts
+// the handler so the server closes the connection mid-response, as a
+// network failure would
+type dropWriter struct {
+	http.ResponseWriter
+	logger *log.Logger
+	// what names the exchange in the log line
+	what string
+}
+
+func (w *dropWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p[:len(p)/2])
+	if err != nil {
+		return n, err
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+	w.logger.Printf("DROPPED connection of %s after %d body bytes (-drop-rate)", w.what, n)
+	panic(http.ErrAbortHandler)
+}
+
+func (w *dropWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxDupEntries bounds the memory used by duplicate detection
+const maxDupEntries = 10000
+
+// dupEntry tracks repeated arrivals of the same request
+type dupEntry struct {
+	lastSeen time.Time
+	count    int
+}
+
+// dupDetector counts identical requests (method, URI and body) arriving
+// within window of each other
+type dupDetector struct {
+	window    time.Duration
+	mu        sync.Mutex
+	entries   map[[sha256.Size]byte]*dupEntry
+	lastSweep time.Time
+}
+
+func newDupDetector(window time.Duration) *dupDetector {
+	return &dupDetector{
+		window:  window,
+		entries: make(map[[sha256.Size]byte]*dupEntry),
+	}
+}
+
+// observe records a request and returns how many times it was seen in a row,
+// each arrival within the window of the previous one
+func (dd *dupDetector) observe(method, uri string, body []byte) int {
+	h := sha256.New()
+	_, _ = io.WriteString(h, method)
+	h.Write([]byte{0})
+	_, _ = io.WriteString(h, uri)
+	h.Write([]byte{0})
+	h.Write(body)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	now := time.Now()
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	dd.evict(now)
+	e, ok := dd.entries[key]
+	if !ok || now.Sub(e.lastSeen) > dd.window {
+		e = &dupEntry{}
+		dd.entries[key] = e
+	}
+	e.lastSeen = now
+	e.count++
+	return e.count
+}
+
+// evict drops entries that fell out of the window, and the oldest entries
+// when the table is still full
+func (dd *dupDetector) evict(now time.Time) {
+	if now.Sub(dd.lastSweep) < dd.window && len(dd.entries) < maxDupEntries {
+		return
+	}
+	dd.lastSweep = now
+	for k, e := range dd.entries {
+		if now.Sub(e.lastSeen) > dd.window {
+			delete(dd.entries, k)
+		}
+	}
+	for len(dd.entries) >= maxDupEntries {
+		var oldestKey [sha256.Size]byte
+		var oldest time.Time
+		for k, e := range dd.entries {
+			if oldest.IsZero() || e.lastSeen.Before(oldest) {
+				oldestKey, oldest = k, e.lastSeen
+			}
+		}
+		delete(dd.entries, oldestKey)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		assume, header, want string
+	}{
+		{"", "gzip", "gzip"},
+		{"", "", ""},
+		{"auto", "", "auto"},
+		{"auto", "br", "br"},
+		{"gzip", "", "gzip"},
+		{"gzip", "identity", "gzip"},
+	} {
+		d := &dumper{assumeEncoding: tc.assume}
+		if got := d.bodyEncoding(tc.header); got != tc.want {
+			t.Errorf("-assume-encoding %q with Content-Encoding %q: %q, want %q", tc.assume, tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestAssumedEncodingDecodesTheLogOnly(t *testing.T) {
+	const text = `{"unlabelled":"gzip"}`
+	gz, err := gzipBytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, assume := range []string{"gzip", "auto"} {
+		t.Run(assume, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// gzipped, but without a Content-Encoding
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write(gz)
+			}))
+			defer backend.Close()
+			d, logs := newTestDumper()
+			d.assumeEncoding = assume
+			proxy := startProxy(t, d, backend.URL)
+			resp, err := http.Get(proxy.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			proxy.Close()
+
+			if !bytes.Equal(got, gz) {
+				t.Errorf("client got % x, want the gzipped bytes unchanged", got)
+			}
+			if !strings.Contains(logs.String(), text) {
+				t.Errorf("log is missing the decoded body:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestDecodedChainRestoresTheRawBody(t *testing.T) {
+	const text = "twice gzipped"
+	once, _ := gzipBytes([]byte(text))
+	twice, _ := gzipBytes(once)
+	raw, decoded, restore, err := readAndMaybeDecompressBody(io.NopCloser(bytes.NewReader(twice)), "gzip, gzip", "RESPONSE", discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != text {
+		t.Errorf("decoded %q, want %q", decoded, text)
+	}
+	restored, _ := io.ReadAll(restore())
+	if !bytes.Equal(raw, twice) || !bytes.Equal(restored, twice) {
+		t.Errorf("raw % x and restored % x, want the body as received", raw, restored)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// maxMirrorBytes bounds the log output kept per exchange for -errors-to-stderr
+const maxMirrorBytes = 1 << 20
+
+// errorMirror copies the log output of failed exchanges to a second writer,
+// typically stderr while the log goes to -log-file. The output of every
+// exchange is kept until its status is known, then copied or dropped.
+type errorMirror struct {
+	out       io.Writer
+	threshold int
+}
+
+// mirrorBuffer keeps up to maxMirrorBytes of an exchange's log output
+type mirrorBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *mirrorBuffer) Write(p []byte) (int, error) {
+	if room := maxMirrorBytes - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// finish copies the kept output to the mirror when status is at or above
+// the threshold
+func (m *errorMirror) finish(b *mirrorBuffer, status int) {
+	if status < m.threshold {
+		return
+	}
+	_, _ = m.out.Write(b.buf.Bytes())
+	if b.truncated {
+		_, _ = io.WriteString(m.out, "... [log output of this exchange truncated for -errors-to-stderr]\n")
+	}
+}
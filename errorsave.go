@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// errorSaver writes the decoded bodies of exchanges answered with an error
+// status to files, for bug reports. Other exchanges never touch the disk.
+type errorSaver struct {
+	dir       string
+	minStatus int
+	logger    *log.Logger
+}
+
+func newErrorSaver(dir string, minStatus int, logger *log.Logger) (*errorSaver, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &errorSaver{dir: dir, minStatus: minStatus, logger: logger}, nil
+}
+
+// save writes <time>-<id>-request.body and <time>-<id>-response.body when
+// the exchange's status is at least minStatus
+func (s *errorSaver) save(c *capturedExchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status < s.minStatus {
+		return
+	}
+	base := filepath.Join(s.dir, fmt.Sprintf("%s-%06d", c.start.Format("20060102T150405"), c.id))
+	for _, f := range []struct {
+		suffix string
+		body   []byte
+	}{
+		{"-request.body", c.requestBody},
+		{"-response.body", c.responseBody},
+	} {
+		if err := os.WriteFile(base+f.suffix, f.body, 0o644); err != nil {
+			s.logger.Printf("Error saving body of exchange #%d: %v", c.id, err)
+			return
+		}
+	}
+	s.logger.Printf("Saved bodies of exchange #%d (status %d) to %s-{request,response}.body", c.id, c.status, base)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// exchange holds the logging state of a single request/response pair. It is
+// attached to the incoming request context, which the reverse proxy carries
+// over to the outgoing request, so the transport and ModifyResponse see the
+// same exchange.
+type exchange struct {
+	// id identifies the exchange in the logs
+	id uint64
+	// requestID, with -request-id-header, is the client's request ID or the
+	// one generated for it, and identifies the exchange in the logs instead of id
+	requestID string
+	// start is when the proxy received the request
+	start time.Time
+	// logger receives the exchange's dump lines
+	logger *log.Logger
+	// held buffers the request dump until the response decides whether the
+	// exchange is logged in full; nil when dumps are written right away
+	held *bytes.Buffer
+	// grouped is set while held keeps a -group-logs dump until the exchange finishes
+	grouped bool
+	// shadows counts the -shadow comparisons still to be logged, which a
+	// grouped dump waits for so they are part of it
+	shadows sync.WaitGroup
+	// sampledOut is set for exchanges skipped by -log-every, only summarized
+	sampledOut bool
+	// filteredOut is set for exchanges left out of the log by the -match and
+	// -exclude filters
+	filteredOut bool
+	// logMode is how much of the exchange -route-log dumps
+	logMode routeLogMode
+	// method is the request method
+	method string
+	// summarized is set once a one line summary of the exchange was logged
+	summarized bool
+	// canary is set when the exchange is routed to -canary-target
+	canary bool
+	// route is the pattern of the route that served the request
+	route string
+	// matchedRoute is the -route serving the request, nil for -t
+	matchedRoute *pathRoute
+	// redact masks the secrets of the exchange when its route adds its own to
+	// -redact-headers and -redact-json, nil to use the global ones
+	redact *debugproxy.Redactor
+	// clientURI is the request URI as sent by the client, before rewrites
+	clientURI string
+	// clientHost and clientScheme are the proxy address as seen by the client
+	clientHost   string
+	clientScheme string
+	// clientTrailer is the trailer map of the client's request, filled in
+	// once its body is read to EOF
+	clientTrailer http.Header
+	// prefix is put in front of every log message of the exchange
+	prefix string
+	// mirror keeps the exchange's log output for -errors-to-stderr
+	mirror *mirrorBuffer
+	// capture, when set, collects the exchange for the web UI or -body-save-on-error
+	capture *capturedExchange
+	// keepCapture is set when the capture goes to the web UI
+	keepCapture bool
+	// timing, with -record-timing-csv, collects the backend request phases
+	timing *phaseTiming
+	// span, with -otlp-endpoint, is the exchange's trace span
+	span *traceSpan
+	// formFields is the body of a form-encoded request, to log next to a
+	// redirect answering it
+	formFields string
+	// headerCasing maps canonical header names to the casing the client used,
+	// for the names it did not send canonical
+	headerCasing map[string]string
+	// backendAddr is the address of the backend connection, with -log-backend-addr
+	backendAddr string
+}
+
+func (ex *exchange) idString() string {
+	if ex.requestID != "" {
+		return ex.requestID
+	}
+	return strconv.FormatUint(ex.id, 10)
+}
+
+// clientPath is the path the client requested, without the query
+func (ex *exchange) clientPath() string {
+	path, _, _ := strings.Cut(ex.clientURI, "?")
+	return path
+}
+
+// routeFilter returns the filter of the route serving the exchange, nil when
+// it sets none
+func (ex *exchange) routeFilter() *exchangeFilter {
+	if ex.matchedRoute == nil {
+		return nil
+	}
+	return ex.matchedRoute.log.filter
+}
+
+type exchangeKey struct{}
+
+func withExchange(ctx context.Context, ex *exchange) context.Context {
+	return context.WithValue(ctx, exchangeKey{}, ex)
+}
+
+func exchangeFrom(ctx context.Context) *exchange {
+	ex, _ := ctx.Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// headerMatch matches a response header by name and, when set, by value
+type headerMatch struct {
+	name  string
+	value string
+}
+
+// parseHeaderMatch parses "Name:Value". An empty value matches any value.
+func parseHeaderMatch(s string) (*headerMatch, error) {
+	name, value, _ := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("invalid header match %q, expected Name:Value", s)
+	}
+	return &headerMatch{name: name, value: strings.TrimSpace(value)}, nil
+}
+
+func (m *headerMatch) match(h http.Header) bool {
+	values := h.Values(m.name)
+	if m.value == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), m.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *headerMatch) String() string {
+	return m.name + ":" + m.value
+}
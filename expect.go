@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// expectsContinue reports whether the client sent Expect: 100-continue with a body
+func expectsContinue(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody &&
+		strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// continueBody defers reading a request body sent with Expect: 100-continue
+// until the transport sends it. The proxy server answers the client's
+// Expect on the first read of the body, so reading it only when the backend
+// asked for it (or the transport's ExpectContinueTimeout expired) relays the
+// 100 Continue end to end. The body is buffered as it passes and logged once
+// fully sent.
+type continueBody struct {
+	rc          io.ReadCloser
+	dumper      *dumper
+	req         *http.Request
+	logger      *log.Logger
+	timeout     time.Duration
+	buf         bytes.Buffer
+	started     bool
+	done        bool
+	gotContinue atomic.Bool
+}
+
+// withContinueTrace logs the backend's 100 Continue for requests whose body is a continueBody
+func withContinueTrace(req *http.Request) *http.Request {
+	body, ok := req.Body.(*continueBody)
+	if !ok {
+		return req
+	}
+	trace := &httptrace.ClientTrace{
+		Got100Continue: func() {
+			body.gotContinue.Store(true)
+			body.logger.Printf("----- 100 CONTINUE from backend, relaying to client -----")
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		if !b.gotContinue.Load() {
+			b.logger.Printf("----- no 100 CONTINUE from backend within %s, sending body anyway -----", b.timeout)
+		}
+	}
+	n, err := b.rc.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.finish(true)
+	}
+	return n, err
+}
+
+func (b *continueBody) Close() error {
+	b.finish(false)
+	return b.rc.Close()
+}
+
+// finish logs the body once; complete is false when the transport closed the
+// body before reading it to the end, e.g. the backend rejected the request
+func (b *continueBody) finish(complete bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+	if !complete {
+		b.logger.Printf("----- REQUEST BODY NOT SENT (backend answered before asking for it, %d bytes read) -----", b.buf.Len())
+		return
+	}
+	rawBody, decodedBody, _, err := readAndMaybeDecompressBody(io.NopCloser(bytes.NewReader(b.buf.Bytes())), b.dumper.bodyEncoding(b.req.Header.Get("Content-Encoding")), "REQUEST", b.dumper.at(levelWarn, b.dumper.loggerFor(b.req.Context())))
+	if err != nil {
+		b.logger.Printf("Error reading request body: %v", err)
+		return
+	}
+	b.dumper.checkDuplicate(b.req, rawBody)
+	b.dumper.logBodies(b.req.Context(), b.logger, "REQUEST", rawBody, decodedBody, b.req.Header)
+	b.dumper.logBodyHash(b.logger, "REQUEST", rawBody, decodedBody)
+	b.dumper.forwardRequestTrailers(b.logger, b.req)
+	captureRequest(b.req, decodedBody)
+	keepFormFields(b.req, decodedBody)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// failoverTransport sends a request again to a standby backend when the
+// primary cannot be reached or answers with one of the failover statuses.
+// The client only sees the standby's response. Bodies streamed while logged
+// or held for a 100 Continue cannot be sent twice, so those requests are
+// not failed over.
+type failoverTransport struct {
+	rt       http.RoundTripper
+	target   *url.URL
+	statuses []statusRange
+	dumper   *dumper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && !bodyBuffered(req) {
+		return t.rt.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = readScriptBody(&req.Body); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := t.rt.RoundTrip(req)
+	var reason string
+	switch {
+	case err != nil:
+		if req.Context().Err() != nil {
+			// the client went away, the standby would not help
+			return nil, err
+		}
+		reason = err.Error()
+	case inStatusRanges(t.statuses, resp.StatusCode):
+		reason = resp.Status
+	default:
+		return resp, nil
+	}
+	retry := req.Clone(req.Context())
+	retry.URL.Scheme, retry.URL.Host = t.target.Scheme, t.target.Host
+	if body != nil {
+		retry.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.dumper.at(levelWarn, t.dumper.loggerFor(req.Context())).Printf("FAILOVER: %s %s failed (%s), retrying on %s", req.Method, t.dumper.sanitize.url(req.URL), reason, t.target.Redacted())
+	failoverResp, failoverErr := t.rt.RoundTrip(retry)
+	if failoverErr != nil {
+		if resp != nil {
+			// the primary's error response is still better than none
+			t.dumper.at(levelWarn, t.dumper.loggerFor(req.Context())).Printf("FAILOVER: %s failed too (%v), returning the primary's response", t.target.Redacted(), failoverErr)
+			return resp, nil
+		}
+		return nil, fmt.Errorf("primary: %v; failover %s: %w", err, t.target.Redacted(), failoverErr)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return failoverResp, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// exchangeFilter selects exchanges by request method, path and response
+// status. Each criterion matches when any of its values does, a filter
+// matches when all of its criteria do, and an unset criterion matches
+// everything. A path matching any of excludePaths never matches.
+type exchangeFilter struct {
+	methods      []string
+	paths        []*regexp.Regexp
+	excludePaths []*regexp.Regexp
+	statuses     []statusRange
+}
+
+// statusRange is an inclusive range of status codes, e.g. 400-499 for "4xx"
+type statusRange struct {
+	lo, hi int
+}
+
+// parseStatusList parses a comma-separated list of codes and classes such as "404,5xx"
+func parseStatusList(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if len(item) == 3 && item[1:] == "xx" && item[0] >= '1' && item[0] <= '5' {
+			lo := int(item[0]-'0') * 100
+			ranges = append(ranges, statusRange{lo, lo + 99})
+			continue
+		}
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 999 {
+			return nil, fmt.Errorf("invalid status %q, expected a code like 404 or a class like 5xx", item)
+		}
+		ranges = append(ranges, statusRange{code, code})
+	}
+	return ranges, nil
+}
+
+// parseExchangeFilter builds a filter from terms like "method=GET,POST",
+// "path=^/api/", "exclude-path=\.css$" and "status=4xx,5xx". Repeating a
+// key adds alternatives.
+func parseExchangeFilter(terms []string) (*exchangeFilter, error) {
+	f := &exchangeFilter{}
+	for _, term := range terms {
+		key, value, ok := strings.Cut(term, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid filter %q, expected method=, path=, exclude-path= or status=", term)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "method":
+			for _, m := range strings.Split(value, ",") {
+				f.methods = append(f.methods, strings.ToUpper(strings.TrimSpace(m)))
+			}
+		case "path":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter path regex %q: %w", value, err)
+			}
+			f.paths = append(f.paths, re)
+		case "exclude-path":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter exclude-path regex %q: %w", value, err)
+			}
+			f.excludePaths = append(f.excludePaths, re)
+		case "status":
+			ranges, err := parseStatusList(value)
+			if err != nil {
+				return nil, err
+			}
+			f.statuses = append(f.statuses, ranges...)
+		default:
+			return nil, fmt.Errorf("unknown filter key %q in %q", key, term)
+		}
+	}
+	return f, nil
+}
+
+// matchRequest checks the criteria known before the response arrives
+func (f *exchangeFilter) matchRequest(method, path string) bool {
+	if len(f.methods) > 0 && !containsFold(f.methods, method) {
+		return false
+	}
+	for _, re := range f.excludePaths {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, re := range f.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchStatus checks the status criterion
+func (f *exchangeFilter) matchStatus(status int) bool {
+	return len(f.statuses) == 0 || inStatusRanges(f.statuses, status)
+}
+
+func inStatusRanges(ranges []statusRange, status int) bool {
+	for _, r := range ranges {
+		if status >= r.lo && status <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *exchangeFilter) match(method, path string, status int) bool {
+	return f.matchRequest(method, path) && f.matchStatus(status)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
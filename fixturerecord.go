@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// fixtureRecorder writes every finished exchange to a file in the
+// -replay-fixture format as it completes, so a session can be replayed
+// later without the web UI. The JSON array is closed on shutdown; a file
+// cut short by a crash is still read by -replay-fixture.
+type fixtureRecorder struct {
+	path  string
+	mu    sync.Mutex
+	f     *os.File
+	count int
+}
+
+func newFixtureRecorder(path string) (*fixtureRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString("["); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fixtureRecorder{path: path, f: f}, nil
+}
+
+func (r *fixtureRecorder) add(f fixtureExchange) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sep := ",\n"
+	if r.count == 0 {
+		sep = "\n"
+	}
+	if _, err := r.f.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := r.f.Write(b); err != nil {
+		return err
+	}
+	r.count++
+	return nil
+}
+
+// close ends the JSON array and returns the number of exchanges recorded
+func (r *fixtureRecorder) close() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.f.WriteString("\n]\n"); err != nil {
+		r.f.Close()
+		return r.count, err
+	}
+	return r.count, r.f.Close()
+}
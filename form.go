@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// maxFormPartLog bounds the value logged for a text part of a multipart form
+const maxFormPartLog = 1024
+
+// formMediaType returns the media type of a form body logged field by
+// field, or "" for other bodies
+func formMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return mediaType
+	}
+	return ""
+}
+
+// formValue returns a field value for the log, masked when -redact-json
+// names the field
+func formValue(redact *debugproxy.Redactor, name, value string) string {
+	if redact.MasksField(name) {
+		return debugproxy.Redacted
+	}
+	return value
+}
+
+// logForm logs a urlencoded or multipart form body field by field: the
+// values of fields, and the file name, type and size of file parts. It
+// reports false, logging nothing, when the body does not parse as a form.
+func (d *dumper) logForm(logger *log.Logger, redact *debugproxy.Redactor, label string, raw, body []byte, h http.Header) bool {
+	contentType := h.Get("Content-Type")
+	var lines []string
+	var err error
+	switch formMediaType(contentType) {
+	case "application/x-www-form-urlencoded":
+		lines, err = d.urlencodedFields(redact, body)
+	case "multipart/form-data":
+		lines, err = d.multipartFields(redact, body, contentType)
+	default:
+		return false
+	}
+	if err != nil {
+		logger.Printf("Error decoding %s form body, logging it as is: %v", strings.ToLower(label), err)
+		return false
+	}
+	note := debugproxy.CompressionNote(raw, body, h.Get("Content-Encoding"))
+	logger.Printf("----- %s BODY (form, %d fields, %d bytes)%s -----\n%s", label, len(lines), len(body), note, d.truncateBody(label, []byte(strings.Join(lines, "\n"))))
+	return true
+}
+
+// urlencodedFields lists the fields of a urlencoded form, name=value in
+// their order, decoded
+func (d *dumper) urlencodedFields(redact *debugproxy.Redactor, body []byte) ([]string, error) {
+	var lines []string
+	for _, pair := range strings.Split(strings.TrimSpace(string(body)), "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = url.QueryUnescape(value); err != nil {
+			return nil, err
+		}
+		lines = append(lines, name+"="+strconv.Quote(formValue(redact, name, value)))
+	}
+	return lines, nil
+}
+
+// multipartFields lists the parts of a multipart form: name=value for text
+// fields, and the file name, type and size for files and binary parts
+func (d *dumper) multipartFields(redact *debugproxy.Redactor, body []byte, contentType string) ([]string, error) {
+	_, params, _ := mime.ParseMediaType(contentType)
+	if params["boundary"] == "" {
+		return nil, errors.New("no boundary in Content-Type")
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var lines []string
+	for {
+		part, err := mr.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		name, partType := part.FormName(), part.Header.Get("Content-Type")
+		if encoding := part.Header.Get("Content-Transfer-Encoding"); encoding != "" {
+			partType += "; " + strings.ToLower(encoding)
+		}
+		if part.FileName() != "" || isBinary(data, partType) {
+			if partType == "" {
+				partType = "no Content-Type"
+			}
+			lines = append(lines, fmt.Sprintf("%s: file %q (%s, %d bytes)", name, part.FileName(), partType, len(data)))
+			continue
+		}
+		value, more := formValue(redact, name, string(data)), ""
+		if len(value) > maxFormPartLog {
+			cut := maxFormPartLog
+			for cut > 0 && !utf8.RuneStart(value[cut]) {
+				cut--
+			}
+			value, more = value[:cut], fmt.Sprintf("... [%d bytes]", len(data))
+		}
+		line := name + "=" + strconv.Quote(value) + more
+		if partType != "" && !strings.HasPrefix(partType, "text/plain") {
+			line += " (" + partType + ")"
+		}
+		lines = append(lines, line)
+	}
+}
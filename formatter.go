@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// externalFormatter pipes bodies of a content type through an external
+// command and logs its output instead of the body
+type externalFormatter struct {
+	// mediaType is a media type such as application/x-foo, or type/* to
+	// match a whole type
+	mediaType string
+	args      []string
+}
+
+// parseBodyFormatter parses "content-type=command args..."
+func parseBodyFormatter(s string) (*externalFormatter, error) {
+	mediaType, command, ok := strings.Cut(s, "=")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	args := strings.Fields(command)
+	if !ok || mediaType == "" || len(args) == 0 {
+		return nil, fmt.Errorf("invalid body formatter %q, expected content-type=command", s)
+	}
+	return &externalFormatter{mediaType: mediaType, args: args}, nil
+}
+
+func (f *externalFormatter) match(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(f.mediaType, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return mediaType == f.mediaType
+}
+
+// format runs the command with body on stdin and returns its stdout. On
+// failure or timeout the body is hex-dumped instead.
+func (f *externalFormatter) format(body []byte, timeout time.Duration) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, f.args[0], f.args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := err.Error()
+		if ctx.Err() != nil {
+			msg = "timed out after " + timeout.String()
+		} else if s := strings.TrimSpace(stderr.String()); s != "" {
+			msg += ": " + s
+		}
+		return []byte(fmt.Sprintf("(body formatter %s failed: %s)\n%s", f.args[0], msg, hex.Dump(body)))
+	}
+	return out
+}
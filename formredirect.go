@@ -0,0 +1,52 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// keepFormFields keeps the fields of a form-encoded request body on the
+// exchange, for logRedirectedForm
+func keepFormFields(req *http.Request, body []byte) {
+	ex := exchangeFrom(req.Context())
+	if ex == nil || len(body) == 0 {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		ex.formFields = string(body)
+	}
+}
+
+// logRedirectedForm logs the fields a form posted next to the redirect
+// answering it, as one line
+func (d *dumper) logRedirectedForm(resp *http.Response) {
+	ex := exchangeFrom(resp.Request.Context())
+	if ex == nil || ex.formFields == "" || resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+	if u, err := url.Parse(location); err == nil {
+		location = d.sanitize.url(u).String()
+	}
+	var fields []string
+	for _, pair := range strings.Split(d.sanitize.query(ex.formFields), "&") {
+		name, value, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		if value == "" || strings.ContainsAny(value, " \"") {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, name+"="+value)
+	}
+	d.at(levelInfo, ex.logger).Printf("Form %s %s (%s) -> %s, Location: %s", resp.Request.Method, d.sanitize.uri(ex.clientURI), strings.Join(fields, " "), resp.Status, location)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// proxyHeaders controls the X-Forwarded-* headers and the Host of forwarded
+// requests
+type proxyHeaders struct {
+	// xff is append, replace or strip
+	xff string
+	// setHostProto sets X-Forwarded-Host and X-Forwarded-Proto
+	setHostProto bool
+	// targetHost sends the backend's own host as Host instead of the client's
+	targetHost bool
+}
+
+func parseXFFMode(mode string) (string, error) {
+	switch mode {
+	case "append", "replace", "strip":
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid -xff %q, expected append, replace or strip", mode)
+}
+
+// director adjusts the proxy headers after director. The reverse proxy
+// itself appends the client address to X-Forwarded-For, unless the header
+// is set to nil.
+func (p proxyHeaders) director(director func(*http.Request), d *dumper) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		switch p.xff {
+		case "replace":
+			req.Header.Del("X-Forwarded-For")
+		case "strip":
+			req.Header["X-Forwarded-For"] = nil
+			for _, name := range []string{"X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded"} {
+				req.Header.Del(name)
+			}
+		}
+		if p.setHostProto {
+			host, proto := req.Host, "http"
+			if ex := exchangeFrom(req.Context()); ex != nil {
+				host, proto = ex.clientHost, ex.clientScheme
+			} else if req.TLS != nil {
+				proto = "https"
+			}
+			req.Header.Set("X-Forwarded-Host", host)
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if p.targetHost {
+			d.at(levelDebug, d.loggerFor(req.Context())).Printf("Host: %s -> %s", req.Host, req.URL.Host)
+			req.Host = ""
+		}
+	}
+}
+
+// forwardedDirector appends an RFC 7239 Forwarded entry describing the
+// client connection to forwarded requests, after any the client sent
+func (d *dumper) forwardedDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		host, proto := req.Host, "http"
+		if ex := exchangeFrom(req.Context()); ex != nil {
+			host, proto = ex.clientHost, ex.clientScheme
+		} else if req.TLS != nil {
+			proto = "https"
+		}
+		entry := "for=" + forwardedNode(req.RemoteAddr) + ";proto=" + proto + ";host=" + forwardedValue(host)
+		if prior := req.Header.Values("Forwarded"); len(prior) > 0 {
+			entry = strings.Join(prior, ", ") + ", " + entry
+		}
+		req.Header.Set("Forwarded", entry)
+		d.at(levelDebug, d.loggerFor(req.Context())).Printf("Forwarded: %s", entry)
+	}
+}
+
+// forwardedNode formats the client address as a Forwarded node: IPv6
+// addresses are bracketed and quoted, unknown ones are "unknown"
+func forwardedNode(remoteAddr string) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	if net.ParseIP(ip) == nil {
+		return "unknown"
+	}
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// forwardedValue quotes a value that is not a plain token, such as a host with a port
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return `"` + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), `"`, `\"`) + `"`
+		}
+	}
+	return v
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardProxyDirector sends requests in absolute form, as clients send them
+// to an HTTP_PROXY, to the host they name instead of the target. Requests in
+// origin form still go to director, the one picking the target or a -backend
+// of the pool, which absolute-form requests skip.
+func forwardProxyDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		if req.URL.Host == "" {
+			director(req)
+			return
+		}
+		// as the target's director does, don't send Go's default User-Agent
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header.Set("User-Agent", "")
+		}
+	}
+}
+
+// connectHandler answers CONNECT requests of forward proxy clients. Without
+// a CA the tunnel bytes are passed through untouched and only the tunnel is
+// logged; with one, the client's TLS is terminated with a certificate for the
+// requested host signed by the CA, and the requests inside are served by
+// serve like any other, so they are dumped in full.
+type connectHandler struct {
+	dumper *dumper
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+	ca     *mitmCA
+	serve  http.HandlerFunc
+}
+
+// handler answers CONNECT requests and passes the others to next
+func (h *connectHandler) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *connectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := h.dumper.at(levelInfo, h.dumper.logger)
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		// HTTP/2 CONNECT streams cannot be taken over
+		http.Error(w, "CONNECT is only supported over HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	var backend net.Conn
+	if h.ca == nil {
+		var err error
+		if backend, err = h.dial(r.Context(), "tcp", r.Host); err != nil {
+			logger.Printf("CONNECT %s from %s failed: %v", r.Host, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		logger.Printf("CONNECT %s: %v", r.Host, err)
+		if backend != nil {
+			backend.Close()
+		}
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		if backend != nil {
+			backend.Close()
+		}
+		return
+	}
+	client := &bufferedConn{Conn: conn, r: buf.Reader}
+	if h.ca == nil {
+		h.tunnel(client, backend, r, logger)
+		return
+	}
+	h.intercept(client, r, logger)
+}
+
+// tunnel copies bytes both ways until either side closes
+func (h *connectHandler) tunnel(client, backend net.Conn, r *http.Request, logger *log.Logger) {
+	start := time.Now()
+	logger.Printf("CONNECT %s from %s: tunnel opened", r.Host, r.RemoteAddr)
+	var up, down atomic.Int64
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn, n *atomic.Int64) {
+		written, _ := io.Copy(dst, src)
+		n.Add(written)
+		// unblock the other direction
+		dst.Close()
+		src.Close()
+		done <- struct{}{}
+	}
+	go pipe(backend, client, &up)
+	go pipe(client, backend, &down)
+	<-done
+	<-done
+	logger.Printf("CONNECT %s from %s: tunnel closed after %s, %d bytes sent, %d bytes received", r.Host, r.RemoteAddr, time.Since(start).Round(time.Millisecond), up.Load(), down.Load())
+}
+
+// intercept terminates the client's TLS and serves the requests sent over it
+func (h *connectHandler) intercept(client net.Conn, r *http.Request, logger *log.Logger) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	logger.Printf("CONNECT %s from %s: intercepting TLS", r.Host, r.RemoteAddr)
+	cfg := &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return h.ca.certificate(name)
+		},
+	}
+	authority := r.Host
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme, req.URL.Host = "https", authority
+			h.serve(w, req)
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	_ = server.Serve(newOneConnListener(tls.Server(client, cfg)))
+}
+
+// bufferedConn reads first what the server buffered before the hijack
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// oneConnListener hands out a single connection, then blocks until it is
+// closed, so http.Server.Serve returns once the connection is done
+type oneConnListener struct {
+	mu   sync.Mutex
+	conn net.Conn
+	addr net.Addr
+	done chan struct{}
+}
+
+func newOneConnListener(conn net.Conn) *oneConnListener {
+	l := &oneConnListener{addr: conn.LocalAddr(), done: make(chan struct{})}
+	l.conn = &closeNotifyConn{Conn: conn, done: l.done}
+	return l
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	conn := l.conn
+	l.conn = nil
+	l.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *oneConnListener) Close() error { return nil }
+
+func (l *oneConnListener) Addr() net.Addr { return l.addr }
+
+type closeNotifyConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// mitmCA signs the certificates presented to intercepted clients, one per
+// host name, kept for the life of the process
+type mitmCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// loadMITMCA loads the CA from certFile and keyFile, generating and saving
+// one there when neither exists. The second result reports a new CA, which
+// clients still have to be told to trust.
+func loadMITMCA(certFile, keyFile string) (*mitmCA, bool, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		ca, err := generateMITMCA(certFile, keyFile)
+		return ca, true, err
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, false, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, false, errors.New("the CA key must be an ECDSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, false, err
+	}
+	if !cert.IsCA {
+		return nil, false, errors.New("the certificate is not a CA")
+	}
+	return &mitmCA{cert: cert, key: key, certs: map[string]*tls.Certificate{}}, false, nil
+}
+
+func generateMITMCA(certFile, keyFile string) (*mitmCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "http-debug-proxy MITM CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &mitmCA{cert: cert, key: key, certs: map[string]*tls.Certificate{}}, nil
+}
+
+// certificate returns the certificate for host, signing it on first use
+func (ca *mitmCA) certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if cert, ok := ca.certs[host]; ok {
+		return cert, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(7 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}
+	ca.certs[host] = cert
+	return cert, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
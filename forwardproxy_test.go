@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+func TestConnectTunnel(t *testing.T) {
+	// the tunnel's far end echoes what it reads
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	d, logs := newTestDumper()
+	connect := &connectHandler{dumper: d, dial: (&net.Dialer{}).DialContext}
+	proxy := httptest.NewServer(connect.handler(http.NotFoundHandler()))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if _, err := io.WriteString(conn, "CONNECT "+addr+" HTTP/1.1\r\nHost: "+addr+"\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT answered %s", resp.Status)
+	}
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	echoed := make([]byte, 4)
+	if _, err := io.ReadFull(br, echoed); err != nil || string(echoed) != "ping" {
+		t.Fatalf("read %q through the tunnel (%v), want the echoed ping", echoed, err)
+	}
+	conn.Close()
+
+	waitForLog(t, logs, "tunnel closed after ")
+	for _, want := range []string{"CONNECT " + addr + " from ", ": tunnel opened", ", 4 bytes sent, 4 bytes received"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestConnectMITM(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secret answer")
+	}))
+	defer backend.Close()
+	dir := t.TempDir()
+	ca, generated, err := loadMITMCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !generated {
+		t.Error("loadMITMCA did not report the CA it generated")
+	}
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, "http://127.0.0.1:1", func(o *debugproxy.Options) {
+		o.Director = forwardProxyDirector(o.Director)
+		// the backend's own certificate is trusted on the way out
+		o.Transport.(*loggingTransport).rt = backend.Client().Transport
+	})
+	connect := &connectHandler{dumper: d, ca: ca, serve: proxy.Config.Handler.ServeHTTP}
+	front := httptest.NewServer(connect.handler(proxy.Config.Handler))
+	defer front.Close()
+
+	frontURL, _ := url.Parse(front.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(frontURL), TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(backend.URL + "/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	client.CloseIdleConnections()
+	if string(body) != "secret answer" {
+		t.Errorf("client got %q", body)
+	}
+	if leaf := resp.TLS.PeerCertificates[0]; leaf.CheckSignatureFrom(ca.cert) != nil {
+		t.Errorf("the client was shown %s, not a certificate signed by the CA", leaf.Subject)
+	}
+
+	waitForLog(t, logs, "----- RESPONSE BODY -----\nsecret answer")
+	for _, want := range []string{": intercepting TLS", "GET /secure HTTP/1.1\r\nHost: " + strings.TrimPrefix(backend.URL, "https://")} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestForwardProxyDirector(t *testing.T) {
+	target := jsonBackend(t, `"target"`)
+	named := jsonBackend(t, `"named"`)
+	d, _ := newTestDumper()
+	proxy := startProxy(t, d, target.URL, func(o *debugproxy.Options) {
+		o.Director = forwardProxyDirector(o.Director)
+	})
+	proxyURL, _ := url.Parse(proxy.URL)
+	viaProxy := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   string
+	}{
+		{"absolute form", viaProxy, named.URL + "/orders", `"named"`},
+		{"origin form", http.DefaultClient, proxy.URL + "/orders", `"target"`},
+	} {
+		resp, err := tc.client.Get(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tc.want {
+			t.Errorf("%s: answered by %s, want %s", tc.name, body, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// GELF over UDP: a gzipped message larger than one datagram is split into
+// at most gelfMaxChunks chunks of gelfChunkSize bytes
+const (
+	gelfChunkSize = 8192 - 12
+	gelfMaxChunks = 128
+	gelfQueueSize = 1024
+)
+
+// gelfSender sends a GELF message per finished exchange to a Graylog or
+// Logstash UDP input. Messages are sent from a goroutine; when the queue is
+// full they are dropped, so sending never holds back proxying.
+type gelfSender struct {
+	conn   net.Conn
+	host   string
+	dumper *dumper
+	logger *log.Logger
+	queue  chan *capturedExchange
+}
+
+func newGELFSender(addr string, d *dumper) (*gelfSender, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	s := &gelfSender{conn: conn, host: host, dumper: d, logger: d.logger, queue: make(chan *capturedExchange, gelfQueueSize)}
+	go s.run()
+	return s, nil
+}
+
+func (s *gelfSender) send(c *capturedExchange) {
+	select {
+	case s.queue <- c:
+	default:
+		s.logger.Printf("GELF: queue full, message of exchange #%d dropped", c.id)
+	}
+}
+
+func (s *gelfSender) run() {
+	for c := range s.queue {
+		msg, err := s.encode(s.message(c.detail(s.dumper)))
+		if err == nil {
+			err = s.write(msg)
+		}
+		if err != nil {
+			s.logger.Printf("GELF: error sending exchange #%d: %v", c.id, err)
+		}
+	}
+}
+
+// message builds the GELF message of an exchange: the summary line as
+// short_message, the full dump as full_message and the parts of the
+// exchange as additional fields
+func (s *gelfSender) message(e exchangeDetail) map[string]any {
+	level := 6 // informational
+	switch {
+	case e.Status >= 500 || e.Status == 0:
+		level = 3 // error
+	case e.Status >= 400:
+		level = 4 // warning
+	}
+	var reqHeader, respHeader bytes.Buffer
+	_ = e.RequestHeader.Write(&reqHeader)
+	_ = e.ResponseHeader.Write(&respHeader)
+	msg := map[string]any{
+		"version":           "1.1",
+		"host":              s.host,
+		"short_message":     fmt.Sprintf("%s %s -> %d %s (%.3fms)", e.Method, e.URL, e.Status, http.StatusText(e.Status), e.DurationMs),
+		"full_message":      formatDetail(e),
+		"timestamp":         float64(e.Start.Add(time.Duration(e.DurationMs*float64(time.Millisecond))).UnixMicro()) / 1e6,
+		"level":             level,
+		"_exchange_id":      e.ID,
+		"_method":           e.Method,
+		"_url":              e.URL,
+		"_status":           e.Status,
+		"_duration_ms":      e.DurationMs,
+		"_request_headers":  reqHeader.String(),
+		"_request_body":     e.RequestBody,
+		"_response_headers": respHeader.String(),
+		"_response_body":    e.ResponseBody,
+	}
+	if e.Tags != "" {
+		msg["_tags"] = strings.TrimSpace(e.Tags)
+	}
+	if e.Error != "" {
+		msg["_error"] = e.Error
+	}
+	return msg
+}
+
+// encode gzips the message, dropping the dump and bodies when it would not
+// fit in gelfMaxChunks chunks
+func (s *gelfSender) encode(msg map[string]any) ([]byte, error) {
+	out, err := gzipJSON(msg)
+	if err != nil || len(out) <= gelfChunkSize*gelfMaxChunks {
+		return out, err
+	}
+	for _, field := range []string{"full_message", "_request_body", "_response_body"} {
+		delete(msg, field)
+	}
+	msg["_truncated"] = true
+	return gzipJSON(msg)
+}
+
+func gzipJSON(v any) ([]byte, error) {
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// write sends msg in one datagram, or in GELF chunks sharing a random id
+func (s *gelfSender) write(msg []byte) error {
+	if len(msg) <= gelfChunkSize {
+		_, err := s.conn.Write(msg)
+		return err
+	}
+	count := (len(msg) + gelfChunkSize - 1) / gelfChunkSize
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	for i := 0; i < count; i++ {
+		chunk := msg[i*gelfChunkSize : min((i+1)*gelfChunkSize, len(msg))]
+		datagram := make([]byte, 0, 12+len(chunk))
+		datagram = append(datagram, 0x1e, 0x0f)
+		datagram = append(datagram, id[:]...)
+		datagram = append(datagram, byte(i), byte(count))
+		if _, err := s.conn.Write(append(datagram, chunk...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenGELF returns a UDP input and a func reading its next datagram
+func listenGELF(t *testing.T) (net.PacketConn, func() []byte) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc, func() []byte {
+		t.Helper()
+		buf := make([]byte, 65536)
+		_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+}
+
+func TestGELFMessagePerExchange(t *testing.T) {
+	pc, next := listenGELF(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("no such widget"))
+	}))
+	defer backend.Close()
+	d, _ := newTestDumper()
+	var err error
+	if d.gelf, err = newGELFSender(pc.LocalAddr().String(), d); err != nil {
+		t.Fatal(err)
+	}
+	proxy := startProxy(t, d, backend.URL)
+	resp, err := http.Get(proxy.URL + "/widgets/9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	plain, err := gunzip(next())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(plain, &msg); err != nil {
+		t.Fatalf("%v: %s", err, plain)
+	}
+	for field, want := range map[string]any{
+		"version":        "1.1",
+		"level":          4.0,
+		"_method":        "GET",
+		"_status":        404.0,
+		"_response_body": "no such widget",
+	} {
+		if msg[field] != want {
+			t.Errorf("%s = %v, want %v", field, msg[field], want)
+		}
+	}
+	if short, _ := msg["short_message"].(string); !strings.Contains(short, "GET ") || !strings.Contains(short, "/widgets/9 -> 404 Not Found") {
+		t.Errorf("short_message = %q", short)
+	}
+	if full, _ := msg["full_message"].(string); !strings.Contains(full, "no such widget") {
+		t.Errorf("full_message is missing the response body: %q", full)
+	}
+}
+
+func TestGELFChunking(t *testing.T) {
+	pc, next := listenGELF(t)
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &gelfSender{conn: conn}
+
+	small := []byte("one datagram")
+	if err := s.write(small); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); !bytes.Equal(got, small) {
+		t.Errorf("small message sent as %q, want it unchunked", got)
+	}
+
+	msg := make([]byte, 2*gelfChunkSize+100)
+	_, _ = rand.Read(msg)
+	if err := s.write(msg); err != nil {
+		t.Fatal(err)
+	}
+	var joined []byte
+	var id []byte
+	for i := range 3 {
+		d := next()
+		if d[0] != 0x1e || d[1] != 0x0f {
+			t.Fatalf("chunk %d starts with %x, want the GELF magic 1e0f", i, d[:2])
+		}
+		if id == nil {
+			id = d[2:10]
+		} else if !bytes.Equal(d[2:10], id) {
+			t.Errorf("chunk %d has id %x, want %x", i, d[2:10], id)
+		}
+		if d[10] != byte(i) || d[11] != 3 {
+			t.Errorf("chunk %d is numbered %d of %d", i, d[10], d[11])
+		}
+		joined = append(joined, d[12:]...)
+	}
+	if !bytes.Equal(joined, msg) {
+		t.Error("the chunks do not join to the message")
+	}
+}
+
+func TestGELFEncodeDropsTheDumpOfOversizedMessages(t *testing.T) {
+	// hex of random bytes gzips to about half, so this exceeds the chunk limit
+	random := make([]byte, gelfChunkSize*gelfMaxChunks)
+	_, _ = rand.Read(random)
+	msg := map[string]any{"short_message": "GET /big -> 200 OK", "full_message": hex.EncodeToString(random), "_response_body": "x"}
+	out, err := (&gelfSender{}).encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gunzip(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(plain, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["_truncated"] != true || got["full_message"] != nil || got["_response_body"] != nil || got["short_message"] != "GET /big -> 200 OK" {
+		t.Errorf("oversized message encoded as %v", got)
+	}
+}
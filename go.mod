@@ -1,3 +1,18 @@
 module gitbhut.com/nopcoder/http-debug-proxy
 
-go 1.23.7
+go 1.24.0
+
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/klauspost/compress v1.17.11
+	go.starlark.net v0.0.0-20250417143717-f57e51f710eb
+	golang.org/x/text v0.21.0
+	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcCodeNames maps gRPC status codes to their canonical names
+var grpcCodeNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// isGRPC reports whether contentType is a gRPC content type (application/grpc, application/grpc+proto, ...)
+func isGRPC(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// grpcStatusName translates a numeric grpc-status value to its name
+func grpcStatusName(status string) string {
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 0 || code >= len(grpcCodeNames) {
+		return "UNKNOWN_CODE"
+	}
+	return grpcCodeNames[code]
+}
+
+// logGRPCStatus logs the grpc-status and grpc-message carried in the
+// response trailers. Trailers are only known once the body was read to the
+// end. A trailers-only response, sent for calls failing before any message,
+// carries them in its headers instead.
+func logGRPCStatus(logger *log.Logger, resp *http.Response) {
+	if !isGRPC(resp.Header.Get("Content-Type")) {
+		return
+	}
+	fields, note := resp.Trailer, ""
+	if fields.Get("Grpc-Status") == "" && resp.Header.Get("Grpc-Status") != "" {
+		fields, note = resp.Header, " [trailers-only]"
+	}
+	status := fields.Get("Grpc-Status")
+	if status == "" {
+		logger.Printf("----- GRPC STATUS: missing grpc-status trailer -----")
+		return
+	}
+	line := "----- GRPC STATUS: " + status + " " + grpcStatusName(status)
+	if msg := fields.Get("Grpc-Message"); msg != "" {
+		// grpc-message is percent-encoded on the wire
+		if unescaped, err := url.PathUnescape(msg); err == nil {
+			msg = unescaped
+		}
+		line += " (" + msg + ")"
+	}
+	logger.Print(line + note + " -----")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTrailersOnlyGRPCResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "widget%209%20not%20found")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL)
+
+	// a length-prefixed empty message: uncompressed, 0 bytes
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/widgets.v1.Widgets/Get", strings.NewReader("\x00\x00\x00\x00\x00"))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if got := resp.Header.Get("Grpc-Status"); got != "5" {
+		t.Errorf("client got grpc-status %q, want 5", got)
+	}
+	for _, want := range []string{
+		"----- RESPONSE BODY: empty gRPC body, no messages -----",
+		"----- GRPC STATUS: 5 NOT_FOUND (widget 9 not found) [trailers-only] -----",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+	for _, unwanted := range []string{"missing grpc-status", "Error"} {
+		if strings.Contains(logs.String(), unwanted) {
+			t.Errorf("log has %q:\n%s", unwanted, logs)
+		}
+	}
+}
+
+func TestGRPCStatusFromTrailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		_, _ = w.Write([]byte("\x00\x00\x00\x00\x00"))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL)
+	resp, err := http.Post(proxy.URL+"/widgets.v1.Widgets/List", "application/grpc", strings.NewReader("\x00\x00\x00\x00\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if want := "----- GRPC STATUS: 0 OK -----"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs)
+	}
+}
+
+func TestGRPCStatusName(t *testing.T) {
+	for status, want := range map[string]string{"0": "OK", "5": "NOT_FOUND", "16": "UNAUTHENTICATED", "17": "UNKNOWN_CODE", "-1": "UNKNOWN_CODE", "x": "UNKNOWN_CODE"} {
+		if got := grpcStatusName(status); got != want {
+			t.Errorf("grpcStatusName(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// bodyGuard rejects exchanges whose decoded body matches a forbidden
+// pattern, such as a stack trace leaking from the backend: the offending
+// request is answered without reaching the backend, the offending response
+// is replaced before it reaches the client.
+type bodyGuard struct {
+	request, response *regexp.Regexp
+	status            int
+}
+
+func newBodyGuard(requestPattern, responsePattern string, status int) (*bodyGuard, error) {
+	if status < 100 || status > 999 {
+		return nil, fmt.Errorf("invalid -fail-status %d", status)
+	}
+	g := &bodyGuard{status: status}
+	var err error
+	if requestPattern != "" {
+		if g.request, err = regexp.Compile(requestPattern); err != nil {
+			return nil, fmt.Errorf("-fail-on-request-body-pattern: %w", err)
+		}
+	}
+	if responsePattern != "" {
+		if g.response, err = regexp.Compile(responsePattern); err != nil {
+			return nil, fmt.Errorf("-fail-on-body-pattern: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// checkRequest returns the response rejecting req, or nil to send it on.
+// Bodies sent while logged or held for a 100 Continue are not checked.
+func (g *bodyGuard) checkRequest(req *http.Request, d *dumper) *http.Response {
+	if g.request == nil || req.Body == nil || req.Body == http.NoBody || expectsContinue(req) || d.streamsUpload(req) {
+		return nil
+	}
+	logger := d.loggerFor(req.Context())
+	raw, err := readScriptBody(&req.Body)
+	if err != nil {
+		// the transport gets the same error
+		return nil
+	}
+	loc := g.request.FindIndex(debugproxy.DecodeContentEncoding(raw, req.Header.Get("Content-Encoding")))
+	if loc == nil {
+		return nil
+	}
+	logger.Printf("GUARD VIOLATION: request body matches -fail-on-request-body-pattern %q at offset %d, answered with %d without contacting the backend", g.request, loc[0], g.status)
+	resp := g.reject(fmt.Sprintf("request body matches -fail-on-request-body-pattern %q", g.request))
+	resp.Request = req
+	return resp
+}
+
+// checkResponse replaces resp when its body matches; streamed responses are
+// not checked
+func (g *bodyGuard) checkResponse(resp *http.Response, d *dumper) {
+	if g.response == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	logger := d.loggerFor(resp.Request.Context())
+	raw, err := readScriptBody(&resp.Body)
+	if err != nil {
+		return
+	}
+	loc := g.response.FindIndex(debugproxy.DecodeContentEncoding(raw, resp.Header.Get("Content-Encoding")))
+	if loc == nil {
+		return
+	}
+	logger.Printf("GUARD VIOLATION: response body (status %d) matches -fail-on-body-pattern %q at offset %d, replaced with %d", resp.StatusCode, g.response, loc[0], g.status)
+	reject := g.reject(fmt.Sprintf("response body matches -fail-on-body-pattern %q", g.response))
+	resp.StatusCode, resp.Status = reject.StatusCode, reject.Status
+	resp.Header, resp.Trailer = reject.Header, nil
+	resp.Body, resp.ContentLength, resp.TransferEncoding = reject.Body, reject.ContentLength, nil
+}
+
+func (g *bodyGuard) reject(reason string) *http.Response {
+	body := []byte("http-debug-proxy: " + reason + "\n")
+	return &http.Response{
+		StatusCode:    g.status,
+		Status:        fmt.Sprintf("%d %s", g.status, http.StatusText(g.status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Length": {strconv.Itoa(len(body))}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
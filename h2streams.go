@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// h2Streams follows the HTTP/2 backend connections to log how requests are
+// multiplexed on them: when each stream starts and ends, relative to the
+// connection, and how many share it at that moment
+type h2Streams struct {
+	mu    sync.Mutex
+	conns map[net.Conn]*h2Conn
+	seq   int
+	// idle is how long a connection without streams is remembered; the
+	// transport closes idle connections after IdleConnTimeout anyway
+	idle time.Duration
+}
+
+type h2Conn struct {
+	id      int
+	opened  time.Time
+	active  int
+	streams int
+	last    time.Time
+}
+
+func newH2Streams(idle time.Duration) *h2Streams {
+	return &h2Streams{conns: make(map[net.Conn]*h2Conn), idle: idle}
+}
+
+// h2Stream is one request on an HTTP/2 connection
+type h2Stream struct {
+	conn  *h2Conn
+	n     int
+	start time.Time
+}
+
+// trace returns req traced to log its stream, and a function to call once
+// the exchange with the backend is over
+func (s *h2Streams) trace(req *http.Request, logger *log.Logger) (*http.Request, func()) {
+	var (
+		mu     sync.Mutex
+		stream *h2Stream
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			tc, ok := info.Conn.(*tls.Conn)
+			if !ok || tc.ConnectionState().NegotiatedProtocol != "h2" {
+				return
+			}
+			st := s.start(info.Conn)
+			mu.Lock()
+			stream = st
+			mu.Unlock()
+			logger.Printf("H2 stream %d on conn %d (%s) started at +%s, %d active", st.n, st.conn.id, info.Conn.RemoteAddr(), st.start.Sub(st.conn.opened).Round(time.Millisecond), st.conn.active)
+		},
+	}
+	end := func() {
+		mu.Lock()
+		st := stream
+		stream = nil
+		mu.Unlock()
+		if st == nil {
+			return
+		}
+		active, now := s.end(st)
+		logger.Printf("H2 stream %d on conn %d ended at +%s after %s, %d still active", st.n, st.conn.id, now.Sub(st.conn.opened).Round(time.Millisecond), now.Sub(st.start).Round(time.Millisecond), active)
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), end
+}
+
+func (s *h2Streams) start(conn net.Conn) *h2Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for c, st := range s.conns {
+		if st.active == 0 && now.Sub(st.last) > s.idle {
+			delete(s.conns, c)
+		}
+	}
+	c, ok := s.conns[conn]
+	if !ok {
+		s.seq++
+		c = &h2Conn{id: s.seq, opened: now}
+		s.conns[conn] = c
+	}
+	c.active++
+	c.streams++
+	c.last = now
+	return &h2Stream{conn: c, n: c.streams, start: now}
+}
+
+func (s *h2Streams) end(st *h2Stream) (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	st.conn.active--
+	st.conn.last = now
+	return st.conn.active, now
+}
+
+// endOnClose calls end once the response body is closed
+type endOnClose struct {
+	io.ReadCloser
+	end  func()
+	once sync.Once
+}
+
+func (b *endOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.end)
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// harSession keeps every completed exchange in memory to write them out
+// as a HAR 1.2 file (http://www.softwareishard.com/blog/har-12-spec/) on
+// shutdown, for browser devtools and other HAR tools
+type harSession struct {
+	path string
+	mu   sync.Mutex
+	list []*capturedExchange
+}
+
+func (s *harSession) add(c *capturedExchange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, c)
+}
+
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []harCookie  `json:"cookies"`
+	Headers     []harNameVal `json:"headers"`
+	QueryString []harNameVal `json:"queryString"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []harCookie  `json:"cookies"`
+	Headers     []harNameVal `json:"headers"`
+	Content     harContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// write saves the exchanges kept so far, oldest first
+func (s *harSession) write() (int, error) {
+	s.mu.Lock()
+	list := slices.Clone(s.list)
+	s.mu.Unlock()
+	var out harLog
+	out.Log.Version = "1.2"
+	out.Log.Creator = harCreator{Name: "http-debug-proxy", Version: version}
+	out.Log.Entries = make([]harEntry, 0, len(list))
+	for _, c := range list {
+		out.Log.Entries = append(out.Log.Entries, c.harEntry())
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(list), os.WriteFile(s.path, b, 0o644)
+}
+
+func (c *capturedExchange) harEntry() harEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ms := float64(c.duration) / float64(time.Millisecond)
+	e := harEntry{
+		StartedDateTime: c.start.Format(time.RFC3339Nano),
+		Time:            ms,
+		Timings:         harTimings{Wait: ms},
+		Comment:         c.err,
+		Request: harRequest{
+			Method:      c.method,
+			URL:         c.url,
+			HTTPVersion: c.proto,
+			Cookies:     harCookies(c.requestHeader),
+			Headers:     harHeaders(c.requestHeader),
+			QueryString: []harNameVal{},
+			HeadersSize: -1,
+			BodySize:    len(c.requestBody),
+		},
+		Response: harResponse{
+			Status:      c.status,
+			StatusText:  http.StatusText(c.status),
+			HTTPVersion: c.responseProto,
+			Cookies:     []harCookie{},
+			Headers:     harHeaders(c.responseHeader),
+			RedirectURL: c.responseHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	if u, err := url.Parse(c.url); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				e.Request.QueryString = append(e.Request.QueryString, harNameVal{name, v})
+			}
+		}
+	}
+	if len(c.requestBody) > 0 {
+		text, encoding := harText(c.requestBody)
+		e.Request.PostData = &harPostData{MimeType: c.requestHeader.Get("Content-Type"), Text: text, Encoding: encoding}
+	}
+	for _, cookie := range (&http.Response{Header: c.responseHeader}).Cookies() {
+		e.Response.Cookies = append(e.Response.Cookies, harCookie{cookie.Name, cookie.Value})
+	}
+	e.Response.Content = harContent{Size: len(c.responseBody), MimeType: c.responseHeader.Get("Content-Type")}
+	if c.streamed {
+		e.Response.Content.Comment = "streamed, not kept"
+	} else {
+		e.Response.Content.Text, e.Response.Content.Encoding = harText(c.responseBody)
+	}
+	return e
+}
+
+// harText returns a body as HAR text, base64 encoded unless it is UTF-8.
+// The bodies kept are decoded, so the text is what the client saw.
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harHeaders(h http.Header) []harNameVal {
+	out := []harNameVal{}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, v := range h[name] {
+			out = append(out, harNameVal{name, v})
+		}
+	}
+	return out
+}
+
+func harCookies(h http.Header) []harCookie {
+	out := []harCookie{}
+	for _, cookie := range (&http.Request{Header: h}).Cookies() {
+		out = append(out, harCookie{cookie.Name, cookie.Value})
+	}
+	return out
+}
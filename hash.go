@@ -0,0 +1,20 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+)
+
+// logBodyHash logs the SHA-256 of a body, decoded or raw as set by -hash-bodies
+func (d *dumper) logBodyHash(logger *log.Logger, label string, raw, decoded []byte) {
+	body := decoded
+	switch d.hashBodies {
+	case "":
+		return
+	case "raw":
+		body = raw
+	}
+	sum := sha256.Sum256(body)
+	logger.Printf("%s BODY SHA-256 (%s, %d bytes): %s", label, d.hashBodies, len(body), hex.EncodeToString(sum[:]))
+}
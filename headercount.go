@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"slices"
+)
+
+// headerLines counts the header lines of h, one per value
+func headerLines(h http.Header) int {
+	n := 0
+	for _, v := range h {
+		n += len(v)
+	}
+	return n
+}
+
+// checkResponseHeaderCount warns about responses with more header lines
+// than -max-response-headers
+func (d *dumper) checkResponseHeaderCount(resp *http.Response) {
+	if n := headerLines(resp.Header); d.maxResponseHeaders > 0 && n > d.maxResponseHeaders {
+		d.at(levelWarn, d.loggerFor(resp.Request.Context())).Printf("WARNING: response %s %s has %d header lines, over -max-response-headers %d", resp.Request.Method, d.sanitize.url(resp.Request.URL), n, d.maxResponseHeaders)
+	}
+}
+
+// dumpResponseHead dumps the status line and headers of resp. With
+// -truncate-headers, only the first -max-response-headers lines are kept,
+// in the dump's sorted order; the response itself keeps them all.
+func (d *dumper) dumpResponseHead(resp *http.Response) ([]byte, error) {
+	resp = d.redactor(exchangeFrom(resp.Request.Context())).Response(resp)
+	total := headerLines(resp.Header)
+	if !d.truncateHeaders || d.maxResponseHeaders <= 0 || total <= d.maxResponseHeaders {
+		return httputil.DumpResponse(resp, false)
+	}
+	kept := make(http.Header, len(resp.Header))
+	left := d.maxResponseHeaders
+	for _, name := range slices.Sorted(maps.Keys(resp.Header)) {
+		if left == 0 {
+			break
+		}
+		values := resp.Header[name]
+		if len(values) > left {
+			values = values[:left]
+		}
+		kept[name] = values
+		left -= len(values)
+	}
+	c := *resp
+	c.Header = kept
+	dump, err := httputil.DumpResponse(&c, false)
+	if err != nil {
+		return nil, err
+	}
+	note := fmt.Sprintf("... %d more header lines not logged (-truncate-headers)\r\n\r\n", total-d.maxResponseHeaders)
+	return append(bytes.TrimSuffix(dump, []byte("\r\n")), note...), nil
+}
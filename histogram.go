@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogramBounds are the upper bounds of the latency histogram buckets; a
+// last bucket counts the slower requests
+var histogramBounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// histogramWidth is the length of the bar of the fullest bucket
+const histogramWidth = 40
+
+// latencyHistogram counts latencies into buckets and logs them as an ASCII
+// histogram every interval, starting over each time
+type latencyHistogram struct {
+	interval time.Duration
+	logger   *log.Logger
+
+	mu     sync.Mutex
+	counts []int64
+}
+
+func newLatencyHistogram(interval time.Duration, logger *log.Logger) *latencyHistogram {
+	return &latencyHistogram{interval: interval, logger: logger, counts: make([]int64, len(histogramBounds)+1)}
+}
+
+func (h *latencyHistogram) record(latency time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && latency > histogramBounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.mu.Unlock()
+}
+
+// run logs the histogram of every interval that saw requests
+func (h *latencyHistogram) run() {
+	for range time.Tick(h.interval) {
+		h.mu.Lock()
+		counts := h.counts
+		h.counts = make([]int64, len(counts))
+		h.mu.Unlock()
+		h.log(counts)
+	}
+}
+
+func (h *latencyHistogram) log(counts []int64) {
+	var total, most int64
+	first, last := -1, 0
+	for i, n := range counts {
+		total += n
+		most = max(most, n)
+		if n > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if total == 0 {
+		return
+	}
+	// the empty buckets below the fastest and above the slowest are left out
+	var b strings.Builder
+	for i := first; i <= last; i++ {
+		n := counts[i]
+		label := "> " + histogramBounds[len(histogramBounds)-1].String()
+		if i < len(histogramBounds) {
+			label = "<= " + histogramBounds[i].String()
+		}
+		if n == 0 {
+			fmt.Fprintf(&b, "%9s %6d\n", label, n)
+			continue
+		}
+		bar := strings.Repeat("#", int((n*histogramWidth+most-1)/most))
+		fmt.Fprintf(&b, "%9s %6d %s\n", label, n, bar)
+	}
+	h.logger.Printf("----- LATENCY HISTOGRAM (last %s, %d requests) -----\n%s", h.interval, total, b.String())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// httpFileWriter saves every exchange as a .http file, the request format
+// of editor REST clients, so a captured request can be sent again from the
+// editor. The response is kept below it as comments.
+type httpFileWriter struct {
+	dir    string
+	logger *log.Logger
+}
+
+func newHTTPFileWriter(dir string, logger *log.Logger) (*httpFileWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &httpFileWriter{dir: dir, logger: logger}, nil
+}
+
+// save writes <time>-<id>.http
+func (w *httpFileWriter) save(c *capturedExchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\n", c.method, c.url)
+	// sending the request through the proxy again adds these back
+	header := c.requestHeader.Clone()
+	for _, name := range []string{"Via", "Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"} {
+		header.Del(name)
+	}
+	writeHTTPFileHeaders(&b, "", header)
+	if len(c.requestBody) > 0 {
+		b.WriteString("\n")
+		b.Write(c.requestBody)
+		if !bytes.HasSuffix(c.requestBody, []byte("\n")) {
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n")
+	switch {
+	case c.err != "":
+		fmt.Fprintf(&b, "# Error: %s\n", c.err)
+	case c.responseHeader != nil:
+		fmt.Fprintf(&b, "# Response: %d %s\n", c.status, http.StatusText(c.status))
+		writeHTTPFileHeaders(&b, "# ", c.responseHeader)
+		if c.streamed {
+			b.WriteString("#\n# (streamed body, not kept)\n")
+		} else if len(c.responseBody) > 0 {
+			b.WriteString("#\n")
+			scanner := bufio.NewScanner(bytes.NewReader(c.responseBody))
+			scanner.Buffer(nil, len(c.responseBody)+1)
+			for scanner.Scan() {
+				fmt.Fprintf(&b, "# %s\n", scanner.Text())
+			}
+		}
+	}
+	path := filepath.Join(w.dir, fmt.Sprintf("%s-%06d.http", c.start.Format("20060102T150405"), c.id))
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		w.logger.Printf("Error saving exchange #%d as .http file: %v", c.id, err)
+	}
+}
+
+// writeHTTPFileHeaders writes h sorted by name. The bodies kept are decoded,
+// so their encoding and length headers would no longer match them.
+func writeHTTPFileHeaders(b *bytes.Buffer, prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		switch name {
+		case "Content-Encoding", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(b, "%s%s: %s\n", prefix, name, strings.Join(h[name], ", "))
+	}
+}
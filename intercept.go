@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// errInterceptDropped fails an exchange dropped from the intercept queue
+var errInterceptDropped = errors.New("dropped by -intercept")
+
+// interceptor pauses the requests, the responses or both matching its
+// filter until they are released or dropped through the web UI API, where
+// they can be edited first. The filter matches the request as the client
+// sent it, whatever edits were made to it. A paused message left alone is
+// released unchanged after timeout.
+type interceptor struct {
+	filter    *exchangeFilter
+	requests  bool
+	responses bool
+	timeout   time.Duration
+	uiAddr    string
+
+	mu     sync.Mutex
+	seq    uint64
+	paused map[uint64]*pausedMessage
+	// closed is set on shutdown, after which nothing is paused
+	closed bool
+}
+
+// pausedMessage is the JSON form of a paused request or response
+type pausedMessage struct {
+	ID       uint64      `json:"id"`
+	Exchange uint64      `json:"exchange"`
+	Kind     string      `json:"kind"`
+	Since    time.Time   `json:"since"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header"`
+	// Body is decoded from any Content-Encoding; empty for streamed bodies
+	Body     string `json:"body"`
+	Streamed bool   `json:"streamed,omitempty"`
+
+	decision chan interceptDecision
+}
+
+// interceptEdit changes a paused message on release. Unset fields are kept;
+// Header replaces all the headers, and Body is sent without encoding.
+type interceptEdit struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   *string     `json:"body,omitempty"`
+}
+
+type interceptDecision struct {
+	drop bool
+	edit interceptEdit
+}
+
+// newInterceptor pauses messages at "requests", "responses" or "both"
+func newInterceptor(filter *exchangeFilter, at string, timeout time.Duration, uiAddr string) (*interceptor, error) {
+	i := &interceptor{filter: filter, timeout: timeout, uiAddr: uiAddr, paused: map[uint64]*pausedMessage{}}
+	switch at {
+	case "requests":
+		i.requests = true
+	case "responses":
+		i.responses = true
+	case "both":
+		i.requests, i.responses = true, true
+	default:
+		return nil, fmt.Errorf("invalid -intercept-at %q, expected requests, responses or both", at)
+	}
+	return i, nil
+}
+
+// pause queues m and waits for its decision. A timeout or the client going
+// away releases it unchanged.
+func (i *interceptor) pause(ctx context.Context, m *pausedMessage, logger *log.Logger) interceptDecision {
+	i.mu.Lock()
+	if i.closed {
+		i.mu.Unlock()
+		return interceptDecision{}
+	}
+	i.seq++
+	m.ID, m.Since, m.decision = i.seq, time.Now(), make(chan interceptDecision, 1)
+	i.paused[m.ID] = m
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		delete(i.paused, m.ID)
+		i.mu.Unlock()
+	}()
+	logger.Printf("INTERCEPT: %s of exchange #%d, %s %s, paused as %d; release with POST http://%s/api/intercepts/%d/release or drop with .../drop", m.Kind, m.Exchange, m.Method, m.URL, m.ID, i.uiAddr, m.ID)
+	var timeout <-chan time.Time
+	if i.timeout > 0 {
+		timer := time.NewTimer(i.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case d := <-m.decision:
+		return d
+	case <-timeout:
+		logger.Printf("INTERCEPT: %s %d released unchanged after %s (-intercept-timeout)", m.Kind, m.ID, i.timeout)
+	case <-ctx.Done():
+		logger.Printf("INTERCEPT: %s %d abandoned, the client went away", m.Kind, m.ID)
+	}
+	return interceptDecision{}
+}
+
+// decide settles paused message id, reporting false when it is not paused
+func (i *interceptor) decide(id uint64, d interceptDecision) bool {
+	i.mu.Lock()
+	m, ok := i.paused[id]
+	if ok {
+		delete(i.paused, id)
+	}
+	i.mu.Unlock()
+	if ok {
+		m.decision <- d
+	}
+	return ok
+}
+
+// releaseAll releases every paused message unchanged, and stops pausing
+func (i *interceptor) releaseAll() {
+	i.mu.Lock()
+	i.closed = true
+	i.mu.Unlock()
+	for _, m := range i.list() {
+		i.decide(m.ID, interceptDecision{})
+	}
+}
+
+// list returns the paused messages, oldest first
+func (i *interceptor) list() []*pausedMessage {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	out := make([]*pausedMessage, 0, len(i.paused))
+	for _, m := range i.paused {
+		out = append(out, m)
+	}
+	slices.SortFunc(out, func(a, b *pausedMessage) int { return cmp.Compare(a.ID, b.ID) })
+	return out
+}
+
+// interceptBody reads a body for a paused message, leaving it readable again;
+// bodies that must stream are not read
+func interceptBody(body *io.ReadCloser, h http.Header, stream bool) (string, bool, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", false, nil
+	}
+	if stream {
+		return "", true, nil
+	}
+	raw, err := readScriptBody(body)
+	if err != nil {
+		return "", false, err
+	}
+	return string(debugproxy.DecodeContentEncoding(raw, h.Get("Content-Encoding"))), false, nil
+}
+
+// setBody replaces a body with an edited one, sent without encoding
+func setBody(body *io.ReadCloser, h http.Header, content string) int64 {
+	*body = io.NopCloser(bytes.NewReader([]byte(content)))
+	h.Del("Content-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(content)))
+	return int64(len(content))
+}
+
+// request pauses a matching request before it is sent to the backend
+func (i *interceptor) request(req *http.Request, d *dumper) error {
+	ex := exchangeFrom(req.Context())
+	if !i.requests || ex == nil || !i.filter.matchRequest(ex.method, ex.clientPath()) {
+		return nil
+	}
+	// not the exchange logger, which may be holding its output back
+	logger := d.at(levelWarn, d.logger)
+	body, streamed, err := interceptBody(&req.Body, req.Header, expectsContinue(req) || d.streamsUpload(req))
+	if err != nil {
+		return err
+	}
+	m := &pausedMessage{Exchange: ex.id, Kind: "request", Method: req.Method, URL: d.sanitize.url(req.URL).String(), Header: req.Header.Clone(), Body: body, Streamed: streamed}
+	decision := i.pause(req.Context(), m, logger)
+	if decision.drop {
+		logger.Printf("INTERCEPT: request %d dropped", m.ID)
+		return errInterceptDropped
+	}
+	e := decision.edit
+	if e.Method != "" {
+		req.Method = e.Method
+	}
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return fmt.Errorf("intercept: invalid edited URL: %w", err)
+		}
+		req.URL = u
+		req.Host = ""
+	}
+	if e.Header != nil {
+		req.Header = e.Header
+	}
+	if e.Body != nil && !streamed {
+		req.ContentLength = setBody(&req.Body, req.Header, *e.Body)
+		req.TransferEncoding = nil
+	}
+	logger.Printf("INTERCEPT: request %d released%s", m.ID, e.summary())
+	if e.changed() {
+		head, err := httputil.DumpRequestOut(d.redactor(exchangeFrom(req.Context())).Request(d.sanitize.request(req)), false)
+		edited := e.Body
+		if streamed {
+			edited = nil
+		}
+		d.logEdited(req.Context(), "REQUEST", head, err, edited, req.Header)
+	}
+	return nil
+}
+
+// response pauses a matching buffered response before it is sent to the
+// client; streamed responses are not paused
+func (i *interceptor) response(resp *http.Response, d *dumper) error {
+	req := resp.Request
+	ex := exchangeFrom(req.Context())
+	if !i.responses || ex == nil || !i.filter.match(ex.method, ex.clientPath(), resp.StatusCode) {
+		return nil
+	}
+	logger := d.at(levelWarn, d.logger)
+	body, _, err := interceptBody(&resp.Body, resp.Header, false)
+	if err != nil {
+		return err
+	}
+	m := &pausedMessage{Exchange: ex.id, Kind: "response", Method: req.Method, URL: d.sanitize.url(req.URL).String(), Status: resp.StatusCode, Header: resp.Header.Clone(), Body: body}
+	decision := i.pause(req.Context(), m, logger)
+	if decision.drop {
+		logger.Printf("INTERCEPT: response %d dropped", m.ID)
+		resp.Body.Close()
+		return errInterceptDropped
+	}
+	e := decision.edit
+	if e.Status != 0 {
+		resp.StatusCode, resp.Status = e.Status, fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	}
+	if e.Header != nil {
+		resp.Header = e.Header
+	}
+	if e.Body != nil {
+		resp.ContentLength = setBody(&resp.Body, resp.Header, *e.Body)
+		resp.TransferEncoding = nil
+	}
+	logger.Printf("INTERCEPT: response %d released%s", m.ID, e.summary())
+	if e.changed() {
+		head, err := d.dumpResponseHead(resp)
+		d.logEdited(req.Context(), "RESPONSE", head, err, e.Body, resp.Header)
+	}
+	return nil
+}
+
+// logEdited dumps a message again as the intercept edit left it, since the
+// dump before the pause shows it as it arrived
+func (d *dumper) logEdited(ctx context.Context, label string, head []byte, err error, body *string, h http.Header) {
+	logger := d.at(levelDebug, d.loggerFor(ctx))
+	if err != nil {
+		logger.Printf("Error dumping edited %s headers: %v", strings.ToLower(label), err)
+	} else {
+		logger.Printf("----- %s HEADERS (edited by intercept) -----\n%s", label, head)
+	}
+	if body == nil {
+		return
+	}
+	if !logsBodies(ctx) {
+		logger.Printf("----- %s BODY (edited by intercept, %d bytes, not logged by -route-log) -----", label, len(*body))
+		return
+	}
+	logger.Printf("----- %s BODY (edited by intercept) -----\n%s", label, d.bodyForLog(d.redactor(exchangeFrom(ctx)), []byte(*body), h.Get("Content-Type")))
+}
+
+// changed reports whether the edit changes anything
+func (e interceptEdit) changed() bool {
+	return e.Method != "" || e.URL != "" || e.Status != 0 || e.Header != nil || e.Body != nil
+}
+
+// summary lists what an edit changed, for the release line
+func (e interceptEdit) summary() string {
+	var changed []string
+	if e.Method != "" {
+		changed = append(changed, "method="+e.Method)
+	}
+	if e.URL != "" {
+		changed = append(changed, "url="+e.URL)
+	}
+	if e.Status != 0 {
+		changed = append(changed, "status="+strconv.Itoa(e.Status))
+	}
+	if e.Header != nil {
+		changed = append(changed, fmt.Sprintf("%d headers", len(e.Header)))
+	}
+	if e.Body != nil {
+		changed = append(changed, fmt.Sprintf("body of %d bytes", len(*e.Body)))
+	}
+	if len(changed) == 0 {
+		return " unchanged"
+	}
+	return fmt.Sprintf(" with edits: %v", changed)
+}
+
+// handle serves the intercept API on mux
+func (i *interceptor) handle(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/intercepts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, i.list())
+	})
+	mux.HandleFunc("POST /api/intercepts/{id}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid intercept id", http.StatusBadRequest)
+			return
+		}
+		var d interceptDecision
+		switch r.PathValue("action") {
+		case "release":
+			if err := json.NewDecoder(r.Body).Decode(&d.edit); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, "invalid edit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		case "drop":
+			d.drop = true
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if !i.decide(id, d) {
+			http.Error(w, "no such paused message", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// pausedOne waits for the interceptor to pause a message and returns it
+func pausedOne(t *testing.T, i *interceptor) *pausedMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if list := i.list(); len(list) > 0 {
+			return list[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("nothing was paused")
+	return nil
+}
+
+func TestInterceptEditsAreLogged(t *testing.T) {
+	for _, at := range []string{"requests", "responses"} {
+		t.Run(at, func(t *testing.T) {
+			filter, err := parseExchangeFilter([]string{"method=POST"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, logs := newTestDumper()
+			if d.intercept, err = newInterceptor(filter, at, 0, "ui.test"); err != nil {
+				t.Fatal(err)
+			}
+			proxy := startProxy(t, d, echoBackend(t).URL, func(p *debugproxy.Options) {
+				// after the dump, as main does
+				p.ModifyResponse = func(resp *http.Response) error {
+					return d.intercept.response(resp, d)
+				}
+			})
+
+			type result struct {
+				body string
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				resp, err := http.Post(proxy.URL+"/items", "text/plain", strings.NewReader("original"))
+				if err != nil {
+					done <- result{err: err}
+					return
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				done <- result{body: string(body)}
+			}()
+			m := pausedOne(t, d.intercept)
+			edited := "edited"
+			if !d.intercept.decide(m.ID, interceptDecision{edit: interceptEdit{Body: &edited}}) {
+				t.Fatal("the paused message could not be released")
+			}
+			r := <-done
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			proxy.Close()
+
+			label, want := "REQUEST", "POST edited"
+			if at == "responses" {
+				label, want = "RESPONSE", "edited"
+			}
+			if r.body != want {
+				t.Errorf("client got %q, want %q", r.body, want)
+			}
+			out := logs.String()
+			release := strings.Index(out, "INTERCEPT: "+strings.ToLower(label)+" 1 released with edits")
+			headers := strings.Index(out, "----- "+label+" HEADERS (edited by intercept) -----\n")
+			body := strings.Index(out, "----- "+label+" BODY (edited by intercept) -----\nedited")
+			if release < 0 || headers < release || body < headers {
+				t.Errorf("log is missing the release followed by the edited %s:\n%s", strings.ToLower(label), out)
+			}
+			if !strings.Contains(out[headers:], "Content-Length: 6") {
+				t.Errorf("edited headers do not show the new Content-Length:\n%s", out[headers:])
+			}
+		})
+	}
+}
+
+func TestInterceptReleasedUnchangedIsNotLoggedAgain(t *testing.T) {
+	d, logs := newTestDumper()
+	var err error
+	if d.intercept, err = newInterceptor(&exchangeFilter{}, "requests", 0, "ui.test"); err != nil {
+		t.Fatal(err)
+	}
+	proxy := startProxy(t, d, echoBackend(t).URL)
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(proxy.URL + "/items")
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	d.intercept.decide(pausedOne(t, d.intercept).ID, interceptDecision{})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	proxy.Close()
+	if !strings.Contains(logs.String(), "INTERCEPT: request 1 released unchanged") || strings.Contains(logs.String(), "edited by intercept") {
+		t.Errorf("unexpected log:\n%s", logs)
+	}
+}
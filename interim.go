@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+)
+
+// withInterimTrace logs the 1xx interim responses, such as 103 Early Hints,
+// the backend sends before the final response, with -log-1xx, and keeps them
+// with the captured exchange. The reverse proxy relays them to the client
+// through its own trace, which this one is composed with.
+func (d *dumper) withInterimTrace(req *http.Request) *http.Request {
+	logger := d.at(levelDebug, d.loggerFor(req.Context()))
+	ex := exchangeFrom(req.Context())
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if ex != nil && ex.capture != nil {
+				ex.capture.addInterim(code, http.Header(header))
+			}
+			if !d.log1xx {
+				return nil
+			}
+			var b bytes.Buffer
+			d.redactor(ex).Header(http.Header(header)).Write(&b)
+			logger.Printf("----- INTERIM RESPONSE %d %s -----\n%s", code, http.StatusText(code), b.Bytes())
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
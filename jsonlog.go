@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// writeJSONRecord logs a finished exchange as one JSON object per line, for
+// -log-format json; it replaces the exchange's text dump
+func (d *dumper) writeJSONRecord(c *capturedExchange) {
+	e := c.detail(d)
+	if u, err := url.Parse(e.URL); err == nil {
+		e.URL = d.sanitize.url(u).String()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		d.logger.Printf("Error encoding exchange #%d as JSON: %v", c.id, err)
+		return
+	}
+	_, _ = d.sink.out.Write(append(b, '\n'))
+}
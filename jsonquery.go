@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// logBody logs a decoded body, noting its compressed size when it was
+// decompressed. With -body-json-query, JSON bodies are logged as the
+// queried values only, path=value separated by spaces.
+func (d *dumper) logBody(logger *log.Logger, redact *debugproxy.Redactor, label string, raw, body []byte, h http.Header) {
+	contentType := h.Get("Content-Type")
+	note := debugproxy.CompressionNote(raw, body, h.Get("Content-Encoding"))
+	if len(d.jsonQuery) > 0 && debugproxy.IsJSON(contentType) {
+		if doc, ok := parseJSONDoc(body); ok {
+			var fields []string
+			for _, path := range d.jsonQuery {
+				walkJSONPath(doc, path, "", func(at string, v any) {
+					value, _ := json.Marshal(v)
+					fields = append(fields, at+"="+string(value))
+				})
+			}
+			if len(fields) == 0 {
+				logger.Printf("----- %s BODY FIELDS%s (none of -body-json-query found, %d bytes) -----", label, note, len(body))
+			} else {
+				logger.Printf("----- %s BODY FIELDS%s -----\n%s", label, note, strings.Join(fields, " "))
+			}
+			return
+		}
+	}
+	logger.Printf("----- %s BODY%s -----\n%s", label, note, d.truncateBody(label, d.bodyForLog(redact, body, contentType)))
+}
+
+// truncateBody cuts a formatted body to -max-request-body-log or
+// -max-response-body-log bytes, by direction. Only the log is cut.
+func (d *dumper) truncateBody(label string, body []byte) []byte {
+	limit := d.maxResponseBodyLog
+	if label == "REQUEST" {
+		limit = d.maxRequestBodyLog
+	}
+	if limit <= 0 || len(body) <= limit {
+		return body
+	}
+	return fmt.Appendf(body[:limit:limit], "... [truncated, %d more bytes, %d total]", len(body)-limit, len(body))
+}
+
+// parseJSONDoc decodes a JSON document, keeping numbers as written
+func parseJSONDoc(body []byte) (any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+	return doc, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// proxyListener is a listen address of the proxy. The extra ones of
+// -listener forward to their own target through director; the -l one, with
+// no director, uses -t and the -route rules.
+type proxyListener struct {
+	addr     string
+	target   *url.URL
+	director func(*http.Request)
+}
+
+// parseListener parses a -listener rule, addr=url, such as
+// :9192=http://localhost:8282
+func parseListener(spec string) (*proxyListener, error) {
+	addr, target, ok := strings.Cut(spec, "=")
+	if !ok || addr == "" {
+		return nil, fmt.Errorf("%q: want addr=url", spec)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", spec, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%q: want an absolute target URL", spec)
+	}
+	return &proxyListener{addr: addr, target: u, director: httputil.NewSingleHostReverseProxy(u).Director}, nil
+}
+
+type listenerKey struct{}
+
+// withListener marks the connections accepted by l, for ConnContext
+func withListener(ctx context.Context, l *proxyListener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, l)
+}
+
+// listenerFrom returns the listener a request came in on, nil when the proxy
+// has a single one
+func listenerFrom(ctx context.Context) *proxyListener {
+	l, _ := ctx.Value(listenerKey{}).(*proxyListener)
+	return l
+}
+
+// listenerDirector sends the requests of extra listeners to their target,
+// and the others through director
+func listenerDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		if l := listenerFrom(req.Context()); l != nil && l.director != nil {
+			l.director(req)
+			return
+		}
+		director(req)
+	}
+}
+
+// shutdownServers shuts the servers down together, returning the first error
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() { errs <- s.Shutdown(ctx) }()
+	}
+	var first error
+	for range servers {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func closeServers(servers []*http.Server) {
+	for _, s := range servers {
+		_ = s.Close()
+	}
+}
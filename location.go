@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// rewriteLocation points a Location header that redirects to the backend
+// itself back at the proxy, using the host and scheme the client connected to
+func (d *dumper) rewriteLocation(resp *http.Response) {
+	loc := resp.Header.Get("Location")
+	ex := exchangeFrom(resp.Request.Context())
+	if loc == "" || ex == nil {
+		return
+	}
+	u, err := url.Parse(loc)
+	if err != nil || u.Host == "" {
+		// relative redirects already resolve against the proxy
+		return
+	}
+	backend := resp.Request.URL
+	if u.Host != backend.Host || u.Scheme != "" && u.Scheme != backend.Scheme {
+		return
+	}
+	u.Scheme = ex.clientScheme
+	u.Host = ex.clientHost
+	rewritten := u.String()
+	resp.Header.Set("Location", rewritten)
+	d.at(levelInfo, d.loggerFor(resp.Request.Context())).Printf("Location rewritten: %s -> %s", loc, rewritten)
+}
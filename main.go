@@ -2,120 +2,2282 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
-// Helper to read, decompress (if gzip), and restore a ReadCloser body
-func readAndMaybeDecompressBody(body io.ReadCloser, encoding string) (rawBody, decodedBody []byte, restore func() io.ReadCloser, err error) {
+// Helper to read, decompress (per Content-Encoding), and restore a ReadCloser body
+func readAndMaybeDecompressBody(body io.ReadCloser, encoding string, label string, warn *log.Logger) (rawBody, decodedBody []byte, restore func() io.ReadCloser, err error) {
 	rawBody, err = io.ReadAll(body)
 	body.Close()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	var decoded []byte
-	if encoding == "gzip" {
-		gz, err := gzip.NewReader(bytes.NewReader(rawBody))
+	if encoding == "auto" {
+		encoding = debugproxy.SniffEncoding(rawBody)
+	}
+	decoded, decodeErr := debugproxy.DecodeContentEncodingErr(rawBody, encoding)
+	if decodeErr != nil {
+		// a body that does not decode points at a compression bug upstream
+		warn.Printf("WARNING: %s body %v, logging raw bytes", label, decodeErr)
+	}
+	restore = func() io.ReadCloser {
+		return io.NopCloser(bytes.NewReader(rawBody))
+	}
+	return rawBody, decoded, restore, nil
+}
+
+// dumper logs HTTP requests and responses passing through the proxy
+type dumper struct {
+	logger *log.Logger
+	// sink creates the per exchange loggers and receives held dumps
+	sink *logSink
+	// transcode converts bodies in other charsets to UTF-8 before logging
+	transcode bool
+	// jsonLog logs each exchange as one JSON object instead of text dumps
+	jsonLog bool
+	// groupLogs holds each exchange's dump until it finishes, to log it as
+	// one block
+	groupLogs bool
+	// logIf, when set, dumps an exchange in full only if its response
+	// carries the matching header; other exchanges get a summary line
+	logIf *headerMatch
+	// dups, when set, warns about identical requests repeated in a short window
+	dups *dupDetector
+	// formatters pipe bodies of matching content types through external commands
+	formatters       []*externalFormatter
+	formatterTimeout time.Duration
+	// tags label the log lines of requests whose path matches
+	tags []tagRule
+	// routeLogs set how much of the exchanges whose path matches is dumped
+	routeLogs []routeLogRule
+	// expectContinueTimeout is the transport's wait for a 100 Continue, for logging
+	expectContinueTimeout time.Duration
+	// logEvery dumps one exchange out of every logEvery, counted by sampleSeq
+	logEvery  uint64
+	sampleSeq atomic.Uint64
+	// exchangeSeq numbers the exchanges
+	exchangeSeq atomic.Uint64
+	// assumeEncoding overrides or sniffs the Content-Encoding of logged bodies
+	assumeEncoding string
+	// logSNI logs the server name requested by TLS clients
+	logSNI bool
+	// logALPN logs the protocol TLS clients negotiated with ALPN
+	logALPN bool
+	// decodeSigV4 logs the parts of AWS SigV4 Authorization headers
+	decodeSigV4 bool
+	// rawRequest logs request heads as received; normalizeHeaders logs the
+	// header names received in non-canonical casing, and preserveHeaderCase
+	// forwards them that way. All three need the client bytes from rawHeadConn.
+	rawRequest         bool
+	normalizeHeaders   bool
+	preserveHeaderCase bool
+	// streamUploads logs large or unsized request bodies as they are sent
+	streamUploads         bool
+	streamUploadThreshold int64
+	// captures, when set, keeps recent exchanges for the web UI
+	captures *captureStore
+	// metrics, when set, counts the traffic for /metrics
+	metrics *proxyMetrics
+	// captureFilter, when set, limits the exchanges kept in captures
+	captureFilter *exchangeFilter
+	// logIDs starts each message of an exchange with its ID
+	logIDs bool
+	// logFilter, when set, limits the exchanges logged
+	logFilter atomic.Pointer[exchangeFilter]
+	// intercept, when set, pauses matching exchanges for the web UI API
+	intercept *interceptor
+	// log1xx logs interim 1xx responses such as 103 Early Hints
+	log1xx bool
+	// logBackendAddr logs the backend address each request is sent to
+	logBackendAddr bool
+	// h2Streams, when set, logs the streams of HTTP/2 backend connections
+	h2Streams *h2Streams
+	// stripAcceptEncoding is set when forwarded requests ask for uncompressed bodies
+	stripAcceptEncoding bool
+	// spans, when set, exports a span per exchange to -otlp-endpoint
+	spans *spanExporter
+	// maxRequestBodyLog and maxResponseBodyLog cap the logged body bytes per
+	// direction (0 means no limit)
+	maxRequestBodyLog  int
+	maxResponseBodyLog int
+	// jsonQuery are the -body-json-query paths logged instead of JSON bodies
+	jsonQuery [][]string
+	// timingCSV, when set, gets a row of timings per exchange
+	timingCSV *timingCSV
+	// logTiming logs the timing breakdown of every exchange
+	logTiming bool
+	// logCurl logs a curl command sending every request again
+	logCurl bool
+	// bodyDump, when set, gets the bodies instead of the log
+	bodyDump *bodyDumper
+	// protoset, when set, has the message types of gRPC and protobuf bodies
+	protoset *protoregistry.Files
+	// maxResponseHeaders is the header line count over which a response is
+	// flagged, and with truncateHeaders cut in the dump
+	maxResponseHeaders int
+	truncateHeaders    bool
+	// compressRequests gzips request bodies before they are sent
+	compressRequests bool
+	// gelf, when set, sends a GELF message per exchange to -gelf-addr
+	gelf *gelfSender
+	// requestIDHeader, when set, carries the exchange ID to the backend and
+	// back to the client
+	requestIDHeader string
+	// tee, when set, mirrors forwarded requests to -tee
+	tee *teeSender
+	// errorMirror, when set, copies the log output of failed exchanges to stderr
+	errorMirror *errorMirror
+	// limit, when set, shuts the proxy down after -max-requests exchanges
+	limit *requestLimit
+	// pretty reindents logged JSON and XML bodies and hex-dumps binary ones
+	pretty bool
+	// guard, when set, rejects bodies matching -fail-on-body-pattern
+	guard *bodyGuard
+	// errorSaver, when set, saves the bodies of exchanges that failed
+	errorSaver *errorSaver
+	// httpFiles, when set, saves every exchange as a .http file
+	httpFiles *httpFileWriter
+	// har, when set, keeps every exchange to write a HAR file on shutdown
+	har *harSession
+	// record, when set, writes every exchange to a -replay-fixture file
+	record *fixtureRecorder
+	// budget, when set, bounds the body bytes buffered across exchanges
+	budget *bufferBudget
+	// cacheHeaders logs a one line summary of the response caching headers
+	cacheHeaders bool
+	// logOriginal also logs the request headers as received, before rewrites
+	logOriginal bool
+	// sanitize redacts secret query parameters from logged URLs
+	sanitize querySanitizer
+	// redact masks secret headers and JSON body fields in the log
+	redact atomic.Pointer[debugproxy.Redactor]
+	// wsInflate decompresses permessage-deflate WebSocket messages for logging
+	wsInflate bool
+	// requests counts the requests being handled, for shutdown
+	requests requestTracker
+	// rawForms logs form bodies as they are instead of field by field
+	rawForms bool
+	// streams, when set, tracks streaming exchanges for -drain-timeout
+	streams *streamTracker
+	// flushInterval streams every response; Server-Sent Events, and with
+	// streamChunked responses of unknown length, are streamed regardless
+	flushInterval time.Duration
+	streamChunked bool
+	// ndjsonPreview logs streamed NDJSON bodies record by record, cut to
+	// this many bytes; ndjsonCapture, when set, keeps the full records
+	ndjsonPreview int
+	ndjsonCapture *recordFile
+	// hashBodies logs the SHA-256 of "decoded" or "raw" buffered bodies
+	hashBodies string
+	// ring, when set, keeps recent exchanges to dump on SIGUSR1
+	ring *captureStore
+	// canary, when set, picks the exchanges sent to -canary-target
+	canary *canarySplit
+	// base64Fields are the JSON paths whose base64 values are logged decoded
+	base64Fields [][]string
+}
+
+// checkDuplicate warns when the same request keeps arriving within the duplicate window
+func (d *dumper) checkDuplicate(req *http.Request, body []byte) {
+	if d.dups == nil {
+		return
+	}
+	uri := req.URL.RequestURI()
+	if ex := exchangeFrom(req.Context()); ex != nil {
+		uri = ex.clientURI
+	}
+	if n := d.dups.observe(req.Method, uri, body); n > 1 {
+		d.at(levelWarn, d.logger).Printf("WARNING: duplicate request %s %s seen %d times within %s", req.Method, d.sanitize.uri(uri), n, d.dups.window)
+	}
+}
+
+// newExchange creates the logging state for a new incoming request served by route
+func (d *dumper) newExchange(r *http.Request, route string, matched *pathRoute) *exchange {
+	ex := &exchange{
+		id:            d.exchangeSeq.Add(1),
+		start:         time.Now(),
+		method:        r.Method,
+		route:         route,
+		matchedRoute:  matched,
+		clientURI:     r.URL.RequestURI(),
+		clientHost:    r.Host,
+		clientScheme:  "http",
+		clientTrailer: r.Trailer,
+		prefix:        tagPrefix(d.tags, r.URL.Path),
+		logMode:       routeLogFor(d.routeLogs, r.URL.Path),
+	}
+	if l := listenerFrom(r.Context()); l != nil {
+		ex.prefix = "[" + l.addr + "] " + ex.prefix
+	}
+	if d.requestIDHeader != "" {
+		if ex.requestID = r.Header.Get(d.requestIDHeader); ex.requestID == "" {
+			ex.requestID = newUUID()
+		}
+	}
+	if d.spans != nil {
+		ex.span = newTraceSpan(r.Header.Get("Traceparent"))
+	}
+	if d.timingCSV != nil || d.logTiming {
+		ex.timing = &phaseTiming{}
+	}
+	if d.canary != nil && d.canary.pick() {
+		ex.canary = true
+		ex.prefix = "[canary] " + ex.prefix
+	}
+	if matched != nil {
+		if matched.log.modeSet {
+			ex.logMode = matched.log.mode
+		}
+		if matched.log.redact != nil {
+			ex.redact = d.redact.Load().With(*matched.log.redact)
+		}
+	}
+	logFilter, routeFilter := d.logFilter.Load(), ex.routeFilter()
+	ex.filteredOut = logFilter != nil && !logFilter.matchRequest(r.Method, r.URL.Path) ||
+		routeFilter != nil && !routeFilter.matchRequest(r.Method, r.URL.Path)
+	var held io.Writer
+	switch {
+	case d.jsonLog:
+		// the JSON record written on finish stands for the whole exchange
+		held = io.Discard
+		ex.summarized = true
+	case ex.filteredOut:
+		held = io.Discard
+		ex.summarized = true
+	case ex.logMode == routeLogSummary:
+		held = io.Discard
+	case d.logEvery > 1 && (d.sampleSeq.Add(1)-1)%d.logEvery != 0:
+		// sampled out: the request is processed as usual but its dump dropped
+		ex.sampledOut = true
+		held = io.Discard
+	case d.logIf != nil || d.groupLogs || logFilter != nil && len(logFilter.statuses) > 0 || routeFilter != nil && len(routeFilter.statuses) > 0:
+		ex.held = &bytes.Buffer{}
+		held = ex.held
+		ex.grouped = d.groupLogs
+	}
+	if r.TLS != nil {
+		ex.clientScheme = "https"
+	}
+	if d.errorMirror != nil {
+		ex.mirror = &mirrorBuffer{}
+	}
+	ex.logger = d.exchangeLogger(ex, held)
+	ex.keepCapture = d.captures != nil && (d.captureFilter == nil || d.captureFilter.matchRequest(r.Method, r.URL.Path))
+	if ex.keepCapture || d.errorSaver != nil || d.httpFiles != nil || d.har != nil || d.record != nil || d.jsonLog || d.ring != nil || d.gelf != nil || d.logCurl {
+		ex.capture = &capturedExchange{
+			id:     ex.id,
+			start:  ex.start,
+			method: r.Method,
+			url:    ex.clientScheme + "://" + ex.clientHost + ex.clientURI,
+			proto:  r.Proto,
+			tags:   strings.TrimSpace(ex.prefix),
+			redact: ex.redact,
+		}
+	}
+	d.logClientRequest(ex, r)
+	return ex
+}
+
+// finishExchange completes an exchange once the response was sent to the client
+func (d *dumper) finishExchange(ex *exchange, status int) {
+	if ex.grouped && ex.held != nil {
+		ex.shadows.Wait()
+		d.writeHeld(ex)
+		ex.logger = d.exchangeLogger(ex, nil)
+	}
+	if ex.capture != nil {
+		ex.capture.finish(status, time.Since(ex.start))
+		if ex.keepCapture && (d.captureFilter == nil || d.captureFilter.matchStatus(status)) {
+			d.captures.add(ex.capture)
+		}
+		if d.errorSaver != nil {
+			d.errorSaver.save(ex.capture)
+		}
+		if d.httpFiles != nil {
+			d.httpFiles.save(ex.capture)
+		}
+		if d.har != nil {
+			d.har.add(ex.capture)
+		}
+		if d.record != nil && status != 0 {
+			if err := d.record.add(ex.capture.fixture(d)); err != nil {
+				d.logger.Printf("Error recording exchange #%d: %v", ex.id, err)
+			}
+		}
+		if d.jsonLog && !ex.filteredOut && d.statusLogged(ex, status) && d.sink.enabled(levelInfo) {
+			d.writeJSONRecord(ex.capture)
+		}
+		if d.ring != nil {
+			d.ring.add(ex.capture)
+		}
+		if d.gelf != nil {
+			d.gelf.send(ex.capture)
+		}
+		if d.logCurl {
+			d.at(levelInfo, ex.logger).Printf("CURL #%s:\n%s", ex.idString(), ex.capture.curl(ex.capture.redactor(d)))
+		}
+	}
+	if d.streams != nil {
+		d.streams.remove(ex)
+	}
+	if d.timingCSV != nil {
+		d.timingCSV.record(ex, ex.clientPath(), status)
+	}
+	if d.logTiming && ex.timing != nil {
+		d.at(levelInfo, ex.logger).Printf("TIMING #%s %s %s: %s", ex.idString(), ex.method, d.sanitize.uri(ex.clientURI), ex.timing.breakdown(ex.start, time.Now()))
+	}
+	if ex.span != nil {
+		d.spans.end(ex, status, ex.clientScheme+"://"+ex.clientHost+d.sanitize.uri(ex.clientURI))
+	}
+	if !ex.summarized && !d.sink.enabled(levelDebug) {
+		// without the full dump, the exchange still gets one line
+		via := ""
+		if ex.backendAddr != "" {
+			via = " via " + ex.backendAddr
+		}
+		d.at(levelInfo, ex.logger).Printf("#%s %s %s -> %d %s (%s)%s", ex.idString(), ex.method, d.sanitize.uri(ex.clientURI), status, http.StatusText(status), time.Since(ex.start).Round(time.Microsecond), via)
+	}
+	if ex.mirror != nil {
+		d.errorMirror.finish(ex.mirror, status)
+	}
+	if d.limit != nil {
+		d.limit.done(d.at(levelInfo, d.logger))
+	}
+}
+
+// captureRequest keeps the request headers and decoded body of a captured exchange
+func captureRequest(req *http.Request, body []byte) {
+	if ex := exchangeFrom(req.Context()); ex != nil && ex.capture != nil {
+		ex.capture.setRequest(req.Header, body)
+	}
+}
+
+// captureResponse keeps the response headers and decoded body of a captured exchange
+func captureResponse(resp *http.Response, body []byte, streamed bool) {
+	if ex := exchangeFrom(resp.Request.Context()); ex != nil && ex.capture != nil {
+		ex.capture.setResponse(resp.Proto, resp.Header, body, streamed)
+	}
+}
+
+// logClientRequest logs what is only known about the request as the client
+// sent it: the TLS server name, the headers before any rewrite and the
+// verbatim request head
+func (d *dumper) logClientRequest(ex *exchange, r *http.Request) {
+	if d.logOriginal {
+		headerDump, err := httputil.DumpRequest(d.redactor(ex).Request(d.sanitize.request(r)), false)
 		if err != nil {
-			decoded = rawBody
+			ex.logger.Printf("Error dumping original request headers: %v", err)
 		} else {
-			decoded, err = io.ReadAll(gz)
-			gz.Close()
-			if err != nil {
-				decoded = rawBody
+			d.at(levelDebug, ex.logger).Printf("----- ORIGINAL REQUEST HEADERS (from client) -----\n%s", headerDump)
+		}
+	}
+	if d.logSNI && r.TLS != nil {
+		d.at(levelInfo, ex.logger).Printf("Client TLS: SNI=%q", r.TLS.ServerName)
+	}
+	if d.logALPN && r.TLS != nil {
+		alpn := r.TLS.NegotiatedProtocol
+		if alpn == "" {
+			alpn = "none"
+		}
+		d.at(levelInfo, ex.logger).Printf("Client TLS: ALPN=%s (%s)", alpn, r.Proto)
+	}
+	if rc := rawConnFrom(r.Context()); rc != nil {
+		logger := d.at(levelDebug, ex.logger)
+		head := rc.takeHead(r.Method + " " + r.RequestURI + " " + r.Proto + "\r\n")
+		if head == nil {
+			logger.Printf("----- RAW REQUEST HEAD not available -----")
+			return
+		}
+		if d.normalizeHeaders || d.preserveHeaderCase {
+			ex.headerCasing = headerCasing(head)
+			logHeaderCasing(logger, ex.headerCasing, d.preserveHeaderCase)
+		}
+		if d.rawRequest {
+			if uri := d.sanitize.uri(r.RequestURI); uri != r.RequestURI {
+				head = bytes.Replace(head, []byte(r.RequestURI), []byte(uri), 1)
 			}
+			head = d.redactor(ex).Head(head)
+			logger.Printf("----- RAW REQUEST HEAD (as received) -----\n%s", head)
 		}
-	} else {
-		decoded = rawBody
 	}
-	restore = func() io.ReadCloser {
-		return io.NopCloser(bytes.NewReader(rawBody))
+}
+
+// exchangeLogger returns a logger writing to held, or to the sink when held
+// is nil, with the exchange prefix placed right before each message. Output
+// to the sink is also kept for -errors-to-stderr.
+func (d *dumper) exchangeLogger(ex *exchange, held io.Writer) *log.Logger {
+	if held == nil && ex.mirror != nil {
+		held = io.MultiWriter(d.sink.out, ex.mirror)
 	}
-	return rawBody, decoded, restore, nil
+	prefix := ex.prefix
+	if d.logIDs {
+		id := ex.idString()
+		if ex.requestID == "" {
+			id = "#" + id
+		}
+		prefix = "[" + id + "] " + prefix
+	}
+	if held == nil && prefix == "" {
+		return d.logger
+	}
+	return d.sink.logger(held, prefix)
+}
+
+// writeHeld writes out the dump held for the exchange
+func (d *dumper) writeHeld(ex *exchange) {
+	_, _ = d.sink.out.Write(ex.held.Bytes())
+	if ex.mirror != nil {
+		_, _ = ex.mirror.Write(ex.held.Bytes())
+	}
+	ex.held = nil
+}
+
+// discardLogger drops messages below the sink level
+var discardLogger = log.New(io.Discard, "", 0)
+
+// at returns logger when the sink shows messages at level l, and a logger
+// dropping them otherwise
+func (d *dumper) at(l logLevel, logger *log.Logger) *log.Logger {
+	if !d.sink.enabled(l) {
+		return discardLogger
+	}
+	return logger
+}
+
+// loggerFor returns the logger of the exchange bound to ctx
+func (d *dumper) loggerFor(ctx context.Context) *log.Logger {
+	if ex := exchangeFrom(ctx); ex != nil {
+		return ex.logger
+	}
+	return d.logger
+}
+
+// statusLogged reports whether the -match-status filter and the one of the
+// exchange's route let a response with status into the log
+func (d *dumper) statusLogged(ex *exchange, status int) bool {
+	if logFilter := d.logFilter.Load(); logFilter != nil && !logFilter.matchStatus(status) {
+		return false
+	}
+	routeFilter := ex.routeFilter()
+	return routeFilter == nil || routeFilter.matchStatus(status)
+}
+
+// redactor returns the redactor masking the secrets of ex, which may be nil
+func (d *dumper) redactor(ex *exchange) *debugproxy.Redactor {
+	if ex != nil && ex.redact != nil {
+		return ex.redact
+	}
+	return d.redact.Load()
+}
+
+// releaseExchange decides, once the response is known, whether a held
+// exchange is dumped. It reports false when the response should not be dumped.
+func (d *dumper) releaseExchange(resp *http.Response) bool {
+	ex := exchangeFrom(resp.Request.Context())
+	if ex == nil || ex.held == nil && !ex.sampledOut && !ex.filteredOut && ex.logMode != routeLogSummary {
+		return true
+	}
+	if ex.filteredOut {
+		return false
+	}
+	statusMatches := d.statusLogged(ex, resp.StatusCode)
+	if ex.held != nil && statusMatches && (d.logIf == nil || d.logIf.match(resp.Header)) {
+		// grouped exchanges stay held until they finish
+		if !ex.grouped {
+			d.writeHeld(ex)
+			ex.logger = d.exchangeLogger(ex, nil)
+		}
+		return true
+	}
+	ex.held = nil
+	ex.summarized = true
+	if !statusMatches {
+		// left out by -match-status, nothing more of it is logged
+		ex.filteredOut = true
+		ex.logger = d.exchangeLogger(ex, io.Discard)
+		return false
+	}
+	ex.logger = d.exchangeLogger(ex, nil)
+	if ex.logMode == routeLogSummary {
+		d.at(levelInfo, ex.logger).Printf("%s %s -> %s (summary only by -route-log, not dumped)", resp.Request.Method, d.sanitize.url(resp.Request.URL), resp.Status)
+		return false
+	}
+	if ex.sampledOut {
+		d.at(levelInfo, ex.logger).Printf("%s %s -> %s (sampled out by -log-every %d, not dumped)", resp.Request.Method, d.sanitize.url(resp.Request.URL), resp.Status, d.logEvery)
+		return false
+	}
+	d.at(levelInfo, ex.logger).Printf("%s %s -> %s (no %s response header, not dumped)", resp.Request.Method, d.sanitize.url(resp.Request.URL), resp.Status, d.logIf)
+	return false
+}
+
+// flushGroup writes out the dump held for a -group-logs exchange and logs
+// the rest of it right away, for streams that could be held indefinitely
+func (d *dumper) flushGroup(ctx context.Context) {
+	ex := exchangeFrom(ctx)
+	if ex == nil || !ex.grouped {
+		return
+	}
+	ex.grouped = false
+	if ex.held != nil {
+		d.writeHeld(ex)
+		ex.logger = d.exchangeLogger(ex, nil)
+	}
+}
+
+// elapsed returns " (after 12.345ms) " for the response dump line with
+// -log-timing, the time since the exchange started
+func (d *dumper) elapsed(ctx context.Context) string {
+	ex := exchangeFrom(ctx)
+	if !d.logTiming || ex == nil {
+		return ""
+	}
+	return " (after " + millis(time.Since(ex.start)) + "ms) "
+}
+
+// bodyEncoding returns the encoding used to decode a body for logging, taking
+// -assume-encoding into account: "auto" sniffs bodies sent without a
+// Content-Encoding, any other value overrides the header
+func (d *dumper) bodyEncoding(contentEncoding string) string {
+	switch d.assumeEncoding {
+	case "":
+		return contentEncoding
+	case "auto":
+		if contentEncoding == "" {
+			return "auto"
+		}
+		return contentEncoding
+	default:
+		return d.assumeEncoding
+	}
+}
+
+// bodyForLog prepares a decoded body for logging according to the dumper options
+func (d *dumper) bodyForLog(redact *debugproxy.Redactor, body []byte, contentType string) []byte {
+	body = redact.JSONBody(body, contentType)
+	for _, f := range d.formatters {
+		if f.match(contentType) {
+			return f.format(body, d.formatterTimeout)
+		}
+	}
+	if isCBOR(contentType) {
+		return formatCBOR(body)
+	}
+	if d.transcode {
+		body = transcodeToUTF8(body, contentType)
+	}
+	if d.pretty {
+		body = prettyBody(body, contentType)
+	}
+	return body
 }
 
 // Dump and log HTTP response headers and body
-func dumpHTTPResponse(resp *http.Response) {
-	headerDump, err := httputil.DumpResponse(resp, false)
+func (d *dumper) dumpHTTPResponse(resp *http.Response) {
+	if !d.releaseExchange(resp) {
+		return
+	}
+	logger := d.at(levelDebug, d.loggerFor(resp.Request.Context()))
+	headerDump, err := d.dumpResponseHead(resp)
 	if err != nil {
-		log.Printf("Error dumping response headers: %v", err)
+		logger.Printf("Error dumping response headers: %v", err)
 	} else {
-		log.Printf("----- RESPONSE HEADERS-----\n%s", headerDump)
+		logger.Printf("----- RESPONSE HEADERS%s-----\n%s", d.elapsed(resp.Request.Context()), headerDump)
+	}
+	if d.cacheHeaders {
+		logCacheHeaders(logger, resp.Header)
+	}
+	var reserved int64
+	if d.budget != nil {
+		data, rest, over, err := d.budget.take(resp.Body, resp.ContentLength)
+		if err != nil {
+			d.loggerFor(resp.Request.Context()).Printf("Error reading response body: %v", err)
+			return
+		}
+		if rest != nil {
+			captureResponse(resp, nil, true)
+			resp.Body = overBudget(rest, logger, "RESPONSE", over, func() { dumpResponseTrailers(logger, resp) })
+			return
+		}
+		resp.Body, reserved = io.NopCloser(bytes.NewReader(data)), int64(len(data))
+	}
+	rawBody, decodedBody, restore, err := readAndMaybeDecompressBody(resp.Body, d.bodyEncoding(resp.Header.Get("Content-Encoding")), "RESPONSE", d.at(levelWarn, d.loggerFor(resp.Request.Context())))
+	if err != nil {
+		d.loggerFor(resp.Request.Context()).Printf("Error reading response body: %v", err)
+		return
+	}
+	d.logBodies(resp.Request.Context(), logger, "RESPONSE", rawBody, decodedBody, resp.Header)
+	d.logBodyHash(logger, "RESPONSE", rawBody, decodedBody)
+	captureResponse(resp, decodedBody, false)
+	// the body was read to EOF, so the trailers are known
+	dumpResponseTrailers(logger, resp)
+	resp.Body = d.budget.releaseOnClose(restore(), reserved)
+}
+
+// dumpResponseTrailers logs the response trailers and, for gRPC, the call status
+func dumpResponseTrailers(logger *log.Logger, resp *http.Response) {
+	if len(resp.Trailer) > 0 {
+		if ex := exchangeFrom(resp.Request.Context()); ex != nil && ex.capture != nil {
+			ex.capture.setTrailers(nil, resp.Trailer)
+		}
+		var buf bytes.Buffer
+		_ = resp.Trailer.Write(&buf)
+		logger.Printf("----- RESPONSE TRAILERS -----\n%s", buf.Bytes())
+	}
+	logGRPCStatus(logger, resp)
+}
+
+// Dump response headers and log the body chunk by chunk as it is read
+// towards the client, instead of buffering it all first
+func (d *dumper) dumpStreamingHTTPResponse(resp *http.Response) {
+	if !d.releaseExchange(resp) {
+		return
 	}
-	_, decodedBody, restore, err := readAndMaybeDecompressBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	d.flushGroup(resp.Request.Context())
+	logger := d.at(levelDebug, d.loggerFor(resp.Request.Context()))
+	headerDump, err := d.dumpResponseHead(resp)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+		logger.Printf("Error dumping response headers: %v", err)
+	} else {
+		logger.Printf("----- RESPONSE HEADERS%s-----\n%s", d.elapsed(resp.Request.Context()), headerDump)
+	}
+	if d.cacheHeaders {
+		logCacheHeaders(logger, resp.Header)
+	}
+	captureResponse(resp, nil, true)
+	bodies := logsBodies(resp.Request.Context())
+	if bodies && d.ndjsonPreview > 0 && isNDJSON(resp.Header.Get("Content-Type")) && resp.Header.Get("Content-Encoding") == "" {
+		resp.Body = &ndjsonLoggingBody{
+			rc:      resp.Body,
+			logger:  logger,
+			label:   "RESPONSE",
+			preview: d.ndjsonPreview,
+			capture: d.ndjsonCapture,
+			onEOF:   func() { dumpResponseTrailers(logger, resp) },
+		}
+		return
+	}
+	resp.Body = &streamLoggingBody{
+		rc:          resp.Body,
+		logger:      logger,
+		label:       "RESPONSE",
+		start:       time.Now(),
+		onEOF:       func() { dumpResponseTrailers(logger, resp) },
+		summaryOnly: !bodies,
+	}
+}
+
+// streamLoggingBody logs every chunk read from the wrapped body. Chunks are
+// logged as they arrive on the wire, so encoded bodies are not decompressed.
+type streamLoggingBody struct {
+	rc     io.ReadCloser
+	logger *log.Logger
+	// label names the body in the log, REQUEST or RESPONSE
+	label string
+	// onEOF, when set, is called once the body was read to the end
+	onEOF func()
+	// summaryOnly logs the total size at the end instead of every chunk
+	summaryOnly bool
+	// start, when set, has every chunk logged with the time since then
+	start time.Time
+	total int64
+	done  bool
+}
+
+func (b *streamLoggingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.total += int64(n)
+	}
+	if n > 0 && !b.summaryOnly {
+		if b.start.IsZero() {
+			b.logger.Printf("----- %s BODY CHUNK (%d bytes) -----\n%s", b.label, n, p[:n])
+		} else {
+			b.logger.Printf("----- %s BODY CHUNK (%d bytes, +%s) -----\n%s", b.label, n, time.Since(b.start).Round(time.Millisecond), p[:n])
+		}
+	}
+	if errors.Is(err, io.EOF) && !b.done {
+		// the transport may read again after EOF
+		b.done = true
+		b.logger.Printf("----- %s BODY END (%d bytes) -----", b.label, b.total)
+		if b.onEOF != nil {
+			b.onEOF()
+		}
+	}
+	return n, err
+}
+
+func (b *streamLoggingBody) Close() error {
+	return b.rc.Close()
+}
+
+// logUpstream logs where an outgoing request is actually sent: the route that
+// matched, the upstream base URL and the path after any rewrites
+func (d *dumper) logUpstream(req *http.Request) {
+	ex := exchangeFrom(req.Context())
+	if ex == nil {
 		return
 	}
-	if decodedBody != nil {
-		log.Printf("----- RESPONSE BODY -----\n%s", decodedBody)
+	base := url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host}
+	line := fmt.Sprintf("Upstream: route=%s base=%s path=%s", ex.route, base.String(), d.sanitize.uri(req.URL.RequestURI()))
+	if ex.clientURI != req.URL.RequestURI() {
+		line += " (client path " + d.sanitize.uri(ex.clientURI) + ")"
+	}
+	d.at(levelDebug, ex.logger).Print(line)
+}
+
+// streamsResponse reports whether a response body is logged chunk by chunk
+// while it is forwarded instead of being buffered first. Buffering a
+// Server-Sent Events stream would hold it back until the backend closes it.
+func (d *dumper) streamsResponse(resp *http.Response) bool {
+	if d.flushInterval != 0 {
+		return true
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return true
 	}
-	resp.Body = restore()
+	return d.streamChunked && resp.ContentLength < 0 && resp.Body != http.NoBody
+}
+
+// streamsUpload reports whether a request body is logged while it is sent
+// instead of being buffered first: uploads of unknown length, or above the
+// configured size threshold
+func (d *dumper) streamsUpload(req *http.Request) bool {
+	return d.streamUploads && (req.ContentLength < 0 || req.ContentLength > d.streamUploadThreshold)
 }
 
-// Dump and log HTTP request headers and body
-func dumpHTTPRequest(req *http.Request) {
-	headerDump, err := httputil.DumpRequestOut(req, false)
+// Dump and log HTTP request headers and body. The body is logged for any
+// method that carries one (PROPFIND, REPORT, SEARCH, ...), not just
+// POST/PUT/PATCH: headers are dumped without the body and the body is read
+// and logged separately whenever the outgoing request has one.
+func (d *dumper) dumpHTTPRequest(req *http.Request) {
+	if req.Body != nil && (expectsContinue(req) || d.streamsUpload(req)) {
+		d.flushGroup(req.Context())
+	}
+	logger := d.at(levelDebug, d.loggerFor(req.Context()))
+	headerDump, err := httputil.DumpRequestOut(d.redactor(exchangeFrom(req.Context())).Request(d.sanitize.request(req)), false)
 	if err != nil {
-		log.Printf("Error dumping request headers: %v", err)
+		logger.Printf("Error dumping request headers: %v", err)
 	} else {
-		log.Printf("----- REQUEST HEADERS-----\n%s", headerDump)
+		if d.stripAcceptEncoding && req.Header.Get("Accept-Encoding") == "" {
+			// DumpRequestOut adds the gzip a default transport would ask for
+			headerDump = bytes.Replace(headerDump, []byte("Accept-Encoding: gzip\r\n"), nil, 1)
+		}
+		if d.logOriginal {
+			logger.Printf("----- FORWARDED REQUEST HEADERS (to backend) -----\n%s", headerDump)
+		} else {
+			logger.Printf("----- REQUEST HEADERS-----\n%s", headerDump)
+		}
+	}
+	d.logUpstream(req)
+	if d.decodeSigV4 {
+		logSigV4(logger, req)
 	}
 	if req.Body == nil {
+		d.checkDuplicate(req, nil)
+		captureRequest(req, nil)
+		return
+	}
+	if expectsContinue(req) {
+		// reading the body now would have the server send 100 Continue
+		// to the client before the backend agreed to receive it
+		req.Body = &continueBody{rc: req.Body, dumper: d, req: req, logger: logger, timeout: d.expectContinueTimeout}
+		return
+	}
+	if d.streamsUpload(req) {
+		// forward the upload right away, logging it as it is sent
+		req.Body = &streamLoggingBody{rc: req.Body, logger: logger, label: "REQUEST", onEOF: func() { d.forwardRequestTrailers(logger, req) }, summaryOnly: !logsBodies(req.Context())}
+		captureRequest(req, nil)
 		return
 	}
+	var reserved int64
+	if d.budget != nil {
+		data, rest, over, err := d.budget.take(req.Body, req.ContentLength)
+		if err != nil {
+			d.loggerFor(req.Context()).Printf("Error reading request body: %v", err)
+			return
+		}
+		if rest != nil {
+			captureRequest(req, nil)
+			req.Body = overBudget(rest, logger, "REQUEST", over, func() { d.forwardRequestTrailers(logger, req) })
+			return
+		}
+		req.Body, reserved = io.NopCloser(bytes.NewReader(data)), int64(len(data))
+	}
 	// Only decompress if Content-Encoding is set
-	_, decodedBody, restore, err := readAndMaybeDecompressBody(req.Body, req.Header.Get("Content-Encoding"))
+	rawBody, decodedBody, restore, err := readAndMaybeDecompressBody(req.Body, d.bodyEncoding(req.Header.Get("Content-Encoding")), "REQUEST", d.at(levelWarn, d.loggerFor(req.Context())))
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		d.loggerFor(req.Context()).Printf("Error reading request body: %v", err)
+		return
+	}
+	d.checkDuplicate(req, rawBody)
+	d.logBodies(req.Context(), logger, "REQUEST", rawBody, decodedBody, req.Header)
+	d.logBodyHash(logger, "REQUEST", rawBody, decodedBody)
+	// the body was read to EOF, so the trailers are known
+	d.forwardRequestTrailers(logger, req)
+	captureRequest(req, decodedBody)
+	keepFormFields(req, decodedBody)
+	req.Body = d.budget.releaseOnClose(restore(), reserved)
+}
+
+// forwardRequestTrailers logs the trailers the client sent after a chunked
+// request body and sets them on the outgoing request. The reverse proxy
+// copies the trailer map before the body is read, so without this the
+// backend gets the declared trailers without their values. Called once the
+// client's body was read to EOF.
+func (d *dumper) forwardRequestTrailers(logger *log.Logger, req *http.Request) {
+	ex := exchangeFrom(req.Context())
+	if ex == nil || len(req.Trailer) == 0 {
 		return
 	}
-	if decodedBody != nil {
-		log.Printf("----- REQUEST BODY -----\n%s", decodedBody)
+	for name := range req.Trailer {
+		if v, ok := ex.clientTrailer[name]; ok {
+			req.Trailer[name] = v
+		}
+	}
+	if ex.capture != nil {
+		ex.capture.setTrailers(req.Trailer, nil)
+	}
+	var buf bytes.Buffer
+	_ = req.Trailer.Write(&buf)
+	logger.Printf("----- REQUEST TRAILERS -----\n%s", buf.Bytes())
+}
+
+// DumpRequest dumps the request as a debugproxy.Dumper, right before the
+// loggingTransport sends it
+func (d *dumper) DumpRequest(ex *debugproxy.Exchange) {
+	d.dumpHTTPRequest(ex.Request)
+}
+
+// DumpResponse dumps the response as a debugproxy.Dumper: an upgrade with
+// its frames, a stream chunk by chunk and others once read whole
+func (d *dumper) DumpResponse(ex *debugproxy.Exchange) {
+	switch resp := ex.Response; {
+	case resp.StatusCode == http.StatusSwitchingProtocols:
+		d.dumpUpgradeResponse(resp)
+	case d.streamsResponse(resp):
+		d.dumpStreamingHTTPResponse(resp)
+	default:
+		d.dumpHTTPResponse(resp)
 	}
-	req.Body = restore()
 }
 
-// loggingTransport wraps an http.RoundTripper to dump requests
+// loggingTransport wraps an http.RoundTripper to pause, check, mirror and
+// trace the requests the dumper dumped
 type loggingTransport struct {
-	rt http.RoundTripper
+	rt     http.RoundTripper
+	dumper *dumper
 }
 
 func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	dumpHTTPRequest(req)
+	if t.dumper.intercept != nil {
+		if err := t.dumper.intercept.request(req, t.dumper); err != nil {
+			return nil, err
+		}
+	}
+	if t.dumper.guard != nil {
+		if resp := t.dumper.guard.checkRequest(req, t.dumper); resp != nil {
+			return resp, nil
+		}
+	}
+	if t.dumper.tee != nil {
+		t.dumper.tee.mirror(req)
+	}
+	if t.dumper.compressRequests {
+		t.dumper.compressRequest(req)
+	}
+	req = withContinueTrace(req)
+	if ex := exchangeFrom(req.Context()); t.dumper.log1xx || ex != nil && ex.capture != nil {
+		req = t.dumper.withInterimTrace(req)
+	}
+	if t.dumper.logBackendAddr {
+		req = t.dumper.withConnTrace(req)
+	}
+	if ex := exchangeFrom(req.Context()); ex != nil && ex.timing != nil {
+		req = withTimingTrace(req, ex.timing)
+	}
+	if ex := exchangeFrom(req.Context()); ex != nil && t.dumper.preserveHeaderCase {
+		preserveHeaderCase(req, ex.headerCasing)
+	}
+	if t.dumper.metrics != nil {
+		start := time.Now()
+		defer func() { t.dumper.metrics.observeUpstream(time.Since(start)) }()
+	}
+	if t.dumper.h2Streams != nil {
+		var end func()
+		req, end = t.dumper.h2Streams.trace(req, t.dumper.at(levelInfo, t.dumper.loggerFor(req.Context())))
+		resp, err := t.rt.RoundTrip(req)
+		if err != nil {
+			end()
+			return nil, err
+		}
+		resp.Body = &endOnClose{ReadCloser: resp.Body, end: end}
+		return resp, nil
+	}
 	return t.rt.RoundTrip(req)
 }
 
+// parseUpstreamProxy validates the URL of a proxy to chain outgoing requests through
+func parseUpstreamProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing upstream proxy host in %q", s)
+	}
+	return u, nil
+}
+
 func main() {
+	configFile := flag.String("config", "", "Read flags from this YAML file, name: value with lists for repeatable flags; flags given on the command line win. Routes, log filters, rewrites and redaction are reloaded on SIGHUP or when the file changes; the target, listeners and other flags need a restart")
 	listenAddr := flag.String("l", ":9191", "Listen address")
+	var listenerSpecs stringList
+	flag.Var(&listenerSpecs, "listener", "Also listen on addr and forward its requests to url, as addr=url such as :9192=http://localhost:8282; the listeners share the log, captures and web UI (repeatable)")
+	logCurl := flag.Bool("log-curl", false, "Log a curl command sending each request again through the proxy, with its headers (as redacted by -redact-headers) and body")
+	logTiming := flag.Bool("log-timing", false, "Log the DNS, connect, TLS, first byte, transfer and total times of every transaction, and the time to the response headers in their dump")
+	recordTimingCSV := flag.String("record-timing-csv", "", "Append a CSV row per transaction (timestamp, method, path, status, latency and DNS/connect/TLS/first byte phases) to this file")
+	maxResponseHeaders := flag.Int("max-response-headers", 0, "Warn about responses with more header lines than this (0 disables)")
+	truncateHeaders := flag.Bool("truncate-headers", false, "With -max-response-headers, log only that many response header lines; the forwarded response keeps them all")
+	resolveInterval := flag.Duration("resolve-interval", 0, "Resolve the target host every interval and send new connections to the current addresses, closing idle ones when they change (0 disables)")
+	targetSRV := flag.String("target-srv", "", "Take the target's host:port from these DNS SRV records (e.g. _http._tcp.backend.example) instead of the -t host; the -t URL still sets the scheme and Host header")
+	var blockPaths, throttlePaths stringList
+	flag.Var(&blockPaths, "block-paths", "Regexp of request paths answered with 403 and -block-body instead of being forwarded, as a WAF would (repeatable, any may match)")
+	blockBody := flag.String("block-body", "Forbidden\n", "Body of the 403 returned for -block-paths")
+	acceptContentTypes := flag.String("accept-content-types", "", "Comma-separated request content types forwarded; requests with a body of another type get 415 (application/ or application/* match a whole type; empty accepts all)")
+	throttleRate := flag.String("throttle", "", "Limit the bandwidth of each exchange, request body and response each way, to this rate, e.g. 256kbps, 2mbps or 64KB/s, as on a slow mobile link")
+	rpsLimit := flag.Float64("rps-limit", 0, "Admit at most this many requests per second, in bursts of up to as many, answering the others with 429 and Retry-After (0 disables)")
+	maxClientConcurrency := flag.Int("max-client-concurrency", 0, "Answer requests with 429 while their client IP already has this many in flight (0 disables)")
+	flag.Var(&throttlePaths, "throttle-paths", "Regexp of request paths -throttle, -rps-limit and -max-client-concurrency apply to (repeatable, any may match; unset applies them to all)")
+	slowStartDuration := flag.Duration("slow-start-duration", 0, "Throttle requests to the backend at startup, raising the allowed rate linearly to -slow-start-target-rate over this duration (0 disables)")
+	slowStartTargetRate := flag.Float64("slow-start-target-rate", 10, "Requests per second allowed at the end of -slow-start-duration; the ramp starts at a tenth of it")
+	compressRequestBodies := flag.Bool("compress-request", false, "Gzip request bodies before forwarding them, setting Content-Encoding: gzip (bodies already encoded, streamed or sent with Expect: 100-continue are left alone)")
+	gelfAddr := flag.String("gelf-addr", "", "Send a GELF message per transaction, with the full dump in additional fields, to this UDP address (host:port of a Graylog or Logstash GELF input)")
+	var transformStatuses stringList
+	flag.Var(&transformStatuses, "transform-status", "Rewrite backend responses with one status to another, as from=to such as 418=503; bodies are unchanged (repeatable)")
+	delayStatus := flag.String("delay-status", "5xx", "Statuses and classes (e.g. 500,503 or 5xx) of responses held back by -delay-status-duration")
+	delayStatusDuration := flag.Duration("delay-status-duration", 0, "Hold responses with a -delay-status status this long before returning them to the client (0 disables)")
+	rawForms := flag.Bool("raw-forms", false, "Log urlencoded and multipart form bodies as they are, instead of field by field with the name, type and size of uploaded files")
+	pretty := flag.Bool("pretty", false, "Reindent JSON and XML (including SOAP) bodies in the log and show binary ones as a hex dump preview; forwarded bodies are unchanged")
+	bodyJSONQuery := flag.String("body-json-query", "", "Log only these comma-separated dotted paths (e.g. user.id,items.*.sku) of JSON bodies, as path=value, instead of the whole body")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export a span per transaction to this OTLP/HTTP collector, e.g. http://localhost:4318, joining the client's traceparent trace")
+	stripAcceptEncoding := flag.Bool("strip-accept-encoding", false, "Remove Accept-Encoding from forwarded requests so the backend answers with uncompressed bodies")
+	htmlBannerText := flag.String("html-banner", "", "Insert a banner with this text after the <body> tag of text/html responses, e.g. to tell environments apart (not with -flush-interval)")
+	selfTest := flag.Bool("selftest", false, "Send a gzipped exchange through an internal loopback proxy, check that it is forwarded intact and logged decoded, then exit (non-zero on failure)")
+	showVersion := flag.Bool("version", false, "Print the version, git commit and build date, then exit")
 	targetService := flag.String("t", "http://localhost:8181", "Target service")
+	flushInterval := flag.Duration("flush-interval", 0, "Flush interval for response data to the client (negative flushes immediately); when set, response bodies are logged in chunks as they stream")
+	transcode := flag.Bool("transcode", false, "Transcode bodies declaring a non UTF-8 charset to UTF-8 for logging")
+	logIfHeader := flag.String("log-if-header", "", "Dump an exchange in full only when the response has this header, as Name:Value (empty value matches any value); other exchanges are summarized")
+	upstreamProxy := flag.String("upstream-proxy", "", "Chain outgoing requests through this proxy (http://, https:// or socks5:// URL)")
+	maxRequestBodyLog := flag.Int("max-request-body-log", 0, "Log at most this many bytes of each request body; the forwarded body stays complete (0 means no limit)")
+	maxResponseBodyLog := flag.Int("max-response-body-log", 0, "Log at most this many bytes of each response body; the forwarded body stays complete (0 means no limit)")
+	maxBodyLog := flag.Int("max-body-log", 0, "Log at most this many bytes of each request and response body, unless -max-request-body-log or -max-response-body-log is set (0 means no limit)")
+	maxLogLine := flag.Int("max-log-line", 0, "Truncate each log line to this many characters (0 means no limit)")
+	dupWindow := flag.Duration("dup-window", 0, "Warn when identical requests (method, path and body) repeat within this window (0 disables)")
+	maxRequests := flag.Uint64("max-requests", 0, "Shut down gracefully once this many transactions have completed (0 means unlimited)")
+	pidFile := flag.String("pid-file", "", "Write the process ID to this file, removed on shutdown")
+	var injectCookies stringList
+	flag.Var(&injectCookies, "inject-cookie", "Add a cookie to every forwarded request, as name=value (repeatable)")
+	listenBacklog := flag.Int("listen-backlog", 0, "Accept queue length of the listening socket (0 keeps the system default)")
+	keepAlivePeriod := flag.Duration("keepalive-period", 0, "TCP keep-alive period for client connections (0 uses the Go default, negative disables keep-alives)")
+	readTimeout := flag.Duration("read-timeout", 0, "Maximum duration for reading an entire client request, including the body (0 means no timeout)")
+	writeTimeout := flag.Duration("write-timeout", 0, "Maximum duration before timing out writes of a response to the client (0 means no timeout)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Maximum time to wait for the next request on an idle keep-alive client connection (0 falls back to -read-timeout)")
+	var bodyFormatters stringList
+	flag.Var(&bodyFormatters, "body-formatter", "Log bodies of a content type through an external command reading the body on stdin, as content-type=command (type/* matches a whole type, repeatable)")
+	maxIdleConns := flag.Int("max-idle-conns", 100, "Maximum idle (keep-alive) connections to backends in total (0 means no limit)")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "Maximum idle (keep-alive) connections kept per backend host")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0, "Maximum connections per backend host, in any state; requests over it wait for a connection (0 means no limit)")
+	idleConnTimeout := flag.Duration("idle-conn-timeout", 90*time.Second, "How long an idle keep-alive connection to a backend is kept before it is closed (0 means no limit)")
+	disableKeepAlive := flag.Bool("disable-keepalive", false, "Open a new backend connection for every request instead of reusing keep-alive connections")
+	dialTimeout := flag.Duration("dial-timeout", 30*time.Second, "Maximum time to open a TCP connection to a backend (0 means no limit beyond the operating system's)")
+	upstreamTimeout := flag.Duration("upstream-timeout", 0, "Maximum time to wait for the backend's response headers once the request was sent; slower backends are answered with -error-status (0 means no limit)")
+	bodyFormatterTimeout := flag.Duration("body-formatter-timeout", 5*time.Second, "Timeout for -body-formatter commands")
+	var tags stringList
+	flag.Var(&tags, "tag", "Label the log lines of requests whose path matches a regular expression, as regex=label (repeatable)")
+	var routeLogs stringList
+	var rewrites stringList
+	flag.Var(&rewrites, "rewrite", "Rewrite rule applied in flight, as op:args: set-request-header:Name=value, del-request-header:Name, set-response-header:Name=value, del-response-header:Name, host:name, path-prefix:/old=/new, request-body:regexp=replacement or response-body:regexp=replacement (repeatable, applied in order)")
+	rewriteFile := flag.String("rewrite-file", "", "File of -rewrite rules, one per line (# starts a comment), applied after the -rewrite flags")
+	var routeSpecs stringList
+	flag.Var(&routeSpecs, "route", "Send requests under a path prefix, optionally for one Host, to another target, as [host]/prefix=url followed by logging options such as log=headers, match-status=5xx or redact-headers=X-Api-Key (repeatable, longest prefix wins); other requests go to -t")
+	var backends stringList
+	flag.Var(&backends, "backend", "Backend of a weighted pool, as url=weight (repeatable); requests are spread over the pool instead of sent to -t")
+	backendHealthPath := flag.String("backend-health-path", "/", "Path requested on each -backend to check it is up")
+	backendHealthInterval := flag.Duration("backend-health-interval", 10*time.Second, "Time between -backend health checks; backends answering 5xx or not at all are skipped (0 disables the checks)")
+	flag.Var(&routeLogs, "route-log", "Set how much of the requests whose path matches a regular expression is dumped, as regex=full|headers|summary (repeatable, first match wins)")
+	expectContinueTimeout := flag.Duration("expect-continue-timeout", time.Second, "How long to wait for the backend's 100 Continue before sending the body of an Expect: 100-continue request")
+	protoset := flag.String("protoset", "", "Decode gRPC and protobuf bodies with the message types of this FileDescriptorSet (protoc --descriptor_set_out --include_imports)")
+	dumpDir := flag.String("dump-dir", "", "Save every request and response body to its own file in this directory, <id>-request.json etc., and log only the file name")
+	wiredumpDir := flag.String("wiredump-dir", "", "Write the raw bytes of every client and backend connection to files in this directory")
+	timestampFormat := flag.String("timestamp-format", "", "Go time layout for log timestamps, e.g. 2006-01-02T15:04:05.000Z07:00 (empty keeps the default format)")
+	utc := flag.Bool("utc", false, "Log timestamps in UTC instead of local time")
+	probe := flag.Bool("probe", false, "Check that the target answers before starting to serve, and exit otherwise")
+	probePath := flag.String("probe-path", "/", "Path requested on the target by -probe")
+	probeRetries := flag.Int("probe-retries", 0, "Number of times -probe retries while the target is not up")
+	probeInterval := flag.Duration("probe-interval", time.Second, "Time between -probe retries")
+	logFormat := flag.String("log-format", "text", "Format of exchange logs: text (multi-line dumps) or json (one JSON object per exchange, with headers and decoded bodies)")
+	logEvery := flag.Uint64("log-every", 1, "Fully dump only one exchange out of every N, summarizing the others")
+	errorStatus := flag.Int("error-status", http.StatusBadGateway, "Status returned to the client when the backend cannot be reached")
+	errorBody := flag.String("error-body", "", "Body returned to the client when the backend cannot be reached")
+	assumeEncoding := flag.String("assume-encoding", "", "Decode logged bodies with this encoding regardless of Content-Encoding (gzip, deflate, br or zstd), or auto to detect gzip and zstd bodies sent without the header")
+	tlsCert := flag.String("tls-cert", "", "Certificate file to terminate TLS on the listener (with -tls-key)")
+	tlsKey := flag.String("tls-key", "", "Private key file for -tls-cert")
+	forwardProxy := flag.Bool("forward-proxy", false, "Also act as a forward proxy: requests in absolute form (HTTP_PROXY clients) go to the host they name, and CONNECT opens a tunnel")
+	mitm := flag.Bool("mitm", false, "With -forward-proxy, intercept CONNECT tunnels with certificates signed by -mitm-ca-cert so HTTPS exchanges are dumped too")
+	mitmCACert := flag.String("mitm-ca-cert", "mitm-ca.pem", "CA certificate signing -mitm certificates; generated with -mitm-ca-key when neither file exists")
+	mitmCAKey := flag.String("mitm-ca-key", "mitm-ca-key.pem", "Private key of -mitm-ca-cert")
+	tlsSelfSigned := flag.Bool("tls-self-signed", false, "Terminate TLS on the listener with a certificate generated at startup, for clients that refuse plaintext (instead of -tls-cert)")
+	decodeSigV4 := flag.Bool("decode-sigv4", false, "Log the credential scope, signed headers and signature of AWS SigV4 Authorization headers separately; the signature is not checked and the header is forwarded as is")
+	logALPN := flag.Bool("log-alpn", false, "Log the protocol (h2, http/1.1) each TLS client negotiated with ALPN, with -tls-cert")
+	logSNI := flag.Bool("log-sni", false, "Log the TLS server name (SNI) requested by clients, with -tls-cert")
+	requestIDHeader := flag.String("request-id-header", "", "Correlate exchanges by this request header (e.g. X-Request-ID): the client's value is used as the exchange ID, or one is generated, and it is sent to the backend and echoed on the response")
+	forwardedHeader := flag.Bool("forwarded-header", false, "Append an RFC 7239 Forwarded entry (for, proto, host) to forwarded requests, next to X-Forwarded-For")
+	xffMode := flag.String("xff", "append", "X-Forwarded-For of forwarded requests: append the client address to the client's own, replace it with the client address, or strip the X-Forwarded-* and Forwarded headers")
+	noXFF := flag.Bool("no-xff", false, "Shorthand for -xff strip")
+	setForwardedHostProto := flag.Bool("x-forwarded-host-proto", false, "Set X-Forwarded-Host and X-Forwarded-Proto to the Host and scheme the client used")
+	preserveHost := flag.Bool("preserve-host", true, "Send the client's Host to the backend; with -preserve-host=false the backend gets its own host")
+	addVia := flag.Bool("add-via", true, "Add the proxy to the Via header of forwarded requests and of responses")
+	serverHeader := flag.String("server-header", "", "Replace the Server header of responses with this value, or remove it with \"-\"")
+	rewriteLocation := flag.Bool("rewrite-location", false, "Rewrite Location headers redirecting to the target so they point at the proxy")
+	streamChunked := flag.Bool("stream-chunked", false, "Log responses of unknown length (chunked) chunk by chunk as they are forwarded, as Server-Sent Events always are, instead of buffering them")
+	streamUploads := flag.Bool("stream-uploads", false, "Log request bodies of unknown length or above -stream-upload-threshold chunk by chunk while forwarding them, instead of buffering them")
+	streamUploadThreshold := flag.Int64("stream-upload-threshold", 1<<20, "Content-Length above which -stream-uploads streams a request body")
+	rawRequest := flag.Bool("raw-request", false, "Log the request line and headers exactly as received from the client (plain HTTP/1.x listeners only)")
+	normalizeHeaders := flag.Bool("normalize-headers", false, "Log the header names the client sent in another casing than the canonical one they are forwarded with (plain HTTP/1.x listeners only)")
+	preserveHeaderCase := flag.Bool("preserve-header-case", false, "Forward header names in the casing the client sent instead of the canonical one, to HTTP/1 backends (plain HTTP/1.x listeners only)")
+	uiAddr := flag.String("ui-addr", "", "Serve a web UI to browse recent transactions on this address, e.g. localhost:9192")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. localhost:9193; with -ui-addr they are also served there")
+	uiSize := flag.Int("ui-size", 100, "Number of recent transactions kept for the web UI")
+	var captureFilters stringList
+	var interceptTerms stringList
+	flag.Var(&interceptTerms, "intercept", "Pause exchanges matching method=GET,POST, path=regex, exclude-path=regex or status=4xx,5xx until they are released, edited or dropped through the -ui-addr API (repeatable, all must match)")
+	interceptAt := flag.String("intercept-at", "requests", "Pause intercepted exchanges before forwarding the request (requests), before returning the response (responses), or both")
+	interceptTimeout := flag.Duration("intercept-timeout", 5*time.Minute, "Release a paused message unchanged after this long (0 waits without limit)")
+	var matchPaths, excludePaths stringList
+	flag.Var(&matchPaths, "match-path", "Only log exchanges whose path matches this regular expression (repeatable, any may match)")
+	flag.Var(&excludePaths, "exclude-path", "Do not log exchanges whose path matches this regular expression, e.g. '\\.(png|css|js)$' (repeatable)")
+	matchMethod := flag.String("match-method", "", "Only log exchanges with one of these comma-separated request methods, e.g. POST,PUT")
+	matchStatus := flag.String("match-status", "", "Only log exchanges whose response status is in this list of codes and classes, e.g. 4xx,5xx; matching exchanges are held until the response arrives")
+	flag.Var(&captureFilters, "capture-filter", "Only keep transactions matching method=GET,POST, path=regex or status=4xx,5xx for the web UI; all are logged (repeatable, all must match)")
+	allowTargetOverride := flag.Bool("allow-target-override", false, "Let clients route a request to another target with the -target-override-param query parameter (unsafe, lets callers pick the upstream)")
+	targetOverrideParam := flag.String("target-override-param", "__target", "Query parameter read by -allow-target-override, stripped before forwarding")
+	harPath := flag.String("har", "", "Keep every exchange in memory and write them to this HAR 1.2 file on shutdown (SIGINT or SIGTERM)")
+	httpFileDir := flag.String("http-file-dir", "", "Save each exchange to a .http file in this directory (method, URL, headers and decoded body, the response as comments) to send it again from an editor REST client")
+	bodySaveOnError := flag.String("body-save-on-error", "", "Save the decoded request and response bodies of exchanges answered with an error status to files in this directory")
+	bodySaveStatus := flag.Int("body-save-status", 500, "Lowest response status saved by -body-save-on-error")
+	maxBufferedBytes := flag.Int64("max-buffered-bytes", 0, "Bound the body bytes buffered for logging across all concurrent requests; bodies over the budget are streamed and only their size logged (0 means no limit)")
+	skipBodyOver := flag.Int64("skip-body-over", 0, "Stream bodies larger than this many bytes without buffering or logging them, only their size (0 means no limit)")
+	openAPIFile := flag.String("openapi", "", "Check every exchange against this OpenAPI 3 spec (YAML or JSON) and log the violations: unknown paths and methods, missing or mistyped parameters and JSON fields, undocumented statuses and content types")
+	cacheResponses := flag.Bool("cache", false, "Answer repeated GET requests from an in-memory cache honoring Cache-Control, Expires and ETag/Last-Modified revalidation, logging HIT, MISS or REVALIDATED per exchange and setting X-Cache on responses")
+	cacheTTL := flag.Duration("cache-ttl", 0, "With -cache, keep every cacheable GET response this long whatever its caching headers say (0 follows the headers)")
+	cacheMaxEntries := flag.Int("cache-max-entries", 1000, "Most responses -cache keeps, evicting the oldest (0 means no limit)")
+	cacheHeaders := flag.Bool("cache-headers", false, "Log the caching headers of each response (Cache-Control, ETag, Age, Vary...) on one compact line")
+	recordPath := flag.String("record", "", "Write every exchange to this file as it completes, in the -replay-fixture format")
+	replayFixture := flag.String("replay-fixture", "", "Serve recorded responses from this file, as saved from the web UI's /api/export, matching requests by method and path")
+	replayTemplate := flag.Bool("replay-template", false, "With -replay-fixture, render recorded response bodies containing {{ as Go text/template, with now, unix, uuid and randInt helpers")
+	replayMatchBody := flag.Bool("replay-match-body", false, "With -replay-fixture, also match requests by body")
+	replayFallthrough := flag.Bool("replay-fallthrough", false, "With -replay-fixture, forward unmatched requests to the target instead of answering 404")
+	stubsFile := flag.String("stubs", "", "YAML or JSON file of stub rules (method, path regexp, status, headers, body or body_file) answering matching requests without the backend; other requests are forwarded")
+	logIDs := flag.Bool("log-ids", false, "Start every log message of an exchange with its ID, [#N] or the -request-id-header value, to pair requests with their responses")
+	groupLogs := flag.Bool("group-logs", false, "Hold each exchange's request and response dumps and log them as one block once the exchange finishes; streamed exchanges are logged as they go")
+	logFile := flag.String("log-file", "", "Append the log to this file instead of writing it to stderr")
+	errorsToStderr := flag.Int("errors-to-stderr", 0, "With -log-file, also write the log output of exchanges with a status at or above this one (e.g. 500) to stderr (0 disables)")
+	histogramInterval := flag.Duration("histogram-interval", 0, "Log an ASCII histogram of the request latencies of every interval this long (0 disables)")
+	verbosity := flag.String("v", "debug", "Log level: error (proxy failures), warn, info (one line per request) or debug (full dumps)")
+	quiet := flag.Bool("q", false, "Log one summary line per request, without headers and bodies; short for -v info")
+	colorMode := flag.String("color", "auto", "Color the log: auto (when it goes to a terminal and NO_COLOR is unset), always or never")
+	logOriginal := flag.Bool("log-original", false, "Also log the request headers as received from the client, before any rewrite, next to the forwarded request")
+	sanitizeURLs := flag.String("sanitize-urls", defaultSanitizedParams, "Comma-separated query parameters whose values are replaced with [REDACTED] in logged URLs (empty disables); forwarded URLs are unchanged")
+	redactHeaders := flag.String("redact-headers", debugproxy.DefaultRedactedHeaders, "Comma-separated headers whose values are replaced with [REDACTED] in the log (empty disables); forwarded headers are unchanged")
+	redactJSON := flag.String("redact-json", "", "Comma-separated JSON field names, matched at any depth, whose values are replaced with [REDACTED] in logged bodies, e.g. password,token; forwarded bodies are unchanged")
+	wsInflate := flag.Bool("ws-inflate", false, "Decompress WebSocket messages sent with permessage-deflate for logging; frames are forwarded untouched")
+	drainTimeout := flag.Duration("drain-timeout", 0, "On shutdown, wait this long for streaming responses and WebSocket connections to end, then close them (0 waits for requests forever and does not wait for WebSockets)")
+	ndjsonPreview := flag.Int("ndjson-preview", 0, "With -flush-interval, log streamed NDJSON responses record by record, keeping only the first this many bytes of each (0 logs chunks as they arrive)")
+	ndjsonCapture := flag.String("ndjson-capture", "", "With -ndjson-preview, also append the full records to this file")
+	hashBodies := flag.String("hash-bodies", "", "Log the SHA-256 of each request and response body, of the \"decoded\" or \"raw\" bytes")
+	scriptFile := flag.String("script", "", "Starlark file defining on_request(req) and/or on_response(resp) hooks that may modify headers and decoded bodies")
+	ringSize := flag.Int("ring-size", 0, "Keep the last this many transactions in memory and log them in full on SIGUSR1 (0 disables)")
+	backendSNI := flag.String("backend-sni", "", "TLS server name sent to https:// backends and verified in their certificate, for backends reached by IP")
+	clientCert := flag.String("client-cert", "", "Certificate file presented to the backend for mutual TLS")
+	clientKey := flag.String("client-key", "", "Private key file for -client-cert")
+	backendCA := flag.String("backend-ca", "", "PEM bundle of CA certificates trusted, besides the system ones, to verify TLS backends")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "Do not verify the certificates of TLS backends")
+	teeTarget := flag.String("tee", "", "Shadow target receiving a copy of every forwarded request in the background; its responses are discarded")
+	shadowTarget := flag.String("shadow", "", "Diff mode: also send every request to this backend, return the -t response to the client, and log how the shadow response differs in status, headers and body (JSON field by field)")
+	shadowIgnoreHeaders := flag.String("shadow-ignore-headers", "Date,Age,Expires,Server,X-Request-Id,Traceparent", "Comma-separated response headers left out of -shadow comparisons")
+	teeHeadersOnly := flag.Bool("tee-headers-only", false, "Mirror only the request line and headers to -tee, without the body")
+	serialize := flag.Bool("serialize", false, "Forward one request at a time in arrival order, the next one waiting until the previous response was sent, to mimic a backend that serves requests serially")
+	failoverTarget := flag.String("failover", "", "Standby target; requests are sent to it again when the primary fails to connect or answers with a -failover-on status")
+	failoverOn := flag.String("failover-on", "5xx", "Comma-separated statuses and classes (e.g. 502,503 or 5xx) of primary responses that trigger -failover")
+	delay := flag.Duration("delay", 0, "Hold every request back this long before forwarding it")
+	delayJitter := flag.Duration("delay-jitter", 0, "Add a random delay up to this long to -delay")
+	failRate := flag.Float64("fail-rate", 0, "Probability, between 0 and 1, of answering a request with -fail-rate-status instead of forwarding it")
+	failRateStatus := flag.Int("fail-rate-status", http.StatusServiceUnavailable, "Status of the responses injected by -fail-rate")
+	var forceStatuses stringList
+	flag.Var(&forceStatuses, "force-status", "Answer requests whose path matches a regular expression with a status instead of forwarding them, as regexp=status (repeatable, first match wins)")
+	faultSeed := flag.Uint64("fault-seed", 0, "Seed of the random -delay-jitter and -fail-rate choices, to repeat a run (0 picks one, logged at startup)")
+	dropRate := flag.Float64("drop-rate", 0, "Probability, between 0 and 1, of closing the client connection abruptly in the middle of a response body")
+	dropSeed := flag.Uint64("drop-seed", 0, "Seed of the random -drop-rate selection, to repeat a run (0 picks one, logged at startup)")
+	canaryTarget := flag.String("canary-target", "", "Second target receiving -canary-percent of the requests; their log lines are tagged [canary]")
+	canaryPercent := flag.Float64("canary-percent", 10, "Percentage of requests sent to -canary-target")
+	canarySeed := flag.Uint64("canary-seed", 0, "Seed of the random canary selection, to repeat a run (0 picks one, logged at startup)")
+	decodeBase64Fields := flag.String("decode-base64-fields", "", "Comma-separated JSON paths (e.g. data.blob,items.*.payload) whose base64 string values are logged decoded after JSON bodies")
+	failOnBodyPattern := flag.String("fail-on-body-pattern", "", "Replace responses whose decoded body matches this regexp with a -fail-status error, logging the violation (streamed responses are not checked)")
+	failOnRequestBodyPattern := flag.String("fail-on-request-body-pattern", "", "Answer requests whose decoded body matches this regexp with a -fail-status error instead of forwarding them")
+	failStatus := flag.Int("fail-status", http.StatusBadGateway, "Status of the error returned for -fail-on-body-pattern and -fail-on-request-body-pattern")
+	backendH2 := flag.Bool("backend-h2", false, "Speak only HTTP/2 to the backend: negotiated over TLS with https:// targets, h2c with prior knowledge with http:// targets")
+	listenH2C := flag.Bool("listen-h2c", false, "Also accept unencrypted HTTP/2 (h2c with prior knowledge) on a plaintext listener, as gRPC clients send it")
+	logH2Streams := flag.Bool("log-h2-streams", false, "Log when each request's stream starts and ends on its HTTP/2 backend connection, to see how requests are multiplexed")
+	logBackendAddr := flag.Bool("log-backend-addr", false, "Log the backend address (IP and port) each request is sent on, and whether the connection was reused")
+	log1xx := flag.Bool("log-1xx", false, "Log interim 1xx responses from the backend, such as 103 Early Hints, with their headers")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	if *selfTest {
+		logs, err := runSelfTest()
+		if err != nil {
+			log.Printf("Self-test log:\n%s", logs)
+			log.Fatalf("Self-test failed: %v", err)
+		}
+		log.Printf("Self-test passed: gzipped request and response bodies were forwarded intact and logged decoded")
+		return
+	}
+	var config *configReloader
+	if *configFile != "" {
+		var err error
+		if config, err = newConfigReloader(*configFile); err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+	}
+	level, err := parseLogLevel(*verbosity)
+	if err != nil {
+		log.Fatalf("Error parsing -v: %v", err)
+	}
+	if *quiet {
+		level = levelInfo
+	}
+	sink := &logSink{out: log.Writer(), layout: *timestampFormat, utc: *utc, level: level}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Error opening log file: %v", err)
+		}
+		defer f.Close()
+		sink.out = f
+	}
+	var mirror *errorMirror
+	if *errorsToStderr > 0 {
+		if *logFile == "" {
+			log.Fatalf("-errors-to-stderr needs -log-file, the log already goes to stderr")
+		}
+		mirror = &errorMirror{out: os.Stderr, threshold: *errorsToStderr}
+		if *maxLogLine > 0 {
+			mirror.out = newLineCapWriter(mirror.out, *maxLogLine)
+		}
+	}
+	colored, err := useColor(*colorMode, sink.out)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	// JSON records are for programs, escape codes would break them
+	if colored && (*logFormat != "json" || *colorMode == "always") {
+		sink.out = &colorWriter{w: sink.out}
+	}
+	if *maxLogLine > 0 {
+		sink.out = newLineCapWriter(sink.out, *maxLogLine)
+	}
+	std := sink.logger(nil, "")
+	log.SetOutput(std.Writer())
+	log.SetFlags(std.Flags())
+
 	target, err := url.Parse(*targetService)
 	if err != nil {
 		log.Fatalf("Error parsing target service: %v", err)
 	}
-
-	// Create the reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = &loggingTransport{rt: http.DefaultTransport}
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		dumpHTTPResponse(resp)
-		return nil
+	var pool *backendPool
+	if len(backends) > 0 {
+		if pool, err = parseBackendPool(backends); err != nil {
+			log.Fatalf("Error parsing -backend: %v", err)
+		}
+		// the first backend stands for the target elsewhere, e.g. for -probe
+		target = pool.backends[0].url
 	}
 
-	log.Printf("Starting proxy server on %s -> forwarding to %s\n", *listenAddr, target)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		proxy.ServeHTTP(w, r)
-	})
-
-	if err := http.ListenAndServe(*listenAddr, nil); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Server failed: %v", err)
+	// All traffic dumps go through the dumper's logger; tests can swap in their own
+	d := &dumper{logger: log.Default(), sink: sink, transcode: *transcode, formatterTimeout: *bodyFormatterTimeout, logEvery: *logEvery, assumeEncoding: *assumeEncoding, logSNI: *logSNI, logALPN: *logALPN, decodeSigV4: *decodeSigV4, rawRequest: *rawRequest, normalizeHeaders: *normalizeHeaders, preserveHeaderCase: *preserveHeaderCase}
+	d.streamUploads, d.streamUploadThreshold = *streamUploads, *streamUploadThreshold
+	d.groupLogs = *groupLogs
+	d.logIDs = *logIDs
+	switch *logFormat {
+	case "text":
+	case "json":
+		d.jsonLog = true
+	default:
+		log.Fatalf("-log-format must be text or json")
+	}
+	d.errorMirror = mirror
+	d.log1xx, d.cacheHeaders, d.logOriginal = *log1xx, *cacheHeaders, *logOriginal
+	d.logBackendAddr = *logBackendAddr
+	d.stripAcceptEncoding = *stripAcceptEncoding
+	d.requestIDHeader = http.CanonicalHeaderKey(*requestIDHeader)
+	if *otlpEndpoint != "" {
+		if d.spans, err = newSpanExporter(*otlpEndpoint, d.logger); err != nil {
+			log.Fatalf("Error parsing -otlp-endpoint: %v", err)
+		}
+	}
+	if *failOnBodyPattern != "" || *failOnRequestBodyPattern != "" {
+		guard, err := newBodyGuard(*failOnRequestBodyPattern, *failOnBodyPattern, *failStatus)
+		if err != nil {
+			log.Fatalf("Error parsing body guard: %v", err)
+		}
+		d.guard = guard
+	}
+	d.sanitize = parseQuerySanitizer(*sanitizeURLs)
+	d.rawForms = *rawForms
+	d.wsInflate = *wsInflate
+	if *drainTimeout > 0 {
+		d.streams = newStreamTracker()
+	}
+	d.ndjsonPreview = *ndjsonPreview
+	d.flushInterval, d.streamChunked = *flushInterval, *streamChunked
+	d.base64Fields = parseJSONPaths(*decodeBase64Fields)
+	d.jsonQuery = parseJSONPaths(*bodyJSONQuery)
+	d.pretty = *pretty
+	d.compressRequests = *compressRequestBodies
+	if *gelfAddr != "" {
+		if d.gelf, err = newGELFSender(*gelfAddr, d); err != nil {
+			log.Fatalf("Error opening -gelf-addr: %v", err)
+		}
+	}
+	d.maxResponseHeaders, d.truncateHeaders = *maxResponseHeaders, *truncateHeaders
+	d.maxRequestBodyLog, d.maxResponseBodyLog = *maxRequestBodyLog, *maxResponseBodyLog
+	if d.maxRequestBodyLog == 0 {
+		d.maxRequestBodyLog = *maxBodyLog
+	}
+	if d.maxResponseBodyLog == 0 {
+		d.maxResponseBodyLog = *maxBodyLog
+	}
+	d.logTiming = *logTiming
+	d.logCurl = *logCurl
+	if *recordTimingCSV != "" {
+		if d.timingCSV, err = openTimingCSV(*recordTimingCSV); err != nil {
+			log.Fatalf("Error opening timing CSV: %v", err)
+		}
+	}
+	if *ringSize > 0 {
+		d.ring = newCaptureStore(*ringSize)
+		dumpSignals := make(chan os.Signal, 1)
+		if notifyRingDump(dumpSignals) {
+			go func() {
+				for range dumpSignals {
+					d.dumpRing(d.logger)
+				}
+			}()
+		} else {
+			log.Printf("-ring-size needs SIGUSR1, which this platform does not have")
+		}
+	}
+	switch *hashBodies {
+	case "", "decoded", "raw":
+		d.hashBodies = *hashBodies
+	default:
+		log.Fatalf("Unsupported -hash-bodies %q, expected decoded or raw", *hashBodies)
+	}
+	if *ndjsonCapture != "" {
+		f, err := os.OpenFile(*ndjsonCapture, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Error opening NDJSON capture file: %v", err)
+		}
+		defer f.Close()
+		d.ndjsonCapture = &recordFile{w: f}
+	}
+	if *maxBufferedBytes > 0 || *skipBodyOver > 0 {
+		d.budget = &bufferBudget{max: *maxBufferedBytes, perBody: *skipBodyOver}
+	}
+	if *bodySaveOnError != "" {
+		d.errorSaver, err = newErrorSaver(*bodySaveOnError, *bodySaveStatus, d.logger)
+		if err != nil {
+			log.Fatalf("Error creating body save directory: %v", err)
+		}
+	}
+	if *recordPath != "" {
+		if d.record, err = newFixtureRecorder(*recordPath); err != nil {
+			log.Fatalf("Error creating record file: %v", err)
+		}
+		log.Printf("Recording exchanges to %s, replay them with -replay-fixture %s", *recordPath, *recordPath)
+	}
+	if *harPath != "" {
+		d.har = &harSession{path: *harPath}
+	}
+	if *httpFileDir != "" {
+		d.httpFiles, err = newHTTPFileWriter(*httpFileDir, d.logger)
+		if err != nil {
+			log.Fatalf("Error creating .http file directory: %v", err)
+		}
+	}
+	if *uiAddr != "" {
+		if *uiSize <= 0 {
+			log.Fatalf("-ui-size must be positive")
+		}
+		d.captures = newCaptureStore(*uiSize)
+	}
+	if *uiAddr != "" || *metricsAddr != "" {
+		d.metrics = newProxyMetrics()
+	}
+	if len(captureFilters) > 0 {
+		if d.captures == nil {
+			log.Fatalf("-capture-filter requires -ui-addr")
+		}
+		f, err := parseExchangeFilter(captureFilters)
+		if err != nil {
+			log.Fatalf("Error parsing -capture-filter: %v", err)
+		}
+		d.captureFilter = f
+	}
+	if len(interceptTerms) > 0 {
+		if *uiAddr == "" {
+			log.Fatalf("-intercept requires -ui-addr")
+		}
+		f, err := parseExchangeFilter(interceptTerms)
+		if err != nil {
+			log.Fatalf("Error parsing -intercept: %v", err)
+		}
+		if d.intercept, err = newInterceptor(f, *interceptAt, *interceptTimeout, *uiAddr); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		log.Printf("Intercepting %s matching %s; paused messages are listed at http://%s/api/intercepts", *interceptAt, strings.Join(interceptTerms, " "), *uiAddr)
+	}
+	switch *assumeEncoding {
+	case "", "auto", "gzip", "deflate", "br", "zstd", "identity":
+	default:
+		log.Fatalf("Unsupported -assume-encoding %q", *assumeEncoding)
+	}
+	for _, spec := range tags {
+		rule, err := parseTagRule(spec)
+		if err != nil {
+			log.Fatalf("Error parsing -tag: %v", err)
+		}
+		d.tags = append(d.tags, rule)
+	}
+	for _, spec := range routeLogs {
+		rule, err := parseRouteLogRule(spec)
+		if err != nil {
+			log.Fatalf("Error parsing -route-log: %v", err)
+		}
+		d.routeLogs = append(d.routeLogs, rule)
+	}
+	for _, spec := range bodyFormatters {
+		f, err := parseBodyFormatter(spec)
+		if err != nil {
+			log.Fatalf("Error parsing -body-formatter: %v", err)
+		}
+		d.formatters = append(d.formatters, f)
+	}
+	if *dupWindow > 0 {
+		d.dups = newDupDetector(*dupWindow)
+	}
+	if *logIfHeader != "" {
+		d.logIf, err = parseHeaderMatch(*logIfHeader)
+		if err != nil {
+			log.Fatalf("Error parsing -log-if-header: %v", err)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ExpectContinueTimeout = *expectContinueTimeout
+	transport.MaxIdleConns = *maxIdleConns
+	transport.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+	transport.MaxConnsPerHost = *maxConnsPerHost
+	transport.IdleConnTimeout = *idleConnTimeout
+	transport.DisableKeepAlives = *disableKeepAlive
+	transport.ResponseHeaderTimeout = *upstreamTimeout
+	transport.DialContext = (&net.Dialer{Timeout: *dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	log.Printf("Backend connections: %s", describeTransport(transport, *dialTimeout))
+	d.expectContinueTimeout = *expectContinueTimeout
+	if *upstreamProxy != "" {
+		proxyURL, err := parseUpstreamProxy(*upstreamProxy)
+		if err != nil {
+			log.Fatalf("Error parsing upstream proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		log.Printf("Chaining outgoing requests through %s", proxyURL.Redacted())
+	}
+
+	if *clientCert != "" || *clientKey != "" {
+		if *clientCert == "" || *clientKey == "" {
+			log.Fatalf("-client-cert and -client-key must be set together")
+		}
+		cert, leaf, err := loadClientCertificate(*clientCert, *clientKey)
+		if err != nil {
+			log.Fatalf("Error loading client certificate: %v", err)
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		log.Printf("Presenting client certificate to the backend: subject=%q issuer=%q expires=%s", leaf.Subject, leaf.Issuer, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	if *backendCA != "" {
+		pool, n, err := loadCABundle(*backendCA)
+		if err != nil {
+			log.Fatalf("Error loading backend CA bundle: %v", err)
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = pool
+		log.Printf("Trusting %d CA certificates from %s for TLS backends", n, *backendCA)
+	}
+
+	if *insecureSkipVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		log.Printf("Warning: TLS backend certificates are not verified (-insecure-skip-verify)")
+	}
+
+	if *backendSNI != "" {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		// also the name the backend certificate is verified against
+		transport.TLSClientConfig.ServerName = *backendSNI
+		log.Printf("Sending SNI %q to TLS backends, whatever host they are reached at", *backendSNI)
+	}
+
+	if *backendH2 {
+		transport.Protocols = new(http.Protocols)
+		if target.Scheme == "http" {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		} else {
+			transport.Protocols.SetHTTP2(true)
+		}
+		log.Printf("Speaking only HTTP/2 to the backend")
+	}
+
+	if *logH2Streams {
+		d.h2Streams = newH2Streams(transport.IdleConnTimeout)
+	}
+
+	if *targetSRV != "" || *resolveInterval > 0 {
+		resolver, err := newTargetResolver(target, *targetSRV, *resolveInterval, d.at(levelInfo, d.logger), transport.CloseIdleConnections)
+		if err != nil {
+			log.Fatalf("Error resolving the target: %v", err)
+		}
+		if *resolveInterval > 0 {
+			go resolver.refresh()
+		}
+		transport.DialContext = resolver.dialer(transport.DialContext)
+	}
+
+	var wd *wireDumper
+	if *protoset != "" {
+		if d.protoset, err = loadProtoset(*protoset); err != nil {
+			log.Fatalf("Error loading protoset: %v", err)
+		}
+	}
+	if *dumpDir != "" {
+		if d.bodyDump, err = newBodyDumper(*dumpDir); err != nil {
+			log.Fatalf("Error creating body dump directory: %v", err)
+		}
+	}
+	if *wiredumpDir != "" {
+		wd, err = newWireDumper(*wiredumpDir, d.logger)
+		if err != nil {
+			log.Fatalf("Error creating wire dump directory: %v", err)
+		}
+		transport.DialContext = wd.dialer(transport.DialContext)
+	}
+
+	if *probe {
+		probeURL := target.JoinPath(*probePath).String()
+		if err := probeBackend(transport, probeURL, *probeRetries, *probeInterval); err != nil {
+			log.Fatalf("Backend probe of %s failed: %v", probeURL, err)
+		}
+	}
+
+	// Create the director chain of the reverse proxy
+	director := httputil.NewSingleHostReverseProxy(target).Director
+	if pool != nil {
+		// the pool takes the place of the single target; the directors
+		// below, -forward-proxy's included, wrap it
+		director = pool.director(d)
+		if *backendHealthInterval > 0 {
+			go pool.checkHealth(transport, *backendHealthPath, *backendHealthInterval, d.at(levelWarn, d.logger))
+		}
+		log.Printf("Backend pool: %s", pool)
+	}
+	var connect *connectHandler
+	if *mitm && !*forwardProxy {
+		log.Fatalf("-mitm requires -forward-proxy")
+	}
+	if *forwardProxy {
+		director = forwardProxyDirector(director)
+		connect = &connectHandler{dumper: d, dial: transport.DialContext}
+		if connect.dial == nil {
+			connect.dial = (&net.Dialer{}).DialContext
+		}
+		if *mitm {
+			ca, generated, err := loadMITMCA(*mitmCACert, *mitmCAKey)
+			if err != nil {
+				log.Fatalf("Error loading MITM CA: %v", err)
+			}
+			connect.ca = ca
+			if generated {
+				log.Printf("Generated MITM CA %s (key %s); make clients trust it to intercept HTTPS", *mitmCACert, *mitmCAKey)
+			}
+		}
+		mode := "passed through"
+		if *mitm {
+			mode = "intercepted"
+		}
+		log.Printf("Forward proxy mode: absolute-form requests go to the host they name, CONNECT tunnels are %s", mode)
+	}
+	var routes liveRoutes
+	var rewriteRules liveRewrites
+	// applyLive uses the settings -config reloads change: routes, log
+	// filters, rewrites and redaction
+	applyLive := func() error {
+		newRoutes, err := parseRoutes(routeSpecs)
+		if err != nil {
+			return fmt.Errorf("parsing -route: %w", err)
+		}
+		var logFilterTerms []string
+		for _, re := range matchPaths {
+			logFilterTerms = append(logFilterTerms, "path="+re)
+		}
+		for _, re := range excludePaths {
+			logFilterTerms = append(logFilterTerms, "exclude-path="+re)
+		}
+		if *matchMethod != "" {
+			logFilterTerms = append(logFilterTerms, "method="+*matchMethod)
+		}
+		if *matchStatus != "" {
+			logFilterTerms = append(logFilterTerms, "status="+*matchStatus)
+		}
+		var logFilter *exchangeFilter
+		if len(logFilterTerms) > 0 {
+			if logFilter, err = parseExchangeFilter(logFilterTerms); err != nil {
+				return fmt.Errorf("parsing the log filters: %w", err)
+			}
+		}
+		newRewrites, err := parseRewriteRules(rewrites, *rewriteFile)
+		if err != nil {
+			return fmt.Errorf("parsing -rewrite: %w", err)
+		}
+		redact := debugproxy.ParseRedactor(*redactHeaders, *redactJSON)
+		routes.Store(&newRoutes)
+		d.logFilter.Store(logFilter)
+		rewriteRules.Store(&newRewrites)
+		d.redact.Store(&redact)
+		return nil
+	}
+	if err := applyLive(); err != nil {
+		log.Fatalf("Error %v", err)
+	}
+	if config != nil {
+		config.logger, config.apply = d.at(levelWarn, d.logger), applyLive
+		go config.watch()
+		reloadSignals := make(chan os.Signal, 1)
+		if notifyReload(reloadSignals) {
+			go func() {
+				for range reloadSignals {
+					config.reload("SIGHUP")
+				}
+			}()
+		}
+	}
+	if len(*routes.Load()) > 0 || config != nil {
+		director = routes.director(director)
+	}
+	if len(*routes.Load()) > 0 {
+		log.Printf("Routes: %s, otherwise %s", routes.Load(), target.Redacted())
+	}
+	var listeners []*proxyListener
+	for _, spec := range listenerSpecs {
+		l, err := parseListener(spec)
+		if err != nil {
+			log.Fatalf("Error parsing -listener: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) > 0 {
+		director = listenerDirector(director)
+	}
+	if *canaryTarget != "" {
+		canaryURL, err := url.Parse(*canaryTarget)
+		if err != nil {
+			log.Fatalf("Error parsing canary target: %v", err)
+		}
+		if *canaryPercent < 0 || *canaryPercent > 100 {
+			log.Fatalf("-canary-percent must be between 0 and 100")
+		}
+		seed := *canarySeed
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		d.canary = newCanarySplit(*canaryPercent, seed)
+		director = canaryDirector(director, httputil.NewSingleHostReverseProxy(canaryURL).Director)
+		log.Printf("Canary: forwarding %g%% of requests to %s (seed %d)", *canaryPercent, canaryURL.Redacted(), seed)
+	}
+	if *allowTargetOverride {
+		director = d.targetOverrideDirector(director, *targetOverrideParam)
+		log.Printf("WARNING: clients may pick the upstream with the %s query parameter", *targetOverrideParam)
+	}
+	if len(injectCookies) > 0 {
+		cookies, err := parseInjectCookies(injectCookies)
+		if err != nil {
+			log.Fatalf("Error parsing -inject-cookie: %v", err)
+		}
+		director = cookieDirector(director, cookies)
+	}
+	if d.spans != nil {
+		director = traceDirector(director)
+	}
+	if *addVia {
+		director = viaDirector(director)
+	}
+	headers := proxyHeaders{setHostProto: *setForwardedHostProto, targetHost: !*preserveHost}
+	if headers.xff, err = parseXFFMode(*xffMode); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *noXFF {
+		headers.xff = "strip"
+	}
+	if headers.xff == "strip" && (*forwardedHeader || headers.setHostProto) {
+		log.Fatalf("-xff strip cannot be combined with -forwarded-header or -x-forwarded-host-proto")
+	}
+	if headers != (proxyHeaders{xff: "append"}) {
+		director = headers.director(director, d)
+	}
+	if *forwardedHeader {
+		director = d.forwardedDirector(director)
+	}
+	if *stripAcceptEncoding {
+		inner := director
+		director = func(req *http.Request) {
+			inner(req)
+			req.Header.Del("Accept-Encoding")
+		}
+		// otherwise the transport asks for gzip itself
+		transport.DisableCompression = true
+	}
+	if len(*rewriteRules.Load()) > 0 || config != nil {
+		director = rewriteRules.director(director, d)
+	}
+	if len(*rewriteRules.Load()) > 0 {
+		log.Printf("Applying %d rewrite rules", len(*rewriteRules.Load()))
+	}
+	var hooks *scriptHooks
+	if *scriptFile != "" {
+		hooks, err = loadScript(*scriptFile, d.logger)
+		if err != nil {
+			log.Fatalf("Error loading script: %v", err)
+		}
+		director = hooks.director(director, d)
+		log.Printf("Running hooks from %s", *scriptFile)
+	}
+	if d.requestIDHeader != "" {
+		director = d.requestIDDirector(director)
+	}
+	var rt http.RoundTripper = transport
+	if *failoverTarget != "" {
+		failoverURL, err := url.Parse(*failoverTarget)
+		if err != nil {
+			log.Fatalf("Error parsing failover target: %v", err)
+		}
+		statuses, err := parseStatusList(*failoverOn)
+		if err != nil {
+			log.Fatalf("Error parsing -failover-on: %v", err)
+		}
+		rt = &failoverTransport{rt: transport, target: failoverURL, statuses: statuses, dumper: d}
+		log.Printf("Failing over to %s on connection errors and %s responses", failoverURL.Redacted(), *failoverOn)
+	}
+	if *replayFixture != "" {
+		var fallback http.RoundTripper
+		if *replayFallthrough {
+			fallback = rt
+		}
+		replay, err := loadReplayTransport(*replayFixture, d, *replayMatchBody, *replayTemplate, fallback)
+		if err != nil {
+			log.Fatalf("Error loading replay fixture: %v", err)
+		}
+		log.Printf("Replaying %d recorded exchanges from %s", replay.count, *replayFixture)
+		rt = replay
+	}
+	if *stubsFile != "" {
+		stubs, err := loadStubs(*stubsFile, rt, d)
+		if err != nil {
+			log.Fatalf("Error loading stubs: %v", err)
+		}
+		log.Printf("Answering requests matching %d stub rules from %s", len(stubs.rules), *stubsFile)
+		rt = stubs
+	}
+	var cache *responseCache
+	if *cacheResponses || *cacheTTL > 0 {
+		cache = newResponseCache(rt, *cacheTTL, *cacheMaxEntries, d)
+		rt = cache
+		if *cacheTTL > 0 {
+			log.Printf("Caching GET responses for %s (-cache-ttl)", *cacheTTL)
+		} else {
+			log.Printf("Caching GET responses as their caching headers allow")
+		}
+	}
+	if *openAPIFile != "" {
+		spec, err := loadOpenAPISpec(*openAPIFile)
+		if err != nil {
+			log.Fatalf("Error loading OpenAPI spec: %v", err)
+		}
+		rt = &openAPITransport{rt: rt, spec: spec, dumper: d}
+		log.Printf("Checking exchanges against the %d paths of %s", len(spec.paths), *openAPIFile)
+	}
+	if *teeTarget != "" {
+		teeURL, err := url.Parse(*teeTarget)
+		if err != nil {
+			log.Fatalf("Error parsing tee target: %v", err)
+		}
+		d.tee = newTeeSender(teeURL, *teeHeadersOnly, transport, d.at(levelWarn, d.logger))
+		log.Printf("Mirroring requests to %s", teeURL.Redacted())
+	}
+	if *shadowTarget != "" {
+		shadowURL, err := url.Parse(*shadowTarget)
+		if err != nil || shadowURL.Host == "" {
+			log.Fatalf("Error parsing shadow target %q: %v", *shadowTarget, err)
+		}
+		rt = newShadowTransport(rt, shadowURL, transport, *shadowIgnoreHeaders, d)
+		log.Printf("Comparing responses with %s", shadowURL.Redacted())
+	}
+	if *serialize {
+		// one backend connection is enough, and keeps a single one in use
+		transport.MaxConnsPerHost = 1
+		rt = &serialTransport{rt: rt, dumper: d}
+		log.Printf("Forwarding requests one at a time (-serialize)")
+	}
+	statusMap, err := parseStatusMap(transformStatuses)
+	if err != nil {
+		log.Fatalf("Error parsing -transform-status: %v", err)
+	}
+	var delayStatuses []statusRange
+	if *delayStatusDuration > 0 {
+		if delayStatuses, err = parseStatusList(*delayStatus); err != nil {
+			log.Fatalf("Error parsing -delay-status: %v", err)
+		}
+	}
+	var banner []byte
+	if *htmlBannerText != "" {
+		banner = htmlBanner(*htmlBannerText)
+	}
+	// onResponse changes the response before it is dumped, modifyResponse
+	// checks it after
+	onResponse := func(px *debugproxy.Exchange) error {
+		resp := px.Response
+		d.checkResponseHeaderCount(resp)
+		if len(statusMap) > 0 {
+			d.transformStatus(resp, statusMap)
+		}
+		if *delayStatusDuration > 0 && inStatusRanges(delayStatuses, resp.StatusCode) {
+			d.at(levelInfo, d.loggerFor(resp.Request.Context())).Printf("Delaying %s response by %s (-delay-status)", resp.Status, *delayStatusDuration)
+			timer := time.NewTimer(*delayStatusDuration)
+			select {
+			case <-timer.C:
+			case <-resp.Request.Context().Done():
+				timer.Stop()
+				resp.Body.Close()
+				return resp.Request.Context().Err()
+			}
+		}
+		if *rewriteLocation {
+			d.rewriteLocation(resp)
+		}
+		if *addVia || *serverHeader != "" {
+			rewriteServerHeader(resp, *addVia, *serverHeader)
+		}
+		if d.requestIDHeader != "" {
+			d.echoRequestID(resp.Request.Context(), resp.Header)
+		}
+		d.logRedirectedForm(resp)
+		streaming := d.streamsResponse(resp)
+		if rules := *rewriteRules.Load(); len(rules) > 0 {
+			rules.modifyResponse(resp, d, resp.StatusCode == http.StatusSwitchingProtocols || streaming)
+		}
+		if hooks != nil {
+			hooks.rewriteResponse(resp, d, resp.StatusCode == http.StatusSwitchingProtocols || streaming)
+		}
+		// rewriting the body would hold back a streaming response
+		if banner != nil && !streaming {
+			d.injectBanner(resp, banner)
+		}
+		if d.streams != nil && (resp.StatusCode == http.StatusSwitchingProtocols || streaming) {
+			if ex := exchangeFrom(resp.Request.Context()); ex != nil {
+				d.streams.add(ex, resp.Body)
+			}
+		}
+		return nil
+	}
+	modifyResponse := func(resp *http.Response) error {
+		// buffering the whole body would hold back an upgrade or a stream
+		if resp.StatusCode == http.StatusSwitchingProtocols || d.streamsResponse(resp) {
+			return nil
+		}
+		// checked after the dump, so the log shows what the backend sent
+		if d.guard != nil {
+			d.guard.checkResponse(resp, d)
+		}
+		if d.intercept != nil {
+			return d.intercept.response(resp, d)
+		}
+		return nil
+	}
+	// the dumper reads the bodies itself, within -max-buffered-bytes, so they
+	// stream through the library proxy unread
+	proxy, err := debugproxy.New(debugproxy.Options{
+		Target:         target,
+		Transport:      &loggingTransport{rt: rt, dumper: d},
+		Dumper:         d,
+		MaxBody:        -1,
+		Director:       director,
+		OnResponse:     onResponse,
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   d.proxyErrorHandler(*errorStatus, *errorBody),
+		FlushInterval:  *flushInterval,
+	})
+	if err != nil {
+		log.Fatalf("Error creating the proxy: %v", err)
+	}
+
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			log.Fatalf("Error writing PID file: %v", err)
+		}
+		defer removePIDFile(*pidFile)
+	}
+
+	log.Printf("Starting proxy server on %s -> forwarding to %s\n", *listenAddr, target)
+	stats := newSessionStats()
+	var drops *dropper
+	if *dropRate > 0 {
+		if *dropRate > 1 {
+			log.Fatalf("-drop-rate must be between 0 and 1")
+		}
+		seed := *dropSeed
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		drops = newDropper(*dropRate, seed)
+		log.Printf("Dropping the connection of %g of the responses mid-body (seed %d)", *dropRate, seed)
+	}
+	var faults *faultInjector
+	if *delay > 0 || *delayJitter > 0 || *failRate > 0 || len(forceStatuses) > 0 {
+		if *failRate < 0 || *failRate > 1 {
+			log.Fatalf("-fail-rate must be between 0 and 1")
+		}
+		seed := *faultSeed
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		faults = newFaultInjector(seed)
+		faults.delay, faults.jitter = *delay, *delayJitter
+		faults.failRate, faults.failStatus = *failRate, *failRateStatus
+		for _, spec := range forceStatuses {
+			rule, err := parseForcedStatus(spec)
+			if err != nil {
+				log.Fatalf("Error parsing -force-status: %v", err)
+			}
+			faults.forced = append(faults.forced, rule)
+		}
+		log.Printf("Injecting faults: delay %s (+ up to %s), fail rate %g with %d, %d forced status rules (seed %d)", *delay, *delayJitter, *failRate, *failRateStatus, len(faults.forced), seed)
+	}
+	var histogram *latencyHistogram
+	if *histogramInterval > 0 {
+		histogram = newLatencyHistogram(*histogramInterval, d.at(levelInfo, d.logger))
+		go histogram.run()
+	}
+	acceptTypes := parseContentTypeAllowlist(*acceptContentTypes)
+	blocked, err := parsePathBlocklist(blockPaths)
+	if err != nil {
+		log.Fatalf("Error parsing -block-paths: %v", err)
+	}
+	var limits *networkLimits
+	if *throttleRate != "" || *rpsLimit > 0 || *maxClientConcurrency > 0 {
+		limits = &networkLimits{}
+		if limits.paths, err = parsePathBlocklist(throttlePaths); err != nil {
+			log.Fatalf("Error parsing -throttle-paths: %v", err)
+		}
+		var parts []string
+		if *throttleRate != "" {
+			if limits.bandwidth, err = parseBandwidth(*throttleRate); err != nil {
+				log.Fatalf("Error parsing -throttle: %v", err)
+			}
+			parts = append(parts, "bandwidth "+formatBandwidth(limits.bandwidth))
+		}
+		if *rpsLimit > 0 {
+			limits.rate = newRateLimiter(*rpsLimit)
+			parts = append(parts, fmt.Sprintf("%g req/s", *rpsLimit))
+		}
+		if *maxClientConcurrency > 0 {
+			limits.clients = newClientLimiter(*maxClientConcurrency)
+			parts = append(parts, fmt.Sprintf("%d concurrent requests per client", *maxClientConcurrency))
+		}
+		if len(limits.paths) > 0 {
+			parts = append(parts, "on paths matching "+throttlePaths.String())
+		}
+		log.Printf("Simulating network limits: %s", strings.Join(parts, ", "))
+	}
+	var ramp *slowStart
+	if *slowStartDuration > 0 {
+		if *slowStartTargetRate <= 0 {
+			log.Fatalf("-slow-start-target-rate must be positive")
+		}
+		ramp = newSlowStart(*slowStartDuration, *slowStartTargetRate)
+		go ramp.logProgress(d.at(levelInfo, d.logger))
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// ends after the exchange is dumped, deferred first
+		d.requests.begin()
+		defer d.requests.end()
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		matched, route := routes.match(r), "/"
+		if matched != nil {
+			route = matched.String()
+		}
+		if l := listenerFrom(r.Context()); l != nil && l.director != nil {
+			matched, route = nil, l.addr
+		}
+		ex := d.newExchange(r, route, matched)
+		if d.metrics != nil {
+			d.metrics.inFlight.Add(1)
+		}
+		// deferred as the reverse proxy panics with http.ErrAbortHandler
+		// when a streamed body breaks off
+		defer func() {
+			d.finishExchange(ex, rec.status)
+			stats.record(rec.status, time.Since(start), body.n, rec.bytes)
+			if d.metrics != nil {
+				d.metrics.inFlight.Add(-1)
+				d.metrics.record(r.Method, rec.status, time.Since(start), body.n, rec.bytes)
+			}
+			if histogram != nil {
+				histogram.record(time.Since(start))
+			}
+		}()
+		if re := blocked.match(r.URL.Path); re != nil {
+			d.at(levelWarn, ex.logger).Printf("BLOCKED %s %s: path matches -block-paths %q, answered 403 without contacting the backend", r.Method, d.sanitize.uri(r.URL.RequestURI()), re)
+			rec.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			rec.WriteHeader(http.StatusForbidden)
+			io.WriteString(rec, *blockBody)
+			return
+		}
+		if !acceptTypes.allows(r) {
+			d.at(levelWarn, ex.logger).Printf("Rejected %s %s: Content-Type %q is not in -accept-content-types, answered 415 without contacting the backend", r.Method, d.sanitize.uri(r.URL.RequestURI()), r.Header.Get("Content-Type"))
+			http.Error(rec, fmt.Sprintf("unsupported Content-Type %q", r.Header.Get("Content-Type")), http.StatusUnsupportedMediaType)
+			return
+		}
+		limited := limits != nil && limits.applies(r.URL.Path)
+		if limited {
+			release, reason := limits.admit(rec, clientIP(r))
+			if release == nil {
+				d.at(levelWarn, ex.logger).Printf("LIMITED %s %s: over %s, answered 429 without contacting the backend", r.Method, d.sanitize.uri(r.URL.RequestURI()), reason)
+				return
+			}
+			defer release()
+		}
+		if ramp != nil {
+			delay, err := ramp.wait(r.Context())
+			if err != nil {
+				return
+			}
+			if delay > 0 {
+				d.at(levelDebug, ex.logger).Printf("Slow start: request held back %s", delay.Round(time.Millisecond))
+			}
+		}
+		if faults != nil {
+			delay, err := faults.wait(r.Context())
+			if err != nil {
+				return
+			}
+			if delay > 0 {
+				d.at(levelInfo, ex.logger).Printf("FAULT: %s %s delayed %s", r.Method, d.sanitize.uri(r.URL.RequestURI()), delay.Round(time.Millisecond))
+			}
+			if status, reason := faults.status(r.URL.Path); status != 0 {
+				d.at(levelWarn, ex.logger).Printf("FAULT: %s %s answered %d without contacting the backend (%s)", r.Method, d.sanitize.uri(r.URL.RequestURI()), status, reason)
+				http.Error(rec, fmt.Sprintf("%d %s (injected by http-debug-proxy)", status, http.StatusText(status)), status)
+				return
+			}
+		}
+		var rw http.ResponseWriter = rec
+		if limited {
+			rw = limits.throttle(rw, r)
+		}
+		if drops != nil && drops.pick() {
+			rw = &dropWriter{ResponseWriter: rec, logger: d.at(levelWarn, ex.logger), what: r.Method + " " + d.sanitize.uri(r.URL.RequestURI())}
+		}
+		proxy.ServeHTTP(rw, r.WithContext(withExchange(r.Context(), ex)))
+	}
+	if connect != nil {
+		connect.serve = handler
+	}
+	http.HandleFunc("/", handler)
+
+	lc := net.ListenConfig{KeepAlive: *keepAlivePeriod}
+	ln, err := lc.Listen(context.Background(), "tcp", *listenAddr)
+	if err != nil {
+		if *pidFile != "" {
+			removePIDFile(*pidFile)
+		}
+		log.Fatalf("Error listening on %s: %v", *listenAddr, err)
+	}
+	if *listenBacklog > 0 {
+		if err := setListenBacklog(ln, *listenBacklog); err != nil {
+			log.Printf("Error setting listen backlog: %v", err)
+		}
+	}
+	if wd != nil {
+		ln = wd.listener(ln)
+	}
+	if *tlsSelfSigned && (*tlsCert != "" || *tlsKey != "") {
+		log.Fatalf("-tls-self-signed cannot be combined with -tls-cert and -tls-key")
+	}
+	if d.rawRequest || d.normalizeHeaders || d.preserveHeaderCase {
+		if *tlsCert != "" || *tlsSelfSigned {
+			log.Printf("-raw-request, -normalize-headers and -preserve-header-case are not supported with TLS termination, ignoring them")
+			d.rawRequest, d.normalizeHeaders, d.preserveHeaderCase = false, false, false
+		} else {
+			ln = &rawHeadListener{Listener: ln}
+		}
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey, d.logger)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		go certs.watch()
+		reloadSignals := make(chan os.Signal, 1)
+		if notifyReload(reloadSignals) {
+			go func() {
+				for range reloadSignals {
+					certs.reload("SIGHUP")
+				}
+			}()
+		}
+		ln = tls.NewListener(ln, listenerTLSConfig(certs, *logSNI, d.at(levelInfo, d.logger)))
+	}
+	if *tlsSelfSigned {
+		host, _, _ := net.SplitHostPort(*listenAddr)
+		cert, err := selfSignedCertificate(host)
+		if err != nil {
+			log.Fatalf("Error generating TLS certificate: %v", err)
+		}
+		log.Printf("Terminating TLS with a self-signed certificate for %s", certificateSummary(cert.Leaf))
+		ln = tls.NewListener(ln, listenerTLSConfig(&certReloader{cert: cert}, *logSNI, d.at(levelInfo, d.logger)))
+	}
+
+	server := &http.Server{
+		Addr:         *listenAddr,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+		ConnContext:  withRawConn,
+	}
+	if *listenH2C {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if connect != nil {
+		// ServeMux does not route CONNECT requests, which carry no path
+		server.Handler = connect.handler(http.DefaultServeMux)
+	}
+	servers := []*http.Server{server}
+	if len(listeners) > 0 {
+		primary := &proxyListener{addr: *listenAddr}
+		server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			return withListener(withRawConn(ctx, c), primary)
+		}
+		if *tlsCert != "" || *tlsSelfSigned {
+			log.Printf("-listener addresses serve plain HTTP, TLS is only terminated on %s", *listenAddr)
+		}
+	}
+	for _, l := range listeners {
+		extraLn, err := lc.Listen(context.Background(), "tcp", l.addr)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", l.addr, err)
+		}
+		if _, raw := ln.(*rawHeadListener); raw {
+			extraLn = &rawHeadListener{Listener: extraLn}
+		}
+		extra := &http.Server{
+			Addr:         l.addr,
+			Handler:      server.Handler,
+			ReadTimeout:  *readTimeout,
+			WriteTimeout: *writeTimeout,
+			IdleTimeout:  *idleTimeout,
+			Protocols:    server.Protocols,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				return withListener(withRawConn(ctx, c), l)
+			},
+		}
+		servers = append(servers, extra)
+		go func() {
+			log.Printf("Starting proxy listener on %s -> forwarding to %s", l.addr, l.target.Redacted())
+			if err := extra.Serve(extraLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Listener %s failed: %v", l.addr, err)
+			}
+		}()
+	}
+	var uiServer *http.Server
+	if *uiAddr != "" {
+		uiServer = &http.Server{Addr: *uiAddr, Handler: newUIHandler(d.captures, d, handler)}
+		go func() {
+			log.Printf("Serving web UI on http://%s/", *uiAddr)
+			if err := uiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Web UI server failed: %v", err)
+			}
+		}()
+	}
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", d.metrics)
+		metricsServer = &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			log.Printf("Serving metrics on http://%s/metrics", *metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	shutdownDone := make(chan struct{})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *maxRequests > 0 {
+		d.limit, ctx = newRequestLimit(ctx, *maxRequests)
+	}
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		// a second signal exits at once, without draining or flushing
+		stop()
+		force := make(chan os.Signal, 1)
+		signal.Notify(force, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-force
+			log.Printf("Second signal, exiting without waiting for %d in-flight requests", d.requests.n.Load())
+			if *pidFile != "" {
+				removePIDFile(*pidFile)
+			}
+			os.Exit(1)
+		}()
+		if n := d.requests.n.Load(); n > 0 {
+			log.Printf("Shutting down proxy server, draining %d in-flight requests (signal again to exit now)", n)
+		} else {
+			log.Printf("Shutting down proxy server")
+		}
+		if d.intercept != nil {
+			// in-flight requests could otherwise wait for -intercept-timeout
+			d.intercept.releaseAll()
+		}
+		if d.streams == nil {
+			if err := shutdownServers(context.Background(), servers); err != nil {
+				log.Printf("Error shutting down: %v", err)
+			}
+		} else {
+			drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+			defer cancel()
+			// Shutdown waits for streamed responses; upgraded connections
+			// are hijacked, so they are waited for separately
+			err := shutdownServers(drainCtx, servers)
+			if !d.streams.wait(drainCtx) || err != nil {
+				if n := d.requests.n.Load(); n > 0 {
+					log.Printf("Drain timeout: closing the connections of %d requests still in flight", n)
+				}
+				d.streams.closeAll(d.logger)
+				closeServers(servers)
+				graceCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				d.streams.wait(graceCtx)
+			}
+			// let the aborted handlers finish their dumps
+			graceCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			d.requests.wait(graceCtx)
+		}
+		if uiServer != nil {
+			_ = uiServer.Close()
+		}
+		if metricsServer != nil {
+			_ = metricsServer.Close()
+		}
+	}()
+
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if *pidFile != "" {
+			removePIDFile(*pidFile)
+		}
+		log.Fatalf("Server failed: %v", err)
+	}
+	<-shutdownDone
+	if d.spans != nil {
+		d.spans.close()
+	}
+	if d.timingCSV != nil {
+		if err := d.timingCSV.close(); err != nil {
+			log.Printf("Error writing timing CSV: %v", err)
+		}
+	}
+	if d.record != nil {
+		if n, err := d.record.close(); err != nil {
+			log.Printf("Error closing record file: %v", err)
+		} else {
+			log.Printf("Recorded %d exchanges to %s", n, d.record.path)
+		}
+	}
+	if d.har != nil {
+		if n, err := d.har.write(); err != nil {
+			log.Printf("Error writing HAR file: %v", err)
+		} else {
+			log.Printf("Wrote %d exchanges to %s", n, d.har.path)
+		}
+	}
+	stats.logSummary(d.at(levelInfo, d.logger))
+	if cache != nil {
+		d.at(levelInfo, d.logger).Print(cache.summary())
 	}
 }
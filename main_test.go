@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// syncBuffer collects the log output of a test proxy, written from the
+// handler and transport goroutines
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+// newTestDumper returns a dumper logging everything into the returned
+// buffer, the way main wires its dumper to the standard logger
+func newTestDumper() (*dumper, *syncBuffer) {
+	logs := &syncBuffer{}
+	sink := &logSink{out: logs, level: levelDebug}
+	d := &dumper{logger: sink.logger(nil, ""), sink: sink}
+	d.redact.Store(&debugproxy.Redactor{})
+	return d, logs
+}
+
+// startProxy serves d in front of backend as main does: each request gets
+// an exchange and d is the proxy's Dumper. configure adds to the proxy
+// options what a test's flags would, such as directors. Close the server
+// before reading the log, which waits for the exchanges to finish.
+func startProxy(t *testing.T, d *dumper, backend string, configure ...func(*debugproxy.Options)) *httptest.Server {
+	t.Helper()
+	return startRoutedProxy(t, d, nil, backend, configure...)
+}
+
+// startRoutedProxy is startProxy with -route rules: their requests go to
+// their targets and their exchanges carry the route
+func startRoutedProxy(t *testing.T, d *dumper, routes pathRoutes, backend string, configure ...func(*debugproxy.Options)) *httptest.Server {
+	t.Helper()
+	target, err := url.Parse(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	opts := debugproxy.Options{
+		Target:       target,
+		Transport:    &loggingTransport{rt: transport, dumper: d},
+		Dumper:       d,
+		MaxBody:      -1,
+		Director:     routes.director(httputil.NewSingleHostReverseProxy(target).Director),
+		ErrorHandler: d.proxyErrorHandler(http.StatusBadGateway, ""),
+	}
+	for _, f := range configure {
+		f(&opts)
+	}
+	proxy, err := debugproxy.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		matched, route := routes.match(r), "/"
+		if matched != nil {
+			route = matched.String()
+		}
+		ex := d.newExchange(r, route, matched)
+		defer func() { d.finishExchange(ex, rec.status) }()
+		proxy.ServeHTTP(rec, r.WithContext(withExchange(r.Context(), ex)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// echoBackend answers with the method and body it received
+func echoBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, r.Method+" "+string(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDumpsGoToTheDumperLogger(t *testing.T) {
+	var std bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&std)
+
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, echoBackend(t).URL)
+	resp, err := http.Post(proxy.URL+"/items", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if string(body) != "POST hello" {
+		t.Errorf("client got %q, want %q", body, "POST hello")
+	}
+	for _, want := range []string{"POST /items HTTP/1.1", "----- REQUEST BODY -----\nhello", "HTTP/1.1 200 OK", "----- RESPONSE BODY -----\nPOST hello"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+	if std.Len() > 0 {
+		t.Errorf("dump went to the standard logger:\n%s", std.String())
+	}
+}
+
+func TestBodiesOfOtherMethodsAreLoggedAndForwarded(t *testing.T) {
+	const propfind = `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`
+	const report = `<?xml version="1.0"?><c:calendar-query xmlns:c="urn:ietf:params:xml:ns:caldav"/>`
+	for _, tc := range []struct {
+		method  string
+		body    io.Reader
+		chunked bool
+		want    string
+	}{
+		{"PROPFIND", strings.NewReader(propfind), false, propfind},
+		// a reader of unknown length makes the client send the body chunked
+		{"REPORT", io.MultiReader(strings.NewReader(report)), true, report},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			var got []byte
+			var gotChunked bool
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = io.ReadAll(r.Body)
+				gotChunked = len(r.TransferEncoding) > 0
+				w.WriteHeader(http.StatusMultiStatus)
+			}))
+			defer backend.Close()
+			d, logs := newTestDumper()
+			proxy := startProxy(t, d, backend.URL)
+
+			req, err := http.NewRequest(tc.method, proxy.URL+"/dav/", tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/xml")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			proxy.Close()
+
+			if resp.StatusCode != http.StatusMultiStatus {
+				t.Errorf("status %d, want 207", resp.StatusCode)
+			}
+			if string(got) != tc.want {
+				t.Errorf("backend got body %q, want %q", got, tc.want)
+			}
+			if tc.chunked && !gotChunked {
+				t.Errorf("backend got a sized body, want it chunked")
+			}
+			if !strings.Contains(logs.String(), tc.method+" /dav/ HTTP/1.1") || !strings.Contains(logs.String(), tc.want) {
+				t.Errorf("log is missing the %s request or its body:\n%s", tc.method, logs)
+			}
+		})
+	}
+}
+
+func TestRequestTrailersAreForwardedAndLogged(t *testing.T) {
+	// the backend echoes the request trailers as response trailers
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		for name := range r.Trailer {
+			w.Header().Add("Trailer", name)
+		}
+		w.WriteHeader(http.StatusOK)
+		for name, v := range r.Trailer {
+			w.Header()[name] = v
+		}
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL)
+
+	req, err := http.NewRequest(http.MethodPost, proxy.URL+"/upload", io.MultiReader(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Trailer = http.Header{"X-Checksum": {"abc123"}}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("backend got trailer X-Checksum %q, want %q", got, "abc123")
+	}
+	for _, want := range []string{"----- REQUEST TRAILERS -----\nX-Checksum: abc123", "----- RESPONSE TRAILERS -----\nX-Checksum: abc123"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestExpectContinueIsRelayed(t *testing.T) {
+	var gotExpect, gotBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotExpect = r.Header.Get("Expect")
+		// reading the body has the backend's server send 100 Continue
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer backend.Close()
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, backend.URL)
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// the headers alone: the body is held back until the 100 arrives
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: proxy.test\r\nContent-Length: 7\r\nExpect: 100-continue\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	interim, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading the 100 Continue: %v", err)
+	}
+	if interim.StatusCode != http.StatusContinue {
+		t.Fatalf("got %s before sending the body, want 100 Continue", interim.Status)
+	}
+	if _, err := io.WriteString(conn, "payload"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	conn.Close()
+	proxy.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status %s, want 200", resp.Status)
+	}
+	if gotExpect != "100-continue" || gotBody != "payload" {
+		t.Errorf("backend got Expect %q and body %q, want it to ask for the payload", gotExpect, gotBody)
+	}
+	for _, want := range []string{"----- 100 CONTINUE from backend, relaying to client -----", "----- REQUEST BODY -----\npayload"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs.String(), "no 100 CONTINUE from backend") {
+		t.Errorf("the body was sent on the timeout, not on the backend's 100:\n%s", logs)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// requestLimit cancels its context once max transactions have completed, to
+// start the graceful shutdown of a -max-requests session
+type requestLimit struct {
+	max       uint64
+	completed atomic.Uint64
+	cancel    context.CancelFunc
+}
+
+func newRequestLimit(ctx context.Context, max uint64) (*requestLimit, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &requestLimit{max: max, cancel: cancel}, ctx
+}
+
+// done counts a completed transaction
+func (l *requestLimit) done(logger *log.Logger) {
+	if l.completed.Add(1) == l.max {
+		logger.Printf("Served %d requests (-max-requests), shutting down", l.max)
+		l.cancel()
+	}
+}
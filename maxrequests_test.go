@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxRequestsShutsDownAfterTheLimit(t *testing.T) {
+	d, logs := newTestDumper()
+	var ctx context.Context
+	d.limit, ctx = newRequestLimit(context.Background(), 2)
+	proxy := startProxy(t, d, echoBackend(t).URL)
+	get := func() {
+		t.Helper()
+		resp, err := http.Get(proxy.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	get()
+	if ctx.Err() != nil {
+		t.Fatal("shutdown started after the first of 2 requests")
+	}
+	get()
+	// the exchange finishes after the response was written; closing the
+	// proxy waits for it
+	proxy.Close()
+	if ctx.Err() == nil {
+		t.Fatal("shutdown did not start after 2 requests")
+	}
+	if n := strings.Count(logs.String(), "Served 2 requests (-max-requests), shutting down"); n != 1 {
+		t.Errorf("logged the limit %d times, want once:\n%s", n, logs)
+	}
+
+	// requests still draining past the limit do not log it again
+	d.limit.done(d.logger)
+	if n := strings.Count(logs.String(), "shutting down"); n != 1 {
+		t.Errorf("logged the limit %d times after another request, want once", n)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// proxyMetrics counts the traffic for a Prometheus scrape of /metrics, in
+// the text exposition format
+type proxyMetrics struct {
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	requests map[requestKey]int64
+	duration durationHistogram
+	upstream durationHistogram
+	bytesIn  int64
+	bytesOut int64
+}
+
+type requestKey struct {
+	method string
+	status int
+}
+
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		requests: make(map[requestKey]int64),
+		duration: newDurationHistogram(),
+		upstream: newDurationHistogram(),
+	}
+}
+
+// durationHistogram is a cumulative histogram over histogramBounds
+type durationHistogram struct {
+	counts []int64
+	sum    time.Duration
+	total  int64
+}
+
+func newDurationHistogram() durationHistogram {
+	return durationHistogram{counts: make([]int64, len(histogramBounds))}
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	for i, bound := range histogramBounds {
+		if d <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += d
+	h.total++
+}
+
+// metricMethod keeps the method label bounded whatever clients send
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// record accounts one completed request
+func (m *proxyMetrics) record(method string, status int, latency time.Duration, bytesIn, bytesOut int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{metricMethod(method), status}]++
+	m.duration.observe(latency)
+	m.bytesIn += bytesIn
+	m.bytesOut += bytesOut
+}
+
+// observeUpstream accounts the time the backend took to send its response
+// headers
+func (m *proxyMetrics) observeUpstream(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upstream.observe(latency)
+}
+
+// ServeHTTP writes the metrics
+func (m *proxyMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP http_debug_proxy_requests_total Requests served, by method and response status.")
+	fmt.Fprintln(w, "# TYPE http_debug_proxy_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		return cmp.Or(cmp.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "http_debug_proxy_requests_total{method=%q,status=%q} %d\n", k.method, strconv.Itoa(k.status), m.requests[k])
+	}
+	writeHistogram(w, "http_debug_proxy_request_duration_seconds", "Time from receiving a request to finishing its response.", &m.duration)
+	writeHistogram(w, "http_debug_proxy_upstream_duration_seconds", "Time the backend took to send response headers.", &m.upstream)
+	fmt.Fprintln(w, "# HELP http_debug_proxy_received_bytes_total Request body bytes received from clients.")
+	fmt.Fprintln(w, "# TYPE http_debug_proxy_received_bytes_total counter")
+	fmt.Fprintf(w, "http_debug_proxy_received_bytes_total %d\n", m.bytesIn)
+	fmt.Fprintln(w, "# HELP http_debug_proxy_sent_bytes_total Response body bytes sent to clients.")
+	fmt.Fprintln(w, "# TYPE http_debug_proxy_sent_bytes_total counter")
+	fmt.Fprintf(w, "http_debug_proxy_sent_bytes_total %d\n", m.bytesOut)
+	fmt.Fprintln(w, "# HELP http_debug_proxy_in_flight_requests Requests being served.")
+	fmt.Fprintln(w, "# TYPE http_debug_proxy_in_flight_requests gauge")
+	fmt.Fprintf(w, "http_debug_proxy_in_flight_requests %d\n", m.inFlight.Load())
+}
+
+func writeHistogram(w io.Writer, name, help string, h *durationHistogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range histogramBounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.total)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.total)
+}
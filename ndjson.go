@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"sync"
+)
+
+// isNDJSON reports whether a content type carries newline-delimited JSON records
+func isNDJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true
+	}
+	return false
+}
+
+// recordFile appends complete records from concurrent streams to one file
+type recordFile struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *recordFile) write(record []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.w.Write(record)
+	return err
+}
+
+// ndjsonLoggingBody logs a streamed NDJSON body record by record, keeping
+// only the first preview bytes of each in the log. With capture set, full
+// records are appended to it as well.
+type ndjsonLoggingBody struct {
+	rc      io.ReadCloser
+	logger  *log.Logger
+	label   string
+	preview int
+	capture *recordFile
+	onEOF   func()
+	// head holds the first preview bytes of the current record, size its
+	// length so far and full the whole of it when capturing
+	head    []byte
+	size    int
+	full    []byte
+	records int
+	total   int64
+	done    bool
+}
+
+func (b *ndjsonLoggingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.total += int64(n)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			b.add(data)
+			break
+		}
+		b.add(data[:i+1])
+		b.endRecord(true)
+		data = data[i+1:]
+	}
+	if errors.Is(err, io.EOF) && !b.done {
+		b.done = true
+		if b.size > 0 {
+			// the last record may lack its newline
+			b.endRecord(false)
+		}
+		b.logger.Printf("----- %s BODY END (%d bytes, %d records) -----", b.label, b.total, b.records)
+		if b.onEOF != nil {
+			b.onEOF()
+		}
+	}
+	return n, err
+}
+
+func (b *ndjsonLoggingBody) add(part []byte) {
+	if room := b.preview - len(b.head); room > 0 {
+		b.head = append(b.head, part[:min(room, len(part))]...)
+	}
+	if b.capture != nil {
+		b.full = append(b.full, part...)
+	}
+	b.size += len(part)
+}
+
+func (b *ndjsonLoggingBody) endRecord(newline bool) {
+	b.records++
+	size := b.size
+	if newline {
+		size--
+	}
+	line := fmt.Sprintf("----- %s RECORD %d (%d bytes) -----\n%s", b.label, b.records, size, bytes.TrimRight(b.head, "\r\n"))
+	if size > b.preview {
+		line += fmt.Sprintf("... [truncated, %d more bytes]", size-b.preview)
+	}
+	b.logger.Print(line)
+	if b.capture != nil {
+		if err := b.capture.write(b.full); err != nil {
+			b.logger.Printf("Error writing NDJSON record: %v", err)
+		}
+	}
+	b.head, b.full, b.size = b.head[:0], b.full[:0], 0
+}
+
+func (b *ndjsonLoggingBody) Close() error {
+	return b.rc.Close()
+}
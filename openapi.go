@@ -0,0 +1,550 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+	"gopkg.in/yaml.v3"
+)
+
+// maxOpenAPIBody bounds the bodies -openapi validates; larger ones are
+// checked without their body
+const maxOpenAPIBody = 8 << 20
+
+// maxOpenAPIViolations bounds the violations listed per exchange
+const maxOpenAPIViolations = 20
+
+// maxSchemaDepth bounds $ref and nesting, for recursive schemas
+const maxSchemaDepth = 64
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIPath is a path template of the spec, such as /pets/{id}, split in
+// segments
+type openAPIPath struct {
+	template string
+	segments []string
+	item     map[string]any
+}
+
+// openAPISpec is an OpenAPI 3 document, kept as decoded YAML, with its paths
+// ready to match. Schemas are checked for type, required, properties,
+// additionalProperties: false, items, enum, nullable, allOf, anyOf, oneOf and
+// local $ref; other keywords are ignored.
+type openAPISpec struct {
+	root  map[string]any
+	base  string
+	paths []*openAPIPath
+}
+
+// loadOpenAPISpec reads a YAML or JSON OpenAPI 3 document
+func loadOpenAPISpec(path string) (*openAPISpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	root, _ := stringKeys(doc).(map[string]any)
+	if version, _ := root["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%s: not an OpenAPI 3 document", path)
+	}
+	spec := &openAPISpec{root: root}
+	if servers, _ := root["servers"].([]any); len(servers) > 0 {
+		if server, _ := servers[0].(map[string]any); server != nil {
+			if s, _ := server["url"].(string); s != "" {
+				if u, err := url.Parse(s); err == nil {
+					spec.base = strings.TrimSuffix(u.Path, "/")
+				}
+			}
+		}
+	}
+	paths, _ := root["paths"].(map[string]any)
+	for template, item := range paths {
+		item, _ := item.(map[string]any)
+		if item == nil {
+			continue
+		}
+		spec.paths = append(spec.paths, &openAPIPath{
+			template: template,
+			segments: strings.Split(strings.Trim(template, "/"), "/"),
+			item:     item,
+		})
+	}
+	return spec, nil
+}
+
+// stringKeys turns the mappings of decoded YAML with other keys than
+// strings, such as the statuses of responses, into string keyed ones
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []any:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return v
+}
+
+// match returns the path template serving path and the values of its
+// parameters, preferring the template with the most literal segments
+func (s *openAPISpec) match(path string) (*openAPIPath, map[string]string) {
+	if s.base != "" {
+		if rest, ok := strings.CutPrefix(path, s.base); ok {
+			path = rest
+		}
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var best *openAPIPath
+	var bestParams map[string]string
+	bestLiterals := -1
+	for _, p := range s.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		params, literals := map[string]string{}, 0
+		for i, seg := range p.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && segments[i] != "" {
+				value, _ := url.PathUnescape(segments[i])
+				params[seg[1:len(seg)-1]] = value
+				continue
+			}
+			if seg != segments[i] {
+				literals = -1
+				break
+			}
+			literals++
+		}
+		if literals > bestLiterals {
+			best, bestParams, bestLiterals = p, params, literals
+		}
+	}
+	return best, bestParams
+}
+
+// resolve follows a local $ref, such as #/components/schemas/Pet
+func (s *openAPISpec) resolve(node any) any {
+	for range maxSchemaDepth {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = nil
+		if pointer, ok := strings.CutPrefix(ref, "#/"); ok {
+			var at any = s.root
+			for _, key := range strings.Split(pointer, "/") {
+				key = strings.ReplaceAll(strings.ReplaceAll(key, "~1", "/"), "~0", "~")
+				parent, _ := at.(map[string]any)
+				at = parent[key]
+			}
+			node = at
+		}
+	}
+	return node
+}
+
+// openAPIOperation is the operation of a request, with what was found wrong
+// with it
+type openAPIOperation struct {
+	spec       *openAPISpec
+	name       string
+	op         map[string]any
+	violations []string
+}
+
+func (o *openAPIOperation) addf(format string, args ...any) {
+	o.violations = append(o.violations, fmt.Sprintf(format, args...))
+}
+
+// checkRequest finds the operation of a request and checks its parameters
+// and body. It returns nil and the reason when the path is not in the spec
+// or the method is not documented for it.
+func (s *openAPISpec) checkRequest(method, path string, query url.Values, h http.Header, body []byte, bodyKept bool) (*openAPIOperation, string) {
+	p, pathParams := s.match(path)
+	if p == nil {
+		return nil, "unknown path, not in the spec"
+	}
+	op, _ := s.resolve(p.item[strings.ToLower(method)]).(map[string]any)
+	if op == nil {
+		var documented []string
+		for _, m := range openAPIMethods {
+			if p.item[m] != nil {
+				documented = append(documented, strings.ToUpper(m))
+			}
+		}
+		return nil, fmt.Sprintf("method not documented for %s (%s)", p.template, strings.Join(documented, ", "))
+	}
+	o := &openAPIOperation{spec: s, op: op, name: p.template}
+	if id, _ := op["operationId"].(string); id != "" {
+		o.name = id
+	}
+	// operation parameters override the path item ones of the same name
+	params := map[string]map[string]any{}
+	for _, list := range []any{p.item["parameters"], op["parameters"]} {
+		items, _ := list.([]any)
+		for _, item := range items {
+			param, _ := s.resolve(item).(map[string]any)
+			if param == nil {
+				continue
+			}
+			name, _ := param["name"].(string)
+			in, _ := param["in"].(string)
+			params[in+" "+name] = param
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		param := params[key]
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		var value string
+		var present bool
+		switch in {
+		case "path":
+			value, present = pathParams[name]
+		case "query":
+			present = query.Has(name)
+			value = query.Get(name)
+		case "header":
+			present = h.Get(name) != ""
+			value = h.Get(name)
+		default:
+			continue
+		}
+		required, _ := param["required"].(bool)
+		if !present {
+			if required || in == "path" {
+				o.addf("request: missing required %s parameter %s", in, name)
+			}
+			continue
+		}
+		if schema := param["schema"]; schema != nil {
+			o.checkParam(fmt.Sprintf("request: %s parameter %s", in, name), schema, value)
+		}
+	}
+	requestBody, _ := s.resolve(op["requestBody"]).(map[string]any)
+	if requestBody == nil || !bodyKept {
+		return o, ""
+	}
+	if len(body) == 0 {
+		if required, _ := requestBody["required"].(bool); required {
+			o.addf("request: missing required body")
+		}
+		return o, ""
+	}
+	o.checkContent("request body", requestBody, h.Get("Content-Type"), body)
+	return o, ""
+}
+
+// checkResponse checks the status and body of the response to the operation
+func (o *openAPIOperation) checkResponse(status int, h http.Header, body []byte, bodyKept bool) {
+	responses, _ := o.op["responses"].(map[string]any)
+	code := strconv.Itoa(status)
+	response := responses[code]
+	if response == nil {
+		response = responses[code[:1]+"XX"]
+	}
+	if response == nil {
+		response = responses[code[:1]+"xx"]
+	}
+	if response == nil {
+		response = responses["default"]
+	}
+	if response == nil {
+		o.addf("response: undocumented status %d (documented: %s)", status, strings.Join(slices.Sorted(maps.Keys(responses)), ", "))
+		return
+	}
+	def, _ := o.spec.resolve(response).(map[string]any)
+	if def == nil || !bodyKept || len(body) == 0 {
+		return
+	}
+	o.checkContent("response body", def, h.Get("Content-Type"), body)
+}
+
+// checkContent checks a body against the content of a request body or
+// response definition: its media type, and for JSON its schema
+func (o *openAPIOperation) checkContent(what string, def map[string]any, contentType string, body []byte) {
+	content, _ := def["content"].(map[string]any)
+	if len(content) == 0 {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media := content[mediaType]
+	if media == nil {
+		major, _, _ := strings.Cut(mediaType, "/")
+		media = content[major+"/*"]
+	}
+	if media == nil {
+		media = content["*/*"]
+	}
+	if media == nil {
+		o.addf("%s: Content-Type %q not in the spec (%s)", what, mediaType, strings.Join(slices.Sorted(maps.Keys(content)), ", "))
+		return
+	}
+	m, _ := media.(map[string]any)
+	schema := m["schema"]
+	if schema == nil || !debugproxy.IsJSON(contentType) {
+		return
+	}
+	doc, ok := parseJSONDoc(body)
+	if !ok {
+		o.addf("%s: not valid JSON", what)
+		return
+	}
+	o.checkSchema(what+" $", schema, doc, 0)
+}
+
+// checkParam checks a parameter value, converting it to the schema's type
+func (o *openAPIOperation) checkParam(what string, schema any, value string) {
+	s, _ := o.spec.resolve(schema).(map[string]any)
+	var v any = value
+	switch schemaType(s) {
+	case "integer", "number":
+		v = json.Number(value)
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			o.addf("%s: expected %s, got %q", what, schemaType(s), value)
+			return
+		}
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			o.addf("%s: expected boolean, got %q", what, value)
+			return
+		}
+		v = b
+	case "array", "object":
+		// serialization styles are not decoded
+		return
+	}
+	o.checkSchema(what, schema, v, 0)
+}
+
+// schemaType returns the type of a schema, the first one not null when it
+// lists several
+func schemaType(s map[string]any) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []any:
+		for _, item := range t {
+			if name, _ := item.(string); name != "null" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// jsonType returns the JSON type of a decoded value, integer for whole
+// numbers
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// typeMatches reports whether a value of JSON type got is allowed by the
+// schema types want
+func typeMatches(s map[string]any, got string) bool {
+	var want []string
+	switch t := s["type"].(type) {
+	case string:
+		want = []string{t}
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				want = append(want, name)
+			}
+		}
+	default:
+		return true
+	}
+	if nullable, _ := s["nullable"].(bool); nullable {
+		want = append(want, "null")
+	}
+	return slices.Contains(want, got) || got == "integer" && slices.Contains(want, "number")
+}
+
+// checkSchema checks value v at path against schema
+func (o *openAPIOperation) checkSchema(path string, schema any, v any, depth int) {
+	if depth > maxSchemaDepth || len(o.violations) > maxOpenAPIViolations {
+		return
+	}
+	s, _ := o.spec.resolve(schema).(map[string]any)
+	if s == nil {
+		return
+	}
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			o.checkSchema(path, sub, v, depth+1)
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := s[keyword].([]any); ok && len(alternatives) > 0 {
+			matched := false
+			for _, sub := range alternatives {
+				trial := &openAPIOperation{spec: o.spec}
+				trial.checkSchema(path, sub, v, depth+1)
+				if len(trial.violations) == 0 {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				o.addf("%s: matches none of the %s schemas", path, keyword)
+			}
+		}
+	}
+	got := jsonType(v)
+	if !typeMatches(s, got) {
+		o.addf("%s: expected %s, got %s", path, schemaType(s), got)
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok && v != nil {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			o.addf("%s: %s is not one of the enum values", path, jsonText(v))
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		props, _ := s["properties"].(map[string]any)
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := v[name]; !present {
+					o.addf("%s: missing required field %s", path, name)
+				}
+			}
+		}
+		additional, _ := s["additionalProperties"].(bool)
+		_, additionalSet := s["additionalProperties"]
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			if sub, ok := props[name]; ok {
+				o.checkSchema(path+"."+name, sub, v[name], depth+1)
+			} else if additionalSet && !additional {
+				o.addf("%s: unknown field %s", path, name)
+			} else if sub, ok := s["additionalProperties"].(map[string]any); ok {
+				o.checkSchema(path+"."+name, sub, v[name], depth+1)
+			}
+		}
+	case []any:
+		if items := s["items"]; items != nil {
+			for i, item := range v {
+				o.checkSchema(fmt.Sprintf("%s[%d]", path, i), items, item, depth+1)
+			}
+		}
+	}
+}
+
+// openAPITransport checks every exchange against an OpenAPI spec and logs
+// the violations alongside the dump: unknown paths and methods, missing or
+// mistyped parameters and fields, undocumented statuses and content types.
+// Requests are forwarded whatever the outcome.
+type openAPITransport struct {
+	rt     http.RoundTripper
+	spec   *openAPISpec
+	dumper *dumper
+}
+
+func (t *openAPITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, what := req.URL.Path, req.Method+" "+t.dumper.sanitize.uri(req.URL.RequestURI())
+	if ex := exchangeFrom(req.Context()); ex != nil {
+		path, what = ex.clientPath(), ex.method+" "+t.dumper.sanitize.uri(ex.clientURI)
+	}
+	var body []byte
+	bodyKept := true
+	if req.Body != nil && req.Body != http.NoBody {
+		if bodyKept = bodyBuffered(req); bodyKept {
+			raw, err := readScriptBody(&req.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = debugproxy.DecodeContentEncoding(raw, req.Header.Get("Content-Encoding"))
+		}
+	}
+	op, problem := t.spec.checkRequest(req.Method, path, req.URL.Query(), req.Header, body, bodyKept)
+	if op == nil {
+		t.dumper.at(levelWarn, t.dumper.loggerFor(req.Context())).Printf("OpenAPI: %s: %s", what, problem)
+		return t.rt.RoundTrip(req)
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.report(t.dumper.loggerFor(req.Context()), what, op)
+		return nil, err
+	}
+	if _, ok := resp.Body.(io.ReadWriteCloser); ok || resp.Body == nil {
+		op.checkResponse(resp.StatusCode, resp.Header, nil, false)
+		t.report(t.dumper.loggerFor(req.Context()), what, op)
+		return resp, nil
+	}
+	status, header := resp.StatusCode, resp.Header.Clone()
+	resp.Body = &keptBody{ReadCloser: resp.Body, limit: maxOpenAPIBody, done: func(body []byte, truncated bool) {
+		op.checkResponse(status, header, debugproxy.DecodeContentEncoding(body, header.Get("Content-Encoding")), !truncated)
+		// the logger of now: releasing a held exchange replaced the one the
+		// request was dumped with
+		t.report(t.dumper.loggerFor(req.Context()), what, op)
+	}}
+	return resp, nil
+}
+
+// report logs the outcome of an exchange's checks
+func (t *openAPITransport) report(logger *log.Logger, what string, op *openAPIOperation) {
+	if len(op.violations) == 0 {
+		t.dumper.at(levelDebug, logger).Printf("OpenAPI: %s conforms to %s", what, op.name)
+		return
+	}
+	violations, more := op.violations, ""
+	if len(violations) > maxOpenAPIViolations {
+		more = fmt.Sprintf("\n  ... %d more", len(violations)-maxOpenAPIViolations)
+		violations = violations[:maxOpenAPIViolations]
+	}
+	t.dumper.at(levelWarn, logger).Printf("OpenAPI: %s violates %s:\n  %s%s", what, op.name, strings.Join(violations, "\n  "), more)
+}
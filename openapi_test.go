@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+const petsSpec = `
+openapi: 3.0.3
+servers:
+  - url: http://pets.test/v1
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pets"
+  /pets/mine:
+    get:
+      responses:
+        "200": {}
+  /pets/{id}:
+    get:
+      operationId: showPet
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pet"
+        4XX: {}
+  /pets/{id}/toys:
+    get:
+      responses:
+        "200": {}
+components:
+  schemas:
+    Pets:
+      type: array
+      items:
+        $ref: "#/components/schemas/Pet"
+    Pet:
+      type: object
+      required: [id, name]
+      additionalProperties: false
+      properties:
+        id:
+          type: integer
+        name:
+          type: string
+        tag:
+          type: string
+          nullable: true
+        kind:
+          enum: [cat, dog]
+        owner:
+          $ref: "#/components/schemas/Owner"
+    Owner:
+      allOf:
+        - type: object
+          required: [name]
+        - type: object
+          properties:
+            contact:
+              oneOf:
+                - type: string
+                - type: integer
+`
+
+func loadPetsSpec(t *testing.T) *openAPISpec {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pets.yaml")
+	if err := os.WriteFile(path, []byte(petsSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := loadOpenAPISpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestOpenAPIMatch(t *testing.T) {
+	spec := loadPetsSpec(t)
+	for _, tc := range []struct {
+		path, template, id string
+	}{
+		{"/v1/pets", "/pets", ""},
+		{"/v1/pets/7", "/pets/{id}", "7"},
+		// a literal segment wins over a parameter
+		{"/v1/pets/mine", "/pets/mine", ""},
+		{"/v1/pets/a%20b/toys", "/pets/{id}/toys", "a b"},
+		{"/pets/7", "/pets/{id}", "7"},
+		// a trailing slash is tolerated
+		{"/v1/pets/", "/pets", ""},
+		{"/v1/owners", "", ""},
+		{"/v1/pets/7/toys/1", "", ""},
+	} {
+		p, params := spec.match(tc.path)
+		template := ""
+		if p != nil {
+			template = p.template
+		}
+		if template != tc.template || params["id"] != tc.id {
+			t.Errorf("match(%q) = %q with id %q, want %q with id %q", tc.path, template, params["id"], tc.template, tc.id)
+		}
+	}
+}
+
+func TestOpenAPICheckSchema(t *testing.T) {
+	spec := loadPetsSpec(t)
+	pet := map[string]any{"$ref": "#/components/schemas/Pet"}
+	pets := map[string]any{"$ref": "#/components/schemas/Pets"}
+	for _, tc := range []struct {
+		name   string
+		schema any
+		doc    string
+		want   []string
+	}{
+		{"valid", pet, `{"id": 1, "name": "Rex", "tag": null, "kind": "dog"}`, nil},
+		{"missing required", pet, `{"id": 1}`, []string{"$: missing required field name"}},
+		{"wrong type", pet, `{"id": "1", "name": "Rex"}`, []string{"$.id: expected integer, got string"}},
+		{"not nullable", pet, `{"id": 1, "name": null}`, []string{"$.name: expected string, got null"}},
+		{"unknown field", pet, `{"id": 1, "name": "Rex", "color": "red"}`, []string{"$: unknown field color"}},
+		{"enum", pet, `{"id": 1, "name": "Rex", "kind": "fish"}`, []string{`$.kind: "fish" is not one of the enum values`}},
+		{"array items", pets, `[{"id": 1, "name": "Rex"}, {"id": 2.5, "name": "Tom"}]`, []string{"$[1].id: expected integer, got number"}},
+		{"not an array", pets, `{"id": 1}`, []string{"$: expected array, got object"}},
+		{"allOf", pet, `{"id": 1, "name": "Rex", "owner": {}}`, []string{"$.owner: missing required field name"}},
+		{"oneOf", pet, `{"id": 1, "name": "Rex", "owner": {"name": "Ann", "contact": 5}}`, nil},
+		{"oneOf none", pet, `{"id": 1, "name": "Rex", "owner": {"name": "Ann", "contact": true}}`, []string{"$.owner.contact: matches none of the oneOf schemas"}},
+		{"dangling $ref", map[string]any{"$ref": "#/components/schemas/Missing"}, `{"anything": 1}`, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, ok := parseJSONDoc([]byte(tc.doc))
+			if !ok {
+				t.Fatalf("invalid test document %s", tc.doc)
+			}
+			o := &openAPIOperation{spec: spec}
+			o.checkSchema("$", tc.schema, doc, 0)
+			if strings.Join(o.violations, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("violations\n%s\nwant\n%s", strings.Join(o.violations, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestOpenAPICheckResponse(t *testing.T) {
+	spec := loadPetsSpec(t)
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	for _, tc := range []struct {
+		name   string
+		status int
+		h      http.Header
+		body   string
+		kept   bool
+		want   string
+	}{
+		{"conforms", 200, jsonHeader, `{"id": 7, "name": "Rex"}`, true, ""},
+		{"schema", 200, jsonHeader, `{"id": "7", "name": "Rex"}`, true, "response body $.id: expected integer, got string"},
+		{"invalid JSON", 200, jsonHeader, `{"id"`, true, "response body: not valid JSON"},
+		{"content type", 200, http.Header{"Content-Type": {"text/html"}}, `<p>`, true, `response body: Content-Type "text/html" not in the spec (application/json)`},
+		{"body not kept", 200, jsonHeader, "", false, ""},
+		{"status range", 404, jsonHeader, `{"error": "no pet"}`, true, ""},
+		{"undocumented status", 500, jsonHeader, `{}`, true, "response: undocumented status 500 (documented: 200, 4XX)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			op, problem := spec.checkRequest(http.MethodGet, "/v1/pets/7", nil, http.Header{}, nil, true)
+			if op == nil {
+				t.Fatal(problem)
+			}
+			op.checkResponse(tc.status, tc.h, []byte(tc.body), tc.kept)
+			if got := strings.Join(op.violations, "\n"); got != tc.want {
+				t.Errorf("violations %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIResponseViolationsWithMatchStatus(t *testing.T) {
+	spec := loadPetsSpec(t)
+	backend := jsonBackend(t, `{"id": "seven", "name": "Rex"}`)
+	d, logs := newTestDumper()
+	filter, err := parseExchangeFilter([]string{"status=2xx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.logFilter.Store(filter)
+	proxy := startProxy(t, d, backend.URL, func(o *debugproxy.Options) {
+		lt := o.Transport.(*loggingTransport)
+		lt.rt = &openAPITransport{rt: lt.rt, spec: spec, dumper: d}
+	})
+	get(t, proxy.URL+"/v1/pets/7")
+	proxy.Close()
+
+	if want := "OpenAPI: GET /v1/pets/7 violates showPet:\n  response body $.id: expected integer, got string"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spanBatchSize and spanFlushInterval bound how long finished spans wait
+// before they are exported
+const (
+	spanBatchSize     = 256
+	spanFlushInterval = 5 * time.Second
+)
+
+// traceSpan identifies the span of one exchange. Its trace is the one of
+// the client's traceparent header when it sent a valid one.
+type traceSpan struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	flags    string
+}
+
+// newTraceSpan starts a span joining the trace of traceparent, or a new trace
+func newTraceSpan(traceparent string) *traceSpan {
+	s := &traceSpan{flags: "01"}
+	_, _ = rand.Read(s.spanID[:])
+	if traceID, parentID, flags, ok := parseTraceparent(traceparent); ok {
+		s.traceID, s.parentID, s.flags = traceID, parentID, flags
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// traceparent is the header value that makes the backend a child of the span
+func (s *traceSpan) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-" + s.flags
+}
+
+// parseTraceparent parses a W3C Trace Context traceparent header
+func parseTraceparent(v string) (traceID [16]byte, parentID [8]byte, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, "", false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, "", false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, "", false
+	}
+	if _, err := hex.DecodeString(parts[3]); err != nil {
+		return traceID, parentID, "", false
+	}
+	return traceID, parentID, strings.ToLower(parts[3]), true
+}
+
+// traceDirector sends the exchange's span as the parent of the backend's
+func traceDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		if ex := exchangeFrom(req.Context()); ex != nil && ex.span != nil {
+			req.Header.Set("Traceparent", ex.span.traceparent())
+		}
+	}
+}
+
+// spanExporter sends the spans of finished exchanges in batches to an OTLP
+// collector, as OTLP/HTTP with JSON encoding. Spans that fail to export are
+// dropped.
+type spanExporter struct {
+	url    string
+	client *http.Client
+	logger *log.Logger
+
+	mu      sync.Mutex
+	pending []otlpSpan
+	flush   chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newSpanExporter exports to the collector at endpoint, e.g.
+// http://localhost:4318; without a path spans go to /v1/traces
+func newSpanExporter(endpoint string, logger *log.Logger) (*spanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an http:// or https:// URL, got %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	e := &spanExporter{
+		url:     u.String(),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// end records the span of a finished exchange
+func (e *spanExporter) end(ex *exchange, status int, fullURL string) {
+	end := time.Now()
+	attrs := []otlpAttribute{
+		stringAttribute("http.request.method", ex.method),
+		stringAttribute("url.full", fullURL),
+		stringAttribute("http.route", ex.route),
+		intAttribute("http.response.status_code", status),
+		{Key: "http_debug_proxy.latency_ms", Value: map[string]any{"doubleValue": float64(end.Sub(ex.start).Microseconds()) / 1000}},
+		stringAttribute("http_debug_proxy.exchange_id", ex.idString()),
+	}
+	if ex.backendAddr != "" {
+		attrs = append(attrs, stringAttribute("network.peer.address", ex.backendAddr))
+	}
+	if ex.canary {
+		attrs = append(attrs, otlpAttribute{Key: "http_debug_proxy.canary", Value: map[string]any{"boolValue": true}})
+	}
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(ex.span.traceID[:]),
+		SpanID:            hex.EncodeToString(ex.span.spanID[:]),
+		Name:              ex.method + " " + ex.route,
+		Kind:              2, // SPAN_KIND_SERVER
+		StartTimeUnixNano: strconv.FormatInt(ex.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attrs,
+	}
+	if ex.span.parentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(ex.span.parentID[:])
+	}
+	if status >= 500 {
+		span.Status.Code = 2 // STATUS_CODE_ERROR
+	}
+	e.mu.Lock()
+	e.pending = append(e.pending, span)
+	full := len(e.pending) >= spanBatchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *spanExporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.stop:
+			e.export()
+			return
+		}
+		e.export()
+	}
+}
+
+// close exports the remaining spans, on shutdown
+func (e *spanExporter) close() {
+	close(e.stop)
+	<-e.stopped
+}
+
+func (e *spanExporter) export() {
+	e.mu.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpAttribute{stringAttribute("service.name", "http-debug-proxy")}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "http-debug-proxy", "version": version},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		e.logger.Printf("Error encoding spans: %v", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.logger.Printf("Error exporting %d spans to %s: %v", len(spans), e.url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		e.logger.Printf("Error exporting %d spans to %s: %s", len(spans), e.url, resp.Status)
+	}
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            struct {
+		Code int `json:"code,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"stringValue": value}}
+}
+
+// intAttribute encodes the value as a string, as OTLP/JSON does for 64-bit integers
+func intAttribute(key string, value int) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.Itoa(value)}}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+func TestSpansAreExportedJoiningTheClientTrace(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	type exported struct {
+		path, contentType string
+		body              []byte
+	}
+	posts := make(chan exported, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		posts <- exported{r.URL.Path, r.Header.Get("Content-Type"), body}
+	}))
+	defer collector.Close()
+	var backendTraceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendTraceparent = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	d, _ := newTestDumper()
+	var err error
+	if d.spans, err = newSpanExporter(collector.URL, d.logger); err != nil {
+		t.Fatal(err)
+	}
+	proxy := startProxy(t, d, backend.URL, func(p *debugproxy.Options) { p.Director = traceDirector(p.Director) })
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/orders/7", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+parentID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+	d.spans.close()
+
+	var got exported
+	select {
+	case got = <-posts:
+	default:
+		t.Fatal("close did not export the span")
+	}
+	if got.path != "/v1/traces" || got.contentType != "application/json" {
+		t.Errorf("exported to %s as %s, want /v1/traces as application/json", got.path, got.contentType)
+	}
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string          `json:"traceId"`
+					SpanID       string          `json:"spanId"`
+					ParentSpanID string          `json:"parentSpanId"`
+					Name         string          `json:"name"`
+					Attributes   []otlpAttribute `json:"attributes"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("%v: %s", err, got.body)
+	}
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("want one span, got %s", got.body)
+	}
+	span := payload.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != traceID || span.ParentSpanID != parentID {
+		t.Errorf("span is in trace %s under %s, want %s under %s", span.TraceID, span.ParentSpanID, traceID, parentID)
+	}
+	if want := "00-" + traceID + "-" + span.SpanID + "-01"; backendTraceparent != want {
+		t.Errorf("backend got traceparent %q, want %q", backendTraceparent, want)
+	}
+	if span.Name != "GET /" || span.Status.Code != 2 {
+		t.Errorf("span %q has status code %d, want %q with 2 (error)", span.Name, span.Status.Code, "GET /")
+	}
+	attrs := map[string]any{}
+	for _, a := range span.Attributes {
+		for _, v := range a.Value {
+			attrs[a.Key] = v
+		}
+	}
+	if attrs["http.request.method"] != "GET" || attrs["http.response.status_code"] != "503" || attrs["url.full"] != "http://"+req.URL.Host+"/orders/7" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		v  string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	} {
+		if _, _, _, ok := parseTraceparent(tc.v); ok != tc.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tc.v, ok, tc.ok)
+		}
+	}
+}
+
+func TestNewTraceSpanStartsATraceWithoutAParent(t *testing.T) {
+	s := newTraceSpan("garbage")
+	if s.traceID == [16]byte{} || s.parentID != [8]byte{} || s.flags != "01" {
+		t.Errorf("got trace %x parent %x flags %s, want a new trace without a parent", s.traceID, s.parentID, s.flags)
+	}
+	if _, parent, _, ok := parseTraceparent(s.traceparent()); !ok || parent != s.spanID {
+		t.Errorf("traceparent %q does not carry the span as the parent", s.traceparent())
+	}
+}
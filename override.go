@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// targetOverrideDirector wraps director so that a request carrying the query
+// parameter param is routed to the target URL it names instead of the
+// configured one. The parameter is stripped before forwarding.
+func (d *dumper) targetOverrideDirector(director func(*http.Request), param string) func(*http.Request) {
+	return func(req *http.Request) {
+		query := req.URL.Query()
+		raw, ok := query[param]
+		if !ok {
+			director(req)
+			return
+		}
+		query.Del(param)
+		req.URL.RawQuery = query.Encode()
+		path, rawPath := req.URL.Path, req.URL.RawPath
+		director(req)
+
+		logger := d.loggerFor(req.Context())
+		override, err := url.Parse(raw[0])
+		if err != nil || (override.Scheme != "http" && override.Scheme != "https") || override.Host == "" {
+			d.at(levelWarn, logger).Printf("Target override %q via %s ignored: not an absolute http(s) URL", raw[0], param)
+			return
+		}
+		req.URL.Scheme = override.Scheme
+		req.URL.Host = override.Host
+		req.URL.Path = joinURLPath(override.Path, path)
+		if rawPath != "" {
+			req.URL.RawPath = joinURLPath(override.EscapedPath(), rawPath)
+		} else {
+			req.URL.RawPath = ""
+		}
+		d.at(levelInfo, logger).Printf("Target override via %s: forwarding to %s", param, override.Redacted())
+	}
+}
+
+// joinURLPath joins a target base path and a request path with a single slash
+func joinURLPath(base, p string) string {
+	switch {
+	case base == "":
+		return p
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(p, "/"):
+		return base + p[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(p, "/"):
+		return base + "/" + p
+	}
+	return base + p
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// poolBackend is one -backend of a weighted pool
+type poolBackend struct {
+	url      *url.URL
+	weight   int
+	director func(*http.Request)
+	// current is the smooth weighted round-robin counter
+	current int
+	healthy bool
+}
+
+// backendPool spreads requests over several backends by weight, with the
+// smooth weighted round-robin nginx uses: a backend of weight 3 next to one
+// of weight 1 gets 3 out of every 4 requests, interleaved. Backends failing
+// their health check are skipped until they pass again.
+type backendPool struct {
+	mu       sync.Mutex
+	backends []*poolBackend
+}
+
+// parseBackendPool parses "url=weight" specs; the weight defaults to 1
+func parseBackendPool(specs []string) (*backendPool, error) {
+	p := &backendPool{}
+	for _, spec := range specs {
+		raw, weight := spec, 1
+		if i := strings.LastIndex(spec, "="); i >= 0 {
+			w, err := strconv.Atoi(spec[i+1:])
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("%q: weight must be a positive integer", spec)
+			}
+			raw, weight = spec[:i], w
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", spec, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%q: backend must be an absolute URL", spec)
+		}
+		p.backends = append(p.backends, &poolBackend{url: u, weight: weight, director: httputil.NewSingleHostReverseProxy(u).Director, healthy: true})
+	}
+	return p, nil
+}
+
+// pick returns the next backend. When every backend is down all of them are
+// candidates, so the client still gets the backend's own error.
+func (p *backendPool) pick() *poolBackend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := p.backends[:0:0]
+	for _, b := range p.backends {
+		if b.healthy {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.backends
+	}
+	var best *poolBackend
+	total := 0
+	for _, b := range candidates {
+		b.current += b.weight
+		total += b.weight
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// director sends each request to the backend picked for it and logs the pick
+func (p *backendPool) director(d *dumper) func(*http.Request) {
+	return func(req *http.Request) {
+		b := p.pick()
+		b.director(req)
+		d.at(levelInfo, d.loggerFor(req.Context())).Printf("Backend pool: %s %s -> %s (weight %d)", req.Method, d.sanitize.uri(req.URL.RequestURI()), b.url.Redacted(), b.weight)
+	}
+}
+
+// checkHealth requests path on every backend each interval. A backend is up
+// while it answers with a non 5xx status; changes are logged.
+func (p *backendPool) checkHealth(rt http.RoundTripper, path string, interval time.Duration, logger *log.Logger) {
+	client := &http.Client{Transport: rt, Timeout: 5 * time.Second}
+	for range time.Tick(interval) {
+		for _, b := range p.backends {
+			u := b.url.JoinPath(path).String()
+			var reason string
+			resp, err := client.Get(u)
+			if err != nil {
+				reason = err.Error()
+			} else {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode >= http.StatusInternalServerError {
+					reason = "status " + resp.Status
+				}
+			}
+			p.mu.Lock()
+			changed := b.healthy != (reason == "")
+			b.healthy = reason == ""
+			p.mu.Unlock()
+			switch {
+			case changed && reason != "":
+				logger.Printf("Backend pool: %s is down (%s), skipping it", b.url.Redacted(), reason)
+			case changed:
+				logger.Printf("Backend pool: %s is up again", b.url.Redacted())
+			}
+		}
+	}
+}
+
+func (p *backendPool) String() string {
+	parts := make([]string, len(p.backends))
+	for i, b := range p.backends {
+		parts[i] = fmt.Sprintf("%s=%d", b.url.Redacted(), b.weight)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// namedBackend answers with its name
+func namedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBackendPoolPicksByWeight(t *testing.T) {
+	p, err := parseBackendPool([]string{"http://a.test=3", "http://b.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var picks []string
+	for range 8 {
+		picks = append(picks, p.pick().url.Host)
+	}
+	if got, want := strings.Join(picks, " "), "a.test a.test b.test a.test a.test a.test b.test a.test"; got != want {
+		t.Errorf("picked %s, want %s", got, want)
+	}
+
+	p.backends[0].healthy = false
+	if b := p.pick(); b.url.Host != "b.test" {
+		t.Errorf("picked %s while a.test is down", b.url.Host)
+	}
+	p.backends[1].healthy = false
+	if b := p.pick(); b == nil {
+		t.Error("no backend picked while all are down")
+	}
+}
+
+func TestParseBackendPoolErrors(t *testing.T) {
+	for _, spec := range []string{"http://a.test=0", "http://a.test=x", "/relative", "a.test:80"} {
+		if _, err := parseBackendPool([]string{spec}); err == nil {
+			t.Errorf("parseBackendPool(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestBackendPoolWithForwardProxy(t *testing.T) {
+	a, b, named := namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "named")
+	pool, err := parseBackendPool([]string{a.URL, b.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, logs := newTestDumper()
+	// as main chains them: the pool replaces the single target and
+	// -forward-proxy wraps it
+	proxy := startProxy(t, d, a.URL, func(p *debugproxy.Options) {
+		p.Director = forwardProxyDirector(pool.director(d))
+	})
+	get := func(client *http.Client, u string) string {
+		t.Helper()
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get(http.DefaultClient, proxy.URL+"/") + get(http.DefaultClient, proxy.URL+"/"); got != "ab" && got != "ba" {
+		t.Errorf("origin-form requests went to %q, want one to each pool backend", got)
+	}
+	proxyURL, _ := url.Parse(proxy.URL)
+	forward := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	if got := get(forward, named.URL+"/"); got != "named" {
+		t.Errorf("absolute-form request went to %q, want the host it names", got)
+	}
+	proxy.Close()
+	if n := strings.Count(logs.String(), "Backend pool: GET / -> "); n != 2 {
+		t.Errorf("logged %d pool picks, want one per origin-form request:\n%s", n, logs)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// binaryPreviewBytes is how much of a binary body -pretty hex-dumps
+const binaryPreviewBytes = 256
+
+// isXML reports whether contentType is an XML type, including SOAP's
+// application/soap+xml and other +xml suffix types
+func isXML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// isBinary reports whether a body should not be written to a terminal as
+// is: an image, audio, video, font, archive or protobuf type, or a body that
+// is not UTF-8 text
+func isBinary(body []byte, contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	major, _, _ := strings.Cut(mediaType, "/")
+	switch {
+	case major == "image" && mediaType != "image/svg+xml", major == "audio", major == "video", major == "font":
+		return true
+	case strings.Contains(mediaType, "protobuf"), strings.HasSuffix(mediaType, "+proto"):
+		return true
+	}
+	switch mediaType {
+	case "application/octet-stream", "application/pdf", "application/zip", "application/gzip", "application/grpc":
+		return true
+	}
+	return !utf8.Valid(body) || bytes.IndexByte(body, 0) >= 0
+}
+
+// binaryPreview replaces a binary body with its size and a hex dump of its
+// first binaryPreviewBytes bytes
+func binaryPreview(body []byte) []byte {
+	if len(body) <= binaryPreviewBytes {
+		return fmt.Appendf(nil, "(binary, %d bytes)\n%s", len(body), hex.Dump(body))
+	}
+	return fmt.Appendf(nil, "(binary, %d bytes, first %d shown)\n%s", len(body), binaryPreviewBytes, hex.Dump(body[:binaryPreviewBytes]))
+}
+
+// prettyBody reindents JSON and XML bodies for -pretty and replaces binary
+// ones with a hex dump preview. Other bodies, and ones that fail to parse,
+// are returned as is.
+func prettyBody(body []byte, contentType string) []byte {
+	if len(body) > 0 && isBinary(body, contentType) {
+		return binaryPreview(body)
+	}
+	switch {
+	case debugproxy.IsJSON(contentType):
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err == nil {
+			return out.Bytes()
+		}
+	case isXML(contentType):
+		if out, err := prettyXML(body); err == nil {
+			return out
+		}
+	}
+	return body
+}
+
+// prettyXML reindents an XML document. Raw tokens are copied, so namespace
+// prefixes stay as written; text that is only whitespace is dropped in favor
+// of the new indentation.
+func prettyXML(body []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	// the bytes are logged as they are, whatever encoding they declare
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+	enc.Indent("", "  ")
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if text, ok := tok.(xml.CharData); ok && len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			t.Name = rawName(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, attr := range t.Attr {
+				attrs[i] = xml.Attr{Name: rawName(attr.Name), Value: attr.Value}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			t.Name = rawName(t.Name)
+			tok = t
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// rawName turns a raw token's prefix back into the written "prefix:local"
+// name, which the encoder would otherwise take for a namespace URL
+func rawName(n xml.Name) xml.Name {
+	if n.Space == "" {
+		return n
+	}
+	return xml.Name{Local: n.Space + ":" + n.Local}
+}
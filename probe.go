@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// probeBackend issues GET requests to u until the backend answers with a
+// non 5xx status, retrying up to retries times, interval apart
+func probeBackend(rt http.RoundTripper, u string, retries int, interval time.Duration) error {
+	client := &http.Client{Transport: rt, Timeout: 5 * time.Second}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Probe of %s failed (%v), retrying in %s (%d/%d)", u, lastErr, interval, attempt, retries)
+			time.Sleep(interval)
+		}
+		resp, err := client.Get(u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			lastErr = fmt.Errorf("status %s", resp.Status)
+			continue
+		}
+		log.Printf("Probe of %s succeeded: %s", u, resp.Status)
+		return nil
+	}
+	return lastErr
+}
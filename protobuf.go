@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxRawProtoDepth bounds how deep the tag dump looks for nested messages
+const maxRawProtoDepth = 16
+
+// isProtobuf reports whether contentType is a bare protobuf type, as sent
+// outside gRPC: application/x-protobuf, application/protobuf, ...
+func isProtobuf(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-protobuf", "application/protobuf", "application/x-google-protobuf", "application/vnd.google.protobuf":
+		return true
+	}
+	return false
+}
+
+// loadProtoset reads the message types of a FileDescriptorSet, as written
+// by protoc --descriptor_set_out --include_imports
+func loadProtoset(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return files, nil
+}
+
+// protoMessage finds the message type of a body in the -protoset: for gRPC
+// the input or output type of the method named by path, /package.Service/Method,
+// and otherwise the type named by the messageType or proto parameter of the
+// Content-Type. It returns nil when the type is not known.
+func (d *dumper) protoMessage(path, label, contentType string) protoreflect.MessageDescriptor {
+	if d.protoset == nil {
+		return nil
+	}
+	if isGRPC(contentType) {
+		service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if !ok {
+			return nil
+		}
+		desc, err := d.protoset.FindDescriptorByName(protoreflect.FullName(service))
+		if err != nil {
+			return nil
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil
+		}
+		md := sd.Methods().ByName(protoreflect.Name(method))
+		if md == nil {
+			return nil
+		}
+		if label == "REQUEST" {
+			return md.Input()
+		}
+		return md.Output()
+	}
+	_, params, _ := mime.ParseMediaType(contentType)
+	name := params["messagetype"]
+	if name == "" {
+		name = params["proto"]
+	}
+	desc, err := d.protoset.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil
+	}
+	md, _ := desc.(protoreflect.MessageDescriptor)
+	return md
+}
+
+// logProtobuf logs a gRPC or protobuf body message by message, as JSON when
+// its type is in the -protoset and as a tag/wire-type dump otherwise
+func (d *dumper) logProtobuf(logger *log.Logger, redact *debugproxy.Redactor, label, path string, body []byte, h http.Header) {
+	contentType := h.Get("Content-Type")
+	md := d.protoMessage(path, label, contentType)
+	if !isGRPC(contentType) {
+		logger.Printf("----- %s BODY (protobuf, %d bytes) -----\n%s", label, len(body), d.truncateBody(label, d.formatProtoMessage(redact, body, md)))
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var out []byte
+	n := 0
+	for rest := body; len(rest) > 0; n++ {
+		if len(rest) < 5 {
+			out = fmt.Appendf(out, "(%d trailing bytes, not a whole gRPC frame)\n", len(rest))
+			break
+		}
+		compressed, size := rest[0] == 1, binary.BigEndian.Uint32(rest[1:5])
+		if uint64(size) > uint64(len(rest)-5) {
+			out = fmt.Appendf(out, "message %d: truncated, %d of %d bytes\n", n+1, len(rest)-5, size)
+			break
+		}
+		msg := rest[5 : 5+size]
+		rest = rest[5+size:]
+		note := ""
+		if compressed {
+			encoding := h.Get("Grpc-Encoding")
+			decoded, err := debugproxy.DecodeContentEncodingErr(msg, encoding)
+			if err != nil {
+				out = fmt.Appendf(out, "message %d (%d bytes, compressed with %q, not decoded: %v)\n", n+1, size, encoding, err)
+				continue
+			}
+			msg, note = decoded, fmt.Sprintf(", %s: %d bytes", encoding, size)
+		}
+		out = fmt.Appendf(out, "message %d (%d bytes%s):\n", n+1, len(msg), note)
+		if mediaType == "application/grpc+json" {
+			out = append(out, redact.JSONBody(msg, "application/json")...)
+		} else {
+			out = append(out, d.formatProtoMessage(redact, msg, md)...)
+		}
+		if !bytes.HasSuffix(out, []byte("\n")) {
+			out = append(out, '\n')
+		}
+	}
+	logger.Printf("----- %s BODY (gRPC, %d messages) -----\n%s", label, n, d.truncateBody(label, out))
+}
+
+// formatProtoMessage renders one protobuf message as JSON with md, falling
+// back to the tag dump when md is nil or the message does not match it
+func (d *dumper) formatProtoMessage(redact *debugproxy.Redactor, msg []byte, md protoreflect.MessageDescriptor) []byte {
+	if md != nil {
+		m := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(msg, m); err == nil {
+			if out, err := protojson.Marshal(m); err == nil {
+				// protojson varies its spacing on purpose, so indent it here
+				var indented bytes.Buffer
+				if json.Indent(&indented, redact.JSONBody(out, "application/json"), "", "  ") == nil {
+					return indented.Bytes()
+				}
+			}
+		}
+	}
+	out, ok := rawProto(msg, "", 0)
+	if !ok {
+		return binaryPreview(msg)
+	}
+	return out
+}
+
+// rawProto dumps a message as protoc --decode_raw does, one field per line
+// as tag: value. Length-delimited fields are shown as strings when they are
+// printable, as nested messages when they parse as one, and as hex otherwise.
+// It reports false when msg is not a protobuf message.
+func rawProto(msg []byte, indent string, depth int) ([]byte, bool) {
+	var out []byte
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil, false
+		}
+		msg = msg[n:]
+		tag := indent + strconv.Itoa(int(num))
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return nil, false
+			}
+			out = fmt.Appendf(out, "%s: %d\n", tag, v)
+			msg = msg[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(msg)
+			if n < 0 {
+				return nil, false
+			}
+			out = fmt.Appendf(out, "%s: 0x%08x\n", tag, v)
+			msg = msg[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(msg)
+			if n < 0 {
+				return nil, false
+			}
+			out = fmt.Appendf(out, "%s: 0x%016x\n", tag, v)
+			msg = msg[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return nil, false
+			}
+			msg = msg[n:]
+			if printable(v) {
+				out = fmt.Appendf(out, "%s: %q\n", tag, v)
+				continue
+			}
+			if depth < maxRawProtoDepth {
+				if nested, ok := rawProto(v, indent+"  ", depth+1); ok {
+					out = fmt.Appendf(out, "%s {\n%s%s}\n", tag, nested, indent)
+					continue
+				}
+			}
+			out = fmt.Appendf(out, "%s: 0x%x\n", tag, v)
+		default:
+			// groups are long deprecated
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// printable reports whether b is text, so a length-delimited field holding
+// it is shown as a string
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
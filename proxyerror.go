@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// describeProxyError classifies an error returned by the transport into a
+// short human readable cause
+func describeProxyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "client canceled the request"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused, is the backend running?"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset by the backend"
+	case errors.As(err, &dnsErr):
+		return "cannot resolve backend host " + dnsErr.Name
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout talking to the backend"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "backend closed the connection without a complete response"
+	default:
+		return "backend request failed"
+	}
+}
+
+// logProxyError logs a failed round trip to the backend with the request
+// details. A held request dump is written out first, as it gives the context
+// of the failure.
+func (d *dumper) logProxyError(r *http.Request, err error) {
+	ex := exchangeFrom(r.Context())
+	logger := d.logger
+	if ex != nil {
+		if ex.held != nil {
+			d.writeHeld(ex)
+		}
+		ex.logger = d.exchangeLogger(ex, nil)
+		logger = ex.logger
+	}
+	id := ""
+	if ex != nil {
+		id = " #" + ex.idString()
+		if ex.capture != nil {
+			ex.capture.setError(err)
+		}
+	}
+	logger.Printf("----- PROXY ERROR%s: %s %s from %s: %s: %v -----", id, r.Method, d.sanitize.url(r.URL), r.RemoteAddr, describeProxyError(err), err)
+}
+
+// proxyErrorHandler answers the requests the backend could not serve with
+// status and body, once the failure was logged
+func (d *dumper) proxyErrorHandler(status int, body string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		d.logProxyError(r, err)
+		if d.requestIDHeader != "" {
+			d.echoRequestID(r.Context(), w.Header())
+		}
+		w.WriteHeader(status)
+		if body != "" {
+			_, _ = io.WriteString(w, body)
+		}
+	}
+}
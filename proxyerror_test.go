@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+func TestDeadBackend(t *testing.T) {
+	// a port nothing listens on any more
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := "http://" + ln.Addr().String()
+	ln.Close()
+
+	d, logs := newTestDumper()
+	d.requestIDHeader = "X-Request-Id"
+	proxy := startProxy(t, d, backend, func(p *debugproxy.Options) {
+		p.ErrorHandler = d.proxyErrorHandler(http.StatusServiceUnavailable, "backend is down\n")
+	})
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/orders?id=1", nil)
+	req.Header.Set("X-Request-Id", "req-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	proxy.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", resp.StatusCode)
+	}
+	if string(body) != "backend is down\n" {
+		t.Errorf("body %q, want the -error-body", body)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "req-42" {
+		t.Errorf("X-Request-Id %q, want the request's", got)
+	}
+	line := regexp.MustCompile(`----- PROXY ERROR #req-42: GET ` + regexp.QuoteMeta(backend) + `/orders\?id=1 from 127\.0\.0\.1:\d+: connection refused, is the backend running\?: .*connection refused -----`)
+	if !line.MatchString(logs.String()) {
+		t.Errorf("log is missing the proxy error line:\n%s", logs)
+	}
+}
+
+func TestDescribeProxyError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{io.EOF, "backend closed the connection without a complete response"},
+		{&net.DNSError{Name: "backend.invalid", IsNotFound: true}, "cannot resolve backend host backend.invalid"},
+		{&net.OpError{Op: "dial", Err: timeoutError{}}, "timeout talking to the backend"},
+	} {
+		if got := describeProxyError(tc.err); got != tc.want {
+			t.Errorf("describeProxyError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+)
+
+// maxRawBuffer bounds the bytes kept per client connection to find request heads
+const maxRawBuffer = 1 << 20
+
+// rawHeadConn keeps the bytes read from a client connection so the request
+// head can be logged exactly as received, before the server parses it
+type rawHeadConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (c *rawHeadConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.buf = append(c.buf, p[:n]...)
+		if len(c.buf) > maxRawBuffer {
+			// only the most recent bytes can still hold an unparsed head
+			c.buf = append([]byte(nil), c.buf[len(c.buf)-maxRawBuffer:]...)
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// takeHead returns the verbatim head (request line and headers) starting
+// with requestLine, and drops the buffer up to its end. Bytes before the
+// head, such as the body of the previous request on the connection, are
+// skipped.
+func (c *rawHeadConn) takeHead(requestLine string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := bytes.Index(c.buf, []byte(requestLine))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(c.buf[start:], []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil
+	}
+	end += start + len("\r\n\r\n")
+	head := append([]byte(nil), c.buf[start:end]...)
+	c.buf = append(c.buf[:0], c.buf[end:]...)
+	return head
+}
+
+// rawHeadListener wraps accepted connections in rawHeadConn
+type rawHeadListener struct {
+	net.Listener
+}
+
+func (l *rawHeadListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rawHeadConn{Conn: conn}, nil
+}
+
+type rawConnKey struct{}
+
+// withRawConn is an http.Server ConnContext hook exposing the connection to handlers
+func withRawConn(ctx context.Context, c net.Conn) context.Context {
+	if rc, ok := c.(*rawHeadConn); ok {
+		return context.WithValue(ctx, rawConnKey{}, rc)
+	}
+	return ctx
+}
+
+func rawConnFrom(ctx context.Context) *rawHeadConn {
+	rc, _ := ctx.Value(rawConnKey{}).(*rawHeadConn)
+	return rc
+}
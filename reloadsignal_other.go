@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyReload is only supported on unix systems; certificates and -config
+// files are still reloaded when they change
+func notifyReload(chan<- os.Signal) bool {
+	return false
+}
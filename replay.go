@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// fixtureExchange is a recorded exchange as exported by GET /api/export and
+// read by -replay-fixture. Bodies are decoded and base64 encoded in JSON.
+type fixtureExchange struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	Status         int         `json:"status"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    []byte      `json:"request_body"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   []byte      `json:"response_body"`
+	// ResponseTruncated is set when the response body was streamed and not
+	// kept, so ResponseBody is empty rather than the body sent
+	ResponseTruncated bool `json:"response_truncated,omitempty"`
+	// template renders ResponseBody, with -replay-template
+	template *template.Template
+}
+
+// fixture returns the exchange as recorded, with the secret headers and
+// query parameters masked as they are in the log
+func (c *capturedExchange) fixture(d *dumper) fixtureExchange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	redact := c.redactor(d)
+	rawURL := c.url
+	if u, err := url.Parse(c.url); err == nil {
+		rawURL = d.sanitize.url(u).String()
+	}
+	return fixtureExchange{
+		Method:            c.method,
+		URL:               rawURL,
+		Status:            c.status,
+		RequestHeader:     redact.Header(c.requestHeader),
+		RequestBody:       c.requestBody,
+		ResponseHeader:    redact.Header(c.responseHeader),
+		ResponseBody:      c.responseBody,
+		ResponseTruncated: c.streamed,
+	}
+}
+
+// replayTransport answers requests from recorded exchanges instead of a live
+// backend. Requests match a recording by method and client request URI, and
+// by request body when matchBody is set. Recordings sharing a key are served
+// in order, the last one repeating once they run out. Unmatched requests go
+// to fallback, or get a 404 when fallback is nil. With templates, recorded
+// response bodies are rendered as text/template for each request.
+type replayTransport struct {
+	dumper    *dumper
+	matchBody bool
+	templates bool
+	fallback  http.RoundTripper
+
+	mu       sync.Mutex
+	fixtures map[string][]fixtureExchange
+	next     map[string]int
+	// count is the number of recordings loaded
+	count int
+}
+
+// loadReplayTransport reads a JSON array of fixtureExchange from path
+func loadReplayTransport(path string, d *dumper, matchBody, templates bool, fallback http.RoundTripper) (*replayTransport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []fixtureExchange
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		// a -record file of a proxy that did not shut down cleanly lacks the closing bracket
+		if json.Unmarshal(append(bytes.TrimSpace(data), ']'), &recorded) != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	t := &replayTransport{dumper: d, matchBody: matchBody, templates: templates, fallback: fallback, fixtures: map[string][]fixtureExchange{}, next: map[string]int{}}
+	for _, f := range recorded {
+		if f.Status == 0 {
+			// the exchange never got a response
+			continue
+		}
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing recorded URL %q: %w", f.URL, err)
+		}
+		// recordings have their secret query parameters masked, so requests
+		// are matched with theirs masked too
+		key := f.Method + " " + d.sanitize.uri(u.RequestURI())
+		if templates {
+			if f.template, err = parseBodyTemplate(key, f.ResponseBody); err != nil {
+				d.logger.Printf("Replay: response body of %s is not a valid template, it is served as is: %v", key, err)
+			}
+		}
+		t.fixtures[key] = append(t.fixtures[key], f)
+		t.count++
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	uri := req.URL.RequestURI()
+	if ex := exchangeFrom(req.Context()); ex != nil {
+		uri = ex.clientURI
+	}
+	key := req.Method + " " + t.dumper.sanitize.uri(uri)
+	var body []byte
+	if (t.matchBody || t.templates) && req.Body != nil {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		body = debugproxy.DecodeContentEncoding(raw, req.Header.Get("Content-Encoding"))
+	}
+	logger := t.dumper.at(levelInfo, t.dumper.loggerFor(req.Context()))
+	f, n, ok := t.lookup(key, body)
+	if ok {
+		note := ""
+		if f.ResponseTruncated {
+			note = " (the body was streamed when recorded and is served empty)"
+		}
+		logger.Printf("Replay: HIT %s, recording %d, status %d%s", key, n, f.Status, note)
+		if f.template != nil {
+			rendered, err := executeBodyTemplate(f.template, req, body)
+			if err != nil {
+				t.dumper.loggerFor(req.Context()).Printf("Replay: error rendering the response template of %s, serving it as is: %v", key, err)
+			} else {
+				f.ResponseBody = rendered
+			}
+		}
+		return fixtureResponse(f, req), nil
+	}
+	if t.fallback != nil {
+		logger.Printf("Replay: MISS %s, forwarding to the target", key)
+		return t.fallback.RoundTrip(req)
+	}
+	logger.Printf("Replay: MISS %s, answering 404", key)
+	return fixtureResponse(fixtureExchange{
+		Status:         http.StatusNotFound,
+		ResponseHeader: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ResponseBody:   []byte("no recorded response for " + key + "\n"),
+	}, req), nil
+}
+
+// lookup returns the next recording for key, and its position among the
+// recordings of key (and body)
+func (t *replayTransport) lookup(key string, body []byte) (fixtureExchange, int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var candidates []fixtureExchange
+	for _, f := range t.fixtures[key] {
+		if !t.matchBody || bytes.Equal(f.RequestBody, body) {
+			candidates = append(candidates, f)
+		}
+	}
+	if len(candidates) == 0 {
+		return fixtureExchange{}, 0, false
+	}
+	seq := key
+	if t.matchBody {
+		seq += "\n" + string(body)
+	}
+	i := t.next[seq]
+	if i >= len(candidates) {
+		i = len(candidates) - 1
+	} else {
+		t.next[seq] = i + 1
+	}
+	return candidates[i], i + 1, true
+}
+
+// fixtureResponse builds the response served for a recording. The recorded
+// body is decoded, so the encoding and framing headers are replaced.
+func fixtureResponse(f fixtureExchange, req *http.Request) *http.Response {
+	h := f.ResponseHeader.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Del("Content-Encoding")
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(f.ResponseBody)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(f.ResponseBody)),
+		ContentLength: int64(len(f.ResponseBody)),
+		Request:       req,
+	}
+}
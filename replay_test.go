@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// redactingDumper masks the default secret headers and query parameters
+func redactingDumper() (*dumper, *syncBuffer) {
+	d, logs := newTestDumper()
+	redact := debugproxy.ParseRedactor(debugproxy.DefaultRedactedHeaders, "")
+	d.redact.Store(&redact)
+	d.sanitize = parseQuerySanitizer(defaultSanitizedParams)
+	return d, logs
+}
+
+func TestFixturesAreRedacted(t *testing.T) {
+	d, _ := redactingDumper()
+	c := &capturedExchange{id: 1, method: http.MethodGet, url: "http://proxy.test/events?token=s3cret&page=2"}
+	c.setRequest(http.Header{"Authorization": {"Bearer s3cret"}, "Cookie": {"session=s3cret"}, "Accept": {"text/event-stream"}}, nil)
+	c.setResponse("HTTP/1.1", http.Header{"Set-Cookie": {"session=s3cret"}, "Content-Type": {"text/event-stream"}}, nil, true)
+	c.finish(http.StatusOK, 0)
+	store := newCaptureStore(10)
+	store.add(c)
+	ui := httptest.NewServer(newUIHandler(store, d, nil))
+	defer ui.Close()
+
+	resp, err := http.Get(ui.URL + "/api/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("export leaks a secret:\n%s", data)
+	}
+	var exported []fixtureExchange
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatal(err)
+	}
+	f := exported[0]
+	if f.URL != "http://proxy.test/events?token=[REDACTED]&page=2" {
+		t.Errorf("URL exported as %q", f.URL)
+	}
+	for _, h := range []http.Header{f.RequestHeader, f.ResponseHeader} {
+		for _, name := range []string{"Authorization", "Cookie", "Set-Cookie"} {
+			if v := h.Get(name); v != "" && v != debugproxy.Redacted {
+				t.Errorf("%s exported as %q", name, v)
+			}
+		}
+	}
+	if f.RequestHeader.Get("Accept") != "text/event-stream" {
+		t.Errorf("Accept exported as %q, want it unchanged", f.RequestHeader.Get("Accept"))
+	}
+	if !f.ResponseTruncated {
+		t.Error("streamed response body is not marked truncated")
+	}
+}
+
+func TestReplayOfARedactedRecording(t *testing.T) {
+	d, logs := redactingDumper()
+	rec := filepath.Join(t.TempDir(), "session.json")
+	r, err := newFixtureRecorder(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &capturedExchange{id: 1, method: http.MethodGet, url: "http://proxy.test/feed?token=s3cret"}
+	c.setRequest(http.Header{}, nil)
+	c.setResponse("HTTP/1.1", http.Header{"Content-Type": {"text/event-stream"}}, nil, true)
+	c.finish(http.StatusOK, 0)
+	if err := r.add(c.fixture(d)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(rec); strings.Contains(string(data), "s3cret") {
+		t.Errorf("recording leaks a secret:\n%s", data)
+	}
+
+	replay, err := loadReplayTransport(rec, d, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := startProxy(t, d, "http://backend.invalid", func(p *debugproxy.Options) {
+		p.Transport = &loggingTransport{rt: replay, dumper: d}
+	})
+	// another token still matches the recording, whose token is masked
+	resp, err := http.Get(proxy.URL + "/feed?token=other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("replay answered %s, want the recorded 200", resp.Status)
+	}
+	if want := "Replay: HIT GET /feed?token=[REDACTED], recording 1, status 200 (the body was streamed when recorded and is served empty)"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// requestIDDirector sets the -request-id-header of forwarded requests to
+// the exchange's ID, which is the client's when it sent one
+func (d *dumper) requestIDDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		if ex := exchangeFrom(req.Context()); ex != nil && ex.requestID != "" {
+			req.Header.Set(d.requestIDHeader, ex.requestID)
+		}
+	}
+}
+
+// echoRequestID sets the -request-id-header of the response to the client
+func (d *dumper) echoRequestID(ctx context.Context, h http.Header) {
+	if ex := exchangeFrom(ctx); ex != nil && ex.requestID != "" {
+		h.Set(d.requestIDHeader, ex.requestID)
+	}
+}
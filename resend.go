@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// maxResends caps the count of POST /api/exchanges/{id}/replay
+const maxResends = 1000
+
+// resendResult is the JSON form of one resent request
+type resendResult struct {
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Bytes      int64   `json:"bytes"`
+	// Error is why the request was not sent, when rendering its body failed
+	Error string `json:"error,omitempty"`
+}
+
+// resendBody replaces the body of resent requests. With render, the body
+// is a template rendered for each request, with the helpers and fields of
+// -replay-template bodies; .Body is the captured request body.
+type resendBody struct {
+	body   []byte
+	render bool
+}
+
+// discardWriter is the response writer of resent requests: the response is
+// logged by the proxy as any other, so only its status and size are kept
+type discardWriter struct {
+	header http.Header
+	status int
+	bytes  int64
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.bytes += int64(len(p))
+	return len(p), nil
+}
+
+// resendRequest rebuilds the request of c as a client request to the proxy.
+// The body kept is decoded, and the proxy adds the forwarding headers again.
+func resendRequest(ctx context.Context, c *capturedExchange) (*http.Request, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, c.method, c.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Header = c.requestHeader.Clone()
+	for _, name := range curlSkippedHeaders {
+		if name != "Accept-Encoding" {
+			r.Header.Del(name)
+		}
+	}
+	r.RequestURI = u.RequestURI()
+	r.RemoteAddr = "replay"
+	return r, c.requestBody, nil
+}
+
+// resend sends the request of c through serve count times, concurrency at
+// a time, and returns the outcome of each in order. with, when set, gives
+// the body sent instead of the captured one.
+func resend(ctx context.Context, serve http.HandlerFunc, c *capturedExchange, count, concurrency int, with *resendBody) ([]resendResult, error) {
+	base, captured, err := resendRequest(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	body := captured
+	var tmpl *template.Template
+	if with != nil {
+		if with.body != nil {
+			body = with.body
+		}
+		if with.render {
+			if tmpl, err = parseBodyTemplate("replay", body); err != nil {
+				return nil, fmt.Errorf("invalid body template: %w", err)
+			}
+		}
+	}
+	results := make([]resendResult, count)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r := base.Clone(ctx)
+				sent := body
+				if tmpl != nil {
+					rendered, err := executeBodyTemplate(tmpl, r, captured)
+					if err != nil {
+						results[i] = resendResult{Error: "rendering the body template: " + err.Error()}
+						continue
+					}
+					sent = rendered
+				}
+				r.Body, r.ContentLength = http.NoBody, 0
+				if len(sent) > 0 {
+					r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(sent)), int64(len(sent))
+				}
+				w := &discardWriter{header: http.Header{}}
+				start := time.Now()
+				serve(w, r)
+				results[i] = resendResult{Status: w.status, DurationMs: float64(time.Since(start)) / float64(time.Millisecond), Bytes: w.bytes}
+			}
+		}()
+	}
+	for i := range count {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results, nil
+}
+
+// maxResendBody bounds the body of POST /api/exchanges/{id}/replay
+const maxResendBody = 10 << 20
+
+// readResendBody reads the body replacing the captured one from a replay
+// call, and whether template=1 asks for it to be rendered; nil when the
+// captured body is sent as is
+func readResendBody(r *http.Request) (*resendBody, error) {
+	with := &resendBody{}
+	switch v := r.URL.Query().Get("template"); v {
+	case "", "0", "false":
+	case "1", "true":
+		with.render = true
+	default:
+		return nil, fmt.Errorf("invalid template %q, expected 1 or 0", v)
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxResendBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResendBody {
+		return nil, fmt.Errorf("body over %d bytes", maxResendBody)
+	}
+	if len(data) > 0 {
+		with.body = data
+	}
+	if with.body == nil && !with.render {
+		return nil, nil
+	}
+	return with, nil
+}
+
+// describe completes the REPLAY log line
+func (b *resendBody) describe() string {
+	switch {
+	case b == nil:
+		return ""
+	case b.body != nil && b.render:
+		return fmt.Sprintf(", body template of %d bytes given", len(b.body))
+	case b.body != nil:
+		return fmt.Sprintf(", body of %d bytes given", len(b.body))
+	default:
+		return ", captured body rendered as a template"
+	}
+}
+
+// resendCounts parses the count and concurrency query parameters
+func resendCounts(q url.Values) (int, int, error) {
+	count, concurrency := 1, 1
+	var err error
+	if v := q.Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil || count < 1 || count > maxResends {
+			return 0, 0, fmt.Errorf("invalid count %q, expected 1 to %d", v, maxResends)
+		}
+	}
+	if v := q.Get("concurrency"); v != "" {
+		if concurrency, err = strconv.Atoi(v); err != nil || concurrency < 1 {
+			return 0, 0, fmt.Errorf("invalid concurrency %q", v)
+		}
+	}
+	return count, min(concurrency, count), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingServe stands for the proxy handler, keeping the bodies it serves
+type recordingServe struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (s *recordingServe) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func capturedPost(body string) *capturedExchange {
+	c := &capturedExchange{id: 7, method: http.MethodPost, url: "http://proxy.test/orders?src=web"}
+	c.setRequest(http.Header{"Content-Type": {"application/json"}, "Content-Length": {"99"}}, []byte(body))
+	c.finish(http.StatusCreated, 0)
+	return c
+}
+
+func TestResendBodies(t *testing.T) {
+	uuid := regexp.MustCompile(`^\{"order": "[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}", "path": "/orders", "was": "\{\{uuid\}\}"\}$`)
+	for _, tc := range []struct {
+		name     string
+		captured string
+		with     *resendBody
+		check    func(string) bool
+	}{
+		{"captured", `{"order": 1}`, nil, func(b string) bool { return b == `{"order": 1}` }},
+		{"captured, not rendered", `{"order": "{{uuid}}"}`, nil, func(b string) bool { return b == `{"order": "{{uuid}}"}` }},
+		{"given", `{"order": 1}`, &resendBody{body: []byte(`{"order": 2}`)}, func(b string) bool { return b == `{"order": 2}` }},
+		{"captured template", `{"order": "{{uuid}}", "path": "{{.Path}}", "was": "{{"{{"}}uuid{{"}}"}}"}`, &resendBody{render: true}, uuid.MatchString},
+		{"given template", `{{uuid}}`, &resendBody{body: []byte(`{"order": "{{uuid}}", "path": "{{.Path}}", "was": "{{.Body}}"}`), render: true}, uuid.MatchString},
+		{"template without placeholders", `{"order": 1}`, &resendBody{render: true}, func(b string) bool { return b == `{"order": 1}` }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &recordingServe{}
+			results, err := resend(context.Background(), s.serve, capturedPost(tc.captured), 3, 2, tc.with)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range results {
+				if r.Status != http.StatusCreated || r.Error != "" {
+					t.Errorf("result %+v", r)
+				}
+			}
+			if len(s.bodies) != 3 {
+				t.Fatalf("sent %d requests, want 3", len(s.bodies))
+			}
+			for _, b := range s.bodies {
+				if !tc.check(b) {
+					t.Errorf("sent body %q", b)
+				}
+			}
+			if tc.with != nil && tc.with.render && uuid.MatchString(s.bodies[0]) && s.bodies[0] == s.bodies[1] {
+				t.Error("the template was rendered once for all requests")
+			}
+		})
+	}
+}
+
+func TestResendBodyTemplateErrors(t *testing.T) {
+	s := &recordingServe{}
+	if _, err := resend(context.Background(), s.serve, capturedPost(`{{`), 1, 1, &resendBody{render: true}); err == nil {
+		t.Error("an invalid template was accepted")
+	}
+	results, err := resend(context.Background(), s.serve, capturedPost(`{{.Header.Get}}`), 2, 1, &resendBody{render: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.bodies) != 0 || len(results) != 2 || !strings.HasPrefix(results[0].Error, "rendering the body template: ") {
+		t.Errorf("a body failing to render was sent, or not reported: %+v", results)
+	}
+}
+
+func TestReplayAPIRendersTemplates(t *testing.T) {
+	d, logs := newTestDumper()
+	store := newCaptureStore(10)
+	store.add(capturedPost(`{"order": 1}`))
+	s := &recordingServe{}
+	ui := httptest.NewServer(newUIHandler(store, d, s.serve))
+	defer ui.Close()
+
+	resp, err := http.Post(ui.URL+"/api/exchanges/7/replay?count=2&template=1", "application/json", strings.NewReader(`{"at": "{{.Method}} {{.Query}}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []resendResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(results) != 2 || results[0].Status != http.StatusCreated {
+		t.Errorf("replay answered %+v", results)
+	}
+	if strings.Join(s.bodies, "|") != `{"at": "POST src=web"}|{"at": "POST src=web"}` {
+		t.Errorf("sent bodies %q", s.bodies)
+	}
+	if want := "REPLAY of exchange #7: 2 requests, 1 at a time, body template of 32 bytes given"; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs)
+	}
+
+	resp, err = http.Post(ui.URL+"/api/exchanges/7/replay?template=yes", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid template parameter answered %s, want 400", resp.Status)
+	}
+}
+
+func TestReplayAdminEndpoints(t *testing.T) {
+	d, _ := newTestDumper()
+	store := newCaptureStore(10)
+	store.add(capturedPost(`{"order": 1}`))
+	s := &recordingServe{}
+	ui := httptest.NewServer(newUIHandler(store, d, s.serve))
+	defer ui.Close()
+
+	for _, path := range []string{"/admin/replay/7", "/admin/exchanges/7/replay"} {
+		resp, err := http.Post(ui.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []resendResult
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if len(results) != 1 || results[0].Status != http.StatusCreated {
+			t.Errorf("POST %s answered %+v", path, results)
+		}
+	}
+	if len(s.bodies) != 2 || s.bodies[0] != `{"order": 1}` {
+		t.Errorf("sent bodies %q, want the captured one twice", s.bodies)
+	}
+	resp, err := http.Post(ui.URL+"/admin/replay/999", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("replay of an unknown exchange answered %s, want 404", resp.Status)
+	}
+}
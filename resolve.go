@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// targetResolver resolves the target host itself, from its DNS name or from
+// DNS SRV records, and dials the addresses found instead of the target.
+// With an interval, the resolution is refreshed; when it changes, idle
+// connections are closed so new requests reach the new addresses.
+type targetResolver struct {
+	// addr is the target as the transport dials it, host:port
+	addr     string
+	srv      string
+	interval time.Duration
+	logger   *log.Logger
+	onChange func()
+
+	mu    sync.Mutex
+	addrs []string
+	next  atomic.Uint64
+}
+
+func newTargetResolver(target *url.URL, srv string, interval time.Duration, logger *log.Logger, onChange func()) (*targetResolver, error) {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	r := &targetResolver{addr: net.JoinHostPort(target.Hostname(), port), srv: srv, interval: interval, logger: logger, onChange: onChange}
+	addrs, err := r.resolve(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r.addrs = addrs
+	logger.Printf("Resolver: %s resolves to %s", r.name(), strings.Join(addrs, ", "))
+	return r, nil
+}
+
+func (r *targetResolver) name() string {
+	if r.srv != "" {
+		return "SRV " + r.srv
+	}
+	return r.addr
+}
+
+// resolve returns the sorted addresses of the target. For SRV, only the
+// records of the best (lowest) priority are used.
+func (r *targetResolver) resolve(ctx context.Context) ([]string, error) {
+	var addrs []string
+	if r.srv != "" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", r.srv)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			if rec.Priority == records[0].Priority {
+				addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port))))
+			}
+		}
+	} else {
+		host, port, _ := net.SplitHostPort(r.addr)
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", r.name())
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
+
+// refresh resolves the target every interval, logging changes
+func (r *targetResolver) refresh() {
+	for range time.Tick(r.interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+		addrs, err := r.resolve(ctx)
+		cancel()
+		if err != nil {
+			r.logger.Printf("Resolver: error resolving %s, keeping the previous addresses: %v", r.name(), err)
+			continue
+		}
+		r.mu.Lock()
+		old := r.addrs
+		r.addrs = addrs
+		r.mu.Unlock()
+		if !slices.Equal(old, addrs) {
+			r.logger.Printf("Resolver: %s now resolves to %s (was %s)", r.name(), strings.Join(addrs, ", "), strings.Join(old, ", "))
+			r.onChange()
+		}
+	}
+}
+
+// dialer dials connections to the target at its resolved addresses, in
+// turn, trying the next on failure; other addresses are dialed as is
+func (r *targetResolver) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != r.addr {
+			return dial(ctx, network, addr)
+		}
+		r.mu.Lock()
+		addrs := r.addrs
+		r.mu.Unlock()
+		start := int(r.next.Add(1))
+		var err error
+		for i := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, addrs[(start+i)%len(addrs)]); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
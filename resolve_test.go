@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTargetResolverDefaultsThePort(t *testing.T) {
+	for _, tc := range []struct{ target, addr string }{
+		{"http://127.0.0.1", "127.0.0.1:80"},
+		{"https://127.0.0.1", "127.0.0.1:443"},
+		{"http://127.0.0.1:8080", "127.0.0.1:8080"},
+	} {
+		var logs syncBuffer
+		r, err := newTargetResolver(mustParseURL(t, tc.target), "", 0, log.New(&logs, "", 0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.addr != tc.addr || len(r.addrs) != 1 || r.addrs[0] != tc.addr {
+			t.Errorf("%s: dials %s at %v, want %s", tc.target, r.addr, r.addrs, tc.addr)
+		}
+		if want := "Resolver: " + tc.addr + " resolves to " + tc.addr; !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestTargetResolverDialerTriesTheResolvedAddresses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	// a closed listener's address refuses connections
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	r := &targetResolver{addr: "backend.test:80", addrs: []string{dead.Addr().String(), ln.Addr().String()}}
+	var dialed []string
+	var d net.Dialer
+	dial := r.dialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return d.DialContext(ctx, network, addr)
+	})
+	for range 2 {
+		conn, err := dial(context.Background(), "tcp", "backend.test:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr().String() != ln.Addr().String() {
+			t.Errorf("connected to %s, want %s", conn.RemoteAddr(), ln.Addr())
+		}
+		conn.Close()
+	}
+	// the two dials start at different addresses, and the dead one falls over
+	if len(dialed) != 3 {
+		t.Errorf("dialed %v, want the dead address once plus the live one twice", dialed)
+	}
+
+	dialed = nil
+	if conn, err := dial(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+	}
+	if len(dialed) != 1 || dialed[0] != ln.Addr().String() {
+		t.Errorf("another address was dialed as %v, want as is", dialed)
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
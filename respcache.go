@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheBody bounds the responses -cache keeps; larger ones are forwarded
+// without being stored
+const maxCacheBody = 8 << 20
+
+// cacheableStatuses are the statuses -cache stores, those RFC 9111 lets a
+// cache reuse by default
+var cacheableStatuses = []int{200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	// vary holds the request header values the response varies by
+	vary    map[string]string
+	stored  time.Time
+	expires time.Time
+	// revalidate is set by no-cache: the entry is checked with the backend
+	// before each use
+	revalidate bool
+}
+
+// responseCache answers repeated GET requests from memory, as a shared
+// cache in front of the backend would: responses are kept for their
+// Cache-Control max-age, s-maxage or Expires, or for ttl when set, which
+// overrides the headers. Stale entries with an ETag or Last-Modified are
+// revalidated with a conditional request. Each GET logs HIT, MISS or
+// REVALIDATED, and the response carries it in X-Cache.
+type responseCache struct {
+	rt         http.RoundTripper
+	dumper     *dumper
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+func newResponseCache(rt http.RoundTripper, ttl time.Duration, maxEntries int, d *dumper) *responseCache {
+	return &responseCache{rt: rt, dumper: d, ttl: ttl, maxEntries: maxEntries, entries: map[string]*cacheEntry{}}
+}
+
+// cacheDirectives parses a Cache-Control header into directive=value,
+// lower case, with "" for directives without a value
+func cacheDirectives(h http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// lifetime returns how long a response stays fresh, and whether it may be
+// stored at all
+func (c *responseCache) lifetime(resp *http.Response, now time.Time) (time.Duration, bool) {
+	cc := cacheDirectives(resp.Header)
+	if !slices.Contains(cacheableStatuses, resp.StatusCode) || resp.Header.Get("Vary") == "*" || resp.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if c.ttl > 0 {
+		return c.ttl, true
+	}
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["private"]; ok {
+		return 0, false
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[name]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, false
+			}
+			age, _ := strconv.Atoi(resp.Header.Get("Age"))
+			return time.Duration(max(seconds-age, 0)) * time.Second, true
+		}
+	}
+	if expires := resp.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, true
+		}
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return max(t.Sub(date), 0), true
+	}
+	// kept stale, for revalidation when it has a validator
+	return 0, resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// cacheKey identifies the stored response for a request, before Vary.
+// Bodies are kept encoded, so Accept-Encoding is part of the key even when
+// the backend does not list it in Vary.
+func cacheKey(req *http.Request) string {
+	return req.Host + " " + req.URL.String() + " " + req.Header.Get("Accept-Encoding")
+}
+
+// matches reports whether req has the header values e varies by
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *responseCache) lookup(req *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[cacheKey(req)]
+	if e == nil || !e.matches(req) {
+		return nil
+	}
+	return e
+}
+
+// store keeps e for req, evicting the oldest entry when the cache is full
+func (c *responseCache) store(req *http.Request, e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(req)
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for k, other := range c.entries {
+			if oldest == "" || other.stored.Before(c.entries[oldest].stored) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = e
+}
+
+func (c *responseCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// bypasses reports why req is not answered from the cache, or ""
+func bypasses(req *http.Request) string {
+	switch {
+	case req.Method != http.MethodGet:
+		return "method"
+	case req.Header.Get("Authorization") != "":
+		return "Authorization"
+	case req.Header.Get("Range") != "":
+		return "Range"
+	case req.Header.Get("Upgrade") != "":
+		return "Upgrade"
+	}
+	if _, ok := cacheDirectives(req.Header)["no-store"]; ok {
+		return "no-store"
+	}
+	return ""
+}
+
+// wantsRevalidation reports whether the client asked for a response checked
+// with the backend
+func wantsRevalidation(req *http.Request) bool {
+	cc := cacheDirectives(req.Header)
+	_, noCache := cc["no-cache"]
+	return noCache || cc["max-age"] == "0" || req.Header.Get("Pragma") == "no-cache"
+}
+
+func (c *responseCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := c.dumper.at(levelInfo, c.dumper.loggerFor(req.Context()))
+	what := req.Method + " " + c.dumper.sanitize.uri(req.URL.RequestURI())
+	if reason := bypasses(req); reason != "" {
+		c.dumper.at(levelDebug, c.dumper.loggerFor(req.Context())).Printf("Cache: BYPASS %s (%s)", what, reason)
+		return c.rt.RoundTrip(req)
+	}
+	now := time.Now()
+	e := c.lookup(req)
+	if e != nil && !e.revalidate && now.Before(e.expires) && !wantsRevalidation(req) {
+		c.count(true)
+		age := now.Sub(e.stored)
+		logger.Printf("Cache: HIT %s (age %s, fresh for %s)", what, age.Round(time.Second), e.expires.Sub(now).Round(time.Second))
+		return e.response(req, "HIT", age), nil
+	}
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	if e != nil && !conditional && (e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != "") {
+		return c.revalidate(req, e, logger, what)
+	}
+	c.count(false)
+	reason := "not cached"
+	if e != nil {
+		reason = "stale"
+	}
+	resp, err := c.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Cache: MISS %s (%s)", what, reason)
+	resp.Header.Set("X-Cache", "MISS")
+	if !conditional {
+		c.keep(req, resp, now)
+	}
+	return resp, nil
+}
+
+// revalidate checks the stale entry e with a conditional request, serving
+// it again when the backend answers 304
+func (c *responseCache) revalidate(req *http.Request, e *cacheEntry, logger *log.Logger, what string) (*http.Response, error) {
+	now := time.Now()
+	cond := req.Clone(req.Context())
+	if etag := e.header.Get("ETag"); etag != "" {
+		cond.Header.Set("If-None-Match", etag)
+	}
+	if modified := e.header.Get("Last-Modified"); modified != "" {
+		cond.Header.Set("If-Modified-Since", modified)
+	}
+	resp, err := c.rt.RoundTrip(cond)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		c.count(false)
+		logger.Printf("Cache: MISS %s (changed, backend answered %d to the revalidation)", what, resp.StatusCode)
+		resp.Header.Set("X-Cache", "MISS")
+		c.keep(req, resp, now)
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	c.count(true)
+	updated := *e
+	updated.header = e.header.Clone()
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+		default:
+			updated.header[name] = values
+		}
+	}
+	updated.stored = now
+	lifetime, _ := c.lifetime(&http.Response{StatusCode: e.status, Header: updated.header}, now)
+	updated.expires = now.Add(lifetime)
+	c.store(req, &updated)
+	logger.Printf("Cache: REVALIDATED %s (304 Not Modified, served from the cache)", what)
+	return updated.response(req, "REVALIDATED", 0), nil
+}
+
+// keep stores resp for req once its body has been read whole, when it may
+// be stored
+func (c *responseCache) keep(req *http.Request, resp *http.Response, now time.Time) {
+	lifetime, ok := c.lifetime(resp, now)
+	if !ok || resp.ContentLength > maxCacheBody {
+		return
+	}
+	cc := cacheDirectives(resp.Header)
+	_, noCache := cc["no-cache"]
+	e := &cacheEntry{
+		status:     resp.StatusCode,
+		header:     resp.Header.Clone(),
+		vary:       map[string]string{},
+		stored:     now,
+		expires:    now.Add(lifetime),
+		revalidate: noCache && c.ttl == 0,
+	}
+	e.header.Del("X-Cache")
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				e.vary[name] = strings.Join(req.Header.Values(name), ", ")
+			}
+		}
+	}
+	resp.Body = &cachingBody{ReadCloser: resp.Body, done: func(body []byte) {
+		e.body = body
+		c.store(req, e)
+	}}
+}
+
+// response builds the response to req from e, with an Age and X-Cache
+func (e *cacheEntry) response(req *http.Request, outcome string, age time.Duration) *http.Response {
+	h := e.header.Clone()
+	h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	h.Set("X-Cache", outcome)
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// summary returns the hit and miss counts for the session summary
+func (c *responseCache) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("Cache: %d hits, %d misses, %d entries", c.hits, c.misses, len(c.entries))
+}
+
+// cachingBody reads a response body through, handing it to done once it was
+// read to the end within maxCacheBody
+type cachingBody struct {
+	io.ReadCloser
+	done func(body []byte)
+
+	buf      bytes.Buffer
+	overflow bool
+	once     sync.Once
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > maxCacheBody {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow {
+		b.once.Do(func() { b.done(bytes.Clone(b.buf.Bytes())) })
+	}
+	return n, err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// cacheBackend answers with the caching headers each path tests, counting
+// the requests that reach it
+type cacheBackend struct {
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func (b *cacheBackend) count(path string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.hits[path]
+}
+
+func (b *cacheBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	b.hits[r.URL.Path]++
+	b.mu.Unlock()
+	switch r.URL.Path {
+	case "/fresh":
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+	case "/validated":
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+	case "/vary":
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, "language "+r.Header.Get("Accept-Language"))
+		return
+	case "/no-store":
+		w.Header().Set("Cache-Control", "no-store")
+	case "/big":
+		w.Header().Set("Cache-Control", "max-age=60")
+		// sent chunked, so the size is only known once it was read
+		chunk := strings.Repeat("x", 1<<20)
+		for range maxCacheBody/len(chunk) + 1 {
+			_, _ = io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+		return
+	}
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = io.WriteString(w, "body of "+r.URL.Path)
+}
+
+// startCachingProxy proxies to a cacheBackend through a responseCache, as
+// -cache with -cache-ttl ttl
+func startCachingProxy(t *testing.T, ttl time.Duration) (string, *cacheBackend, *syncBuffer) {
+	t.Helper()
+	backend := &cacheBackend{hits: map[string]int{}}
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+	d, logs := newTestDumper()
+	proxy := startProxy(t, d, srv.URL, func(o *debugproxy.Options) {
+		lt := o.Transport.(*loggingTransport)
+		lt.rt = newResponseCache(lt.rt, ttl, 0, d)
+	})
+	return proxy.URL, backend, logs
+}
+
+// getCached sends a GET with the header name: value pairs in h, returning
+// the X-Cache outcome and the body
+func getCached(t *testing.T, u string, h ...string) (string, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(h); i += 2 {
+		req.Header.Set(h[i], h[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Header.Get("X-Cache"), string(body)
+}
+
+func TestResponseCache(t *testing.T) {
+	proxy, backend, logs := startCachingProxy(t, 0)
+	for _, tc := range []struct {
+		name    string
+		path    string
+		header  []string
+		outcome string
+		body    string
+		calls   int
+	}{
+		{"first request", "/fresh", nil, "MISS", "body of /fresh", 1},
+		{"fresh entry", "/fresh", nil, "HIT", "body of /fresh", 1},
+		{"client asks for revalidation", "/fresh", []string{"Cache-Control", "no-cache"}, "REVALIDATED", "body of /fresh", 2},
+		{"conditional client request", "/fresh", []string{"Cache-Control", "no-cache", "If-None-Match", `"v1"`}, "MISS", "", 3},
+		{"no-cache response", "/validated", nil, "MISS", "body of /validated", 1},
+		{"no-cache entry", "/validated", nil, "REVALIDATED", "body of /validated", 2},
+		{"no-cache entry again", "/validated", nil, "REVALIDATED", "body of /validated", 3},
+		{"vary first", "/vary", []string{"Accept-Language", "en"}, "MISS", "language en", 1},
+		{"vary same value", "/vary", []string{"Accept-Language", "en"}, "HIT", "language en", 1},
+		{"vary other value", "/vary", []string{"Accept-Language", "fr"}, "MISS", "language fr", 2},
+		{"no-store response", "/no-store", nil, "MISS", "body of /no-store", 1},
+		{"no-store not kept", "/no-store", nil, "MISS", "body of /no-store", 2},
+		{"authorization bypasses", "/fresh", []string{"Authorization", "Bearer t"}, "", "body of /fresh", 4},
+	} {
+		outcome, body := getCached(t, proxy+tc.path, tc.header...)
+		if outcome != tc.outcome || body != tc.body {
+			t.Errorf("%s: X-Cache %q with body %q, want %q with %q", tc.name, outcome, body, tc.outcome, tc.body)
+		}
+		if calls := backend.count(tc.path); calls != tc.calls {
+			t.Errorf("%s: the backend was called %d times for %s, want %d", tc.name, calls, tc.path, tc.calls)
+		}
+	}
+	for _, want := range []string{
+		"Cache: MISS GET /fresh (not cached)",
+		"Cache: HIT GET /fresh (age 0s, fresh for 1m0s)",
+		"Cache: REVALIDATED GET /validated (304 Not Modified, served from the cache)",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q", want)
+		}
+	}
+}
+
+func TestResponseCacheSkipsOversizedBodies(t *testing.T) {
+	proxy, backend, _ := startCachingProxy(t, 0)
+	for i := 1; i <= 2; i++ {
+		outcome, body := getCached(t, proxy+"/big")
+		if outcome != "MISS" || len(body) <= maxCacheBody {
+			t.Errorf("request %d: X-Cache %q with %d bytes, want MISS with the whole body", i, outcome, len(body))
+		}
+	}
+	if calls := backend.count("/big"); calls != 2 {
+		t.Errorf("the backend was called %d times, want 2: a body over maxCacheBody was kept", calls)
+	}
+}
+
+func TestResponseCacheTTLOverridesHeaders(t *testing.T) {
+	proxy, backend, _ := startCachingProxy(t, time.Minute)
+	for _, path := range []string{"/validated", "/no-store"} {
+		for _, want := range []string{"MISS", "HIT"} {
+			if outcome, body := getCached(t, proxy+path); outcome != want || body != "body of "+path {
+				t.Errorf("%s: X-Cache %q with body %q, want %s", path, outcome, body, want)
+			}
+		}
+		if calls := backend.count(path); calls != 1 {
+			t.Errorf("%s: the backend was called %d times with -cache-ttl, want 1", path, calls)
+		}
+	}
+}
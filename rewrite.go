@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rewriteRule is one -rewrite rule, as op:args
+type rewriteRule struct {
+	op string
+	// name is the header name, old path prefix or body pattern source
+	name  string
+	value string
+	re    *regexp.Regexp
+}
+
+// parseRewriteRule parses a rule such as set-request-header:X-Debug=1,
+// del-response-header:Server, host:api.example.com, path-prefix:/v1=/v2 or
+// response-body:"debug":false="debug":true. The first = ends the name or
+// pattern.
+func parseRewriteRule(spec string) (rewriteRule, error) {
+	op, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		return rewriteRule{}, fmt.Errorf("%q: want op:args", spec)
+	}
+	r := rewriteRule{op: op}
+	name, value, hasValue := strings.Cut(args, "=")
+	switch op {
+	case "set-request-header", "set-response-header":
+		if !hasValue || name == "" {
+			return rewriteRule{}, fmt.Errorf("%q: want %s:Name=value", spec, op)
+		}
+		r.name, r.value = http.CanonicalHeaderKey(strings.TrimSpace(name)), value
+	case "del-request-header", "del-response-header":
+		if args == "" {
+			return rewriteRule{}, fmt.Errorf("%q: want %s:Name", spec, op)
+		}
+		r.name = http.CanonicalHeaderKey(strings.TrimSpace(args))
+	case "host":
+		if args == "" {
+			return rewriteRule{}, fmt.Errorf("%q: want host:name", spec)
+		}
+		r.value = args
+	case "path-prefix":
+		if !hasValue || !strings.HasPrefix(name, "/") {
+			return rewriteRule{}, fmt.Errorf("%q: want path-prefix:/old=/new", spec)
+		}
+		r.name, r.value = name, value
+	case "request-body", "response-body":
+		if !hasValue || name == "" {
+			return rewriteRule{}, fmt.Errorf("%q: want %s:regexp=replacement", spec, op)
+		}
+		re, err := regexp.Compile(name)
+		if err != nil {
+			return rewriteRule{}, fmt.Errorf("%q: %v", spec, err)
+		}
+		r.name, r.value, r.re = name, value, re
+	default:
+		return rewriteRule{}, fmt.Errorf("%q: unknown op %q", spec, op)
+	}
+	return r, nil
+}
+
+// rewriteRules modifies requests before they are forwarded and responses
+// before they are returned, in the order the rules were given. Rewrites
+// happen before the dumps, so the log shows the traffic as rewritten.
+type rewriteRules []rewriteRule
+
+// parseRewriteRules parses the -rewrite flags followed by the lines of
+// file, when set; blank lines and lines starting with # are skipped
+func parseRewriteRules(specs []string, file string) (rewriteRules, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				specs = append(specs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var rules rewriteRules
+	for _, spec := range specs {
+		r, err := parseRewriteRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// director applies the request rules after director
+func (rules rewriteRules) director(director func(*http.Request), d *dumper) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		logger := d.at(levelDebug, d.loggerFor(req.Context()))
+		for _, r := range rules {
+			switch r.op {
+			case "set-request-header":
+				req.Header.Set(r.name, r.value)
+				logger.Printf("Rewrite: request header %s set to %q", r.name, r.value)
+			case "del-request-header":
+				if _, ok := req.Header[r.name]; ok {
+					req.Header.Del(r.name)
+					logger.Printf("Rewrite: request header %s removed", r.name)
+				}
+			case "host":
+				logger.Printf("Rewrite: Host %s -> %s", req.Host, r.value)
+				req.Host = r.value
+			case "path-prefix":
+				if rest, ok := strings.CutPrefix(req.URL.Path, r.name); ok {
+					path := r.value + rest
+					logger.Printf("Rewrite: path %s -> %s", req.URL.Path, path)
+					req.URL.Path, req.URL.RawPath = path, ""
+				}
+			}
+		}
+		// reading these bodies now would defeat 100-continue and streaming
+		if req.Body != nil && req.Body != http.NoBody && !expectsContinue(req) && !d.streamsUpload(req) {
+			if n, ok := rules.rewriteBody("request-body", &req.Body, req.Header, logger); ok {
+				req.ContentLength = n
+				req.TransferEncoding = nil
+			}
+		}
+	}
+}
+
+// modifyResponse applies the response rules; the bodies of streamed
+// responses are left alone
+func (rules rewriteRules) modifyResponse(resp *http.Response, d *dumper, streamed bool) {
+	logger := d.at(levelDebug, d.loggerFor(resp.Request.Context()))
+	for _, r := range rules {
+		switch r.op {
+		case "set-response-header":
+			resp.Header.Set(r.name, r.value)
+			logger.Printf("Rewrite: response header %s set to %q", r.name, r.value)
+		case "del-response-header":
+			if _, ok := resp.Header[r.name]; ok {
+				resp.Header.Del(r.name)
+				logger.Printf("Rewrite: response header %s removed", r.name)
+			}
+		}
+	}
+	if !streamed && resp.Body != nil && resp.Body != http.NoBody {
+		if n, ok := rules.rewriteBody("response-body", &resp.Body, resp.Header, logger); ok {
+			resp.ContentLength = n
+			resp.TransferEncoding = nil
+		}
+	}
+}
+
+// rewriteBody runs the op body rules over a body, decoding and encoding
+// gzip bodies again around them. It returns the new length when a rule
+// matched, and sets Content-Length to it.
+func (rules rewriteRules) rewriteBody(op string, body *io.ReadCloser, h http.Header, logger *log.Logger) (int64, bool) {
+	var matching rewriteRules
+	for _, r := range rules {
+		if r.op == op {
+			matching = append(matching, r)
+		}
+	}
+	if len(matching) == 0 {
+		return 0, false
+	}
+	encoding := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	gzipped := encoding == "gzip" || encoding == "x-gzip"
+	if encoding != "" && encoding != "identity" && !gzipped {
+		logger.Printf("Rewrite: %s rules skipped, unsupported Content-Encoding %q", op, encoding)
+		return 0, false
+	}
+	raw, err := readScriptBody(body)
+	if err != nil {
+		logger.Printf("Rewrite: error reading body, %s rules skipped: %v", op, err)
+		return 0, false
+	}
+	decoded := raw
+	if gzipped {
+		if decoded, err = gunzip(raw); err != nil {
+			logger.Printf("Rewrite: error decompressing body, %s rules skipped: %v", op, err)
+			return 0, false
+		}
+	}
+	out := decoded
+	for _, r := range matching {
+		if matches := len(r.re.FindAllIndex(out, -1)); matches > 0 {
+			out = r.re.ReplaceAll(out, []byte(r.value))
+			logger.Printf("Rewrite: %s %q replaced %d times", op, r.name, matches)
+		}
+	}
+	if bytes.Equal(out, decoded) {
+		return 0, false
+	}
+	if gzipped {
+		if out, err = gzipBytes(out); err != nil {
+			logger.Printf("Rewrite: error compressing body, %s rules skipped: %v", op, err)
+			*body = io.NopCloser(bytes.NewReader(raw))
+			return 0, false
+		}
+	}
+	*body = io.NopCloser(bytes.NewReader(out))
+	h.Set("Content-Length", strconv.Itoa(len(out)))
+	return int64(len(out)), true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// dumpRing logs the exchanges kept for -ring-size, oldest first
+func (d *dumper) dumpRing(logger *log.Logger) {
+	list := d.ring.list()
+	logger.Printf("----- RING BUFFER: last %d transactions -----", len(list))
+	for _, c := range list {
+		logger.Print(formatDetail(c.detail(d)))
+	}
+	logger.Printf("----- END OF RING BUFFER -----")
+}
+
+// formatDetail renders a captured exchange as a block of log text
+func formatDetail(e exchangeDetail) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "----- #%d %s %s -> %d (%.3fms) %s-----\n", e.ID, e.Method, e.URL, e.Status, e.DurationMs, e.Tags)
+	if e.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", e.Error)
+	}
+	writeDetailPart(&b, "Request", e.RequestHeader, e.RequestBody)
+	if len(e.RequestTrailer) > 0 {
+		writeDetailPart(&b, "Request trailers", e.RequestTrailer, "")
+	}
+	for _, r := range e.Interim {
+		writeDetailPart(&b, fmt.Sprintf("Interim %d %s", r.Status, http.StatusText(r.Status)), r.Header, "")
+	}
+	body := e.ResponseBody
+	if e.Streamed {
+		body = "(streamed, not kept)"
+	}
+	writeDetailPart(&b, "Response", e.ResponseHeader, body)
+	if len(e.ResponseTrailer) > 0 {
+		writeDetailPart(&b, "Response trailers", e.ResponseTrailer, "")
+	}
+	return b.String()
+}
+
+func writeDetailPart(b *bytes.Buffer, label string, h http.Header, body string) {
+	fmt.Fprintf(b, "%s:\n", label)
+	_ = h.Write(b)
+	if body != "" {
+		fmt.Fprintf(b, "\n%s\n", body)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyRingDump is only supported on unix systems, which have SIGUSR1
+func notifyRingDump(chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// routeLogMode is how much of an exchange -route-log dumps
+type routeLogMode int
+
+const (
+	routeLogFull routeLogMode = iota
+	// routeLogHeaders dumps headers and trailers, bodies by size only
+	routeLogHeaders
+	// routeLogSummary logs the one line summary only
+	routeLogSummary
+)
+
+var routeLogModes = map[string]routeLogMode{"full": routeLogFull, "headers": routeLogHeaders, "summary": routeLogSummary}
+
+// routeLogRule sets the logging mode of requests whose path matches re
+type routeLogRule struct {
+	re   *regexp.Regexp
+	mode routeLogMode
+}
+
+// parseRouteLogRule parses "regex=mode". Like -tag, the mode is taken after
+// the last '=' so the regular expression may contain '='.
+func parseRouteLogRule(s string) (routeLogRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return routeLogRule{}, fmt.Errorf("invalid route log rule %q, expected regex=full|headers|summary", s)
+	}
+	mode, ok := routeLogModes[s[i+1:]]
+	if !ok {
+		return routeLogRule{}, fmt.Errorf("invalid route log mode %q, expected full, headers or summary", s[i+1:])
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return routeLogRule{}, fmt.Errorf("invalid route log regex %q: %w", s[:i], err)
+	}
+	return routeLogRule{re: re, mode: mode}, nil
+}
+
+// routeLogFor returns the mode of the first rule matching path, full when none does
+func routeLogFor(rules []routeLogRule, path string) routeLogMode {
+	for _, r := range rules {
+		if r.re.MatchString(path) {
+			return r.mode
+		}
+	}
+	return routeLogFull
+}
+
+// logsBodies reports whether the exchange bound to ctx has its bodies dumped
+func logsBodies(ctx context.Context) bool {
+	ex := exchangeFrom(ctx)
+	return ex == nil || ex.logMode == routeLogFull
+}
+
+// logBodies logs a decoded body and its base64 fields, or only its size
+// when -route-log dumps the exchange's headers only. With -dump-dir the body
+// is saved to a file instead, and only the file is logged. gRPC and protobuf
+// bodies are logged message by message, and forms field by field.
+func (d *dumper) logBodies(ctx context.Context, logger *log.Logger, label string, raw, body []byte, h http.Header) {
+	if body == nil {
+		return
+	}
+	if !logsBodies(ctx) {
+		logger.Printf("----- %s BODY (%d bytes, not logged by -route-log) -----", label, len(body))
+		return
+	}
+	if len(body) == 0 && isGRPC(h.Get("Content-Type")) {
+		// an empty dump would hide the call; its outcome is in the status
+		logger.Printf("----- %s BODY: empty gRPC body, no messages -----", label)
+		return
+	}
+	redact := d.redactor(exchangeFrom(ctx))
+	if d.bodyDump != nil && len(body) > 0 {
+		path, err := d.bodyDump.save(exchangeFrom(ctx), label, redact.JSONBody(body, h.Get("Content-Type")), h.Get("Content-Type"))
+		if err == nil {
+			logger.Printf("----- %s BODY (%d bytes) saved to %s -----", label, len(body), path)
+			return
+		}
+		logger.Printf("Error saving %s body to -dump-dir, logging it: %v", strings.ToLower(label), err)
+	}
+	if contentType := h.Get("Content-Type"); isGRPC(contentType) || isProtobuf(contentType) {
+		path := ""
+		if ex := exchangeFrom(ctx); ex != nil {
+			path = ex.clientPath()
+		}
+		d.logProtobuf(logger, redact, label, path, body, h)
+		return
+	}
+	if !d.rawForms && formMediaType(h.Get("Content-Type")) != "" && d.logForm(logger, redact, label, raw, body, h) {
+		return
+	}
+	d.logBody(logger, redact, label, raw, body, h)
+	d.logBase64Fields(logger, body, h.Get("Content-Type"))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// pathRoute sends requests whose path is under prefix, and whose Host is
+// host when set, to target instead of -t
+type pathRoute struct {
+	host     string
+	prefix   string
+	target   *url.URL
+	director func(*http.Request)
+	// log holds the logging options of the route's exchanges
+	log routeLogOptions
+}
+
+// routeLogOptions are the logging settings of one -route, over the global
+// ones. Unset options leave the global settings alone.
+type routeLogOptions struct {
+	// options is the option list as given, for the startup line
+	options string
+	// mode, when modeSet, replaces the -route-log mode
+	mode    routeLogMode
+	modeSet bool
+	// filter, when set, leaves out of the log the exchanges it does not
+	// match, on top of the -match and -exclude filters
+	filter *exchangeFilter
+	// redact, when set, masks its headers and JSON fields on top of
+	// -redact-headers and -redact-json
+	redact *debugproxy.Redactor
+}
+
+// routeFilterKeys map the route options filtering the log to the terms of
+// the exchange filter, named after the global flags
+var routeFilterKeys = map[string]string{
+	"match-path":   "path",
+	"exclude-path": "exclude-path",
+	"match-method": "method",
+	"match-status": "status",
+}
+
+// parseRouteLogOptions parses the options following a route's target:
+// log=full|headers|summary, the match-path=, exclude-path=, match-method=
+// and match-status= filters, and redact-headers= and redact-json= lists
+func parseRouteLogOptions(fields []string) (routeLogOptions, error) {
+	o := routeLogOptions{options: strings.Join(fields, " ")}
+	var terms []string
+	var redactHeaders, redactJSON []string
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return routeLogOptions{}, fmt.Errorf("invalid option %q, expected name=value", field)
+		}
+		switch key {
+		case "log":
+			mode, ok := routeLogModes[value]
+			if !ok {
+				return routeLogOptions{}, fmt.Errorf("invalid log mode %q, expected full, headers or summary", value)
+			}
+			o.mode, o.modeSet = mode, true
+		case "redact-headers":
+			redactHeaders = append(redactHeaders, value)
+		case "redact-json":
+			redactJSON = append(redactJSON, value)
+		default:
+			term, ok := routeFilterKeys[key]
+			if !ok {
+				return routeLogOptions{}, fmt.Errorf("unknown option %q", key)
+			}
+			terms = append(terms, term+"="+value)
+		}
+	}
+	if len(terms) > 0 {
+		f, err := parseExchangeFilter(terms)
+		if err != nil {
+			return routeLogOptions{}, err
+		}
+		o.filter = f
+	}
+	if len(redactHeaders) > 0 || len(redactJSON) > 0 {
+		r := debugproxy.ParseRedactor(strings.Join(redactHeaders, ","), strings.Join(redactJSON, ","))
+		o.redact = &r
+	}
+	return o, nil
+}
+
+// parseRoute parses a -route rule, [host]/prefix=url, such as
+// /api=http://localhost:8181 or admin.local/=http://localhost:9000,
+// followed by logging options separated by spaces, as in
+// "/api=http://localhost:8181 log=headers redact-headers=X-Api-Key"
+func parseRoute(spec string) (pathRoute, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return pathRoute{}, fmt.Errorf("%q: want [host]/prefix=url", spec)
+	}
+	match, target, ok := strings.Cut(fields[0], "=")
+	if !ok {
+		return pathRoute{}, fmt.Errorf("%q: want [host]/prefix=url", spec)
+	}
+	i := strings.Index(match, "/")
+	if i < 0 {
+		return pathRoute{}, fmt.Errorf("%q: the prefix must start with /", spec)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return pathRoute{}, fmt.Errorf("%q: %v", spec, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return pathRoute{}, fmt.Errorf("%q: want an absolute target URL", spec)
+	}
+	opts, err := parseRouteLogOptions(fields[1:])
+	if err != nil {
+		return pathRoute{}, fmt.Errorf("%q: %v", spec, err)
+	}
+	return pathRoute{
+		host:     strings.ToLower(match[:i]),
+		prefix:   match[i:],
+		target:   u,
+		director: httputil.NewSingleHostReverseProxy(u).Director,
+		log:      opts,
+	}, nil
+}
+
+// matches reports whether the route serves a request for host and path. A
+// prefix matches whole path segments, so /api matches /api and /api/users
+// but not /apis.
+func (r *pathRoute) matches(host, path string) bool {
+	if r.host != "" && r.host != host {
+		return false
+	}
+	rest, ok := strings.CutPrefix(path, r.prefix)
+	return ok && (rest == "" || strings.HasSuffix(r.prefix, "/") || strings.HasPrefix(rest, "/"))
+}
+
+// String returns the rule as given, without the target
+func (r *pathRoute) String() string {
+	return r.host + r.prefix
+}
+
+// pathRoutes picks the backend of a request by its path, the longest
+// matching prefix winning, and routes with a host before those without
+type pathRoutes []*pathRoute
+
+func parseRoutes(specs []string) (pathRoutes, error) {
+	var routes pathRoutes
+	for _, spec := range specs {
+		r, err := parseRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, &r)
+	}
+	slices.SortStableFunc(routes, func(a, b *pathRoute) int {
+		if len(a.prefix) != len(b.prefix) {
+			return len(b.prefix) - len(a.prefix)
+		}
+		if (a.host == "") != (b.host == "") {
+			if a.host == "" {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	})
+	return routes, nil
+}
+
+// match returns the route serving r, nil when the request goes to -t
+func (routes pathRoutes) match(r *http.Request) *pathRoute {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.ToLower(host)
+	for _, route := range routes {
+		if route.matches(host, r.URL.Path) {
+			return route
+		}
+	}
+	return nil
+}
+
+// director sends requests to the target of their route, and the others
+// through director
+func (routes pathRoutes) director(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		if route := routes.match(req); route != nil {
+			route.director(req)
+			return
+		}
+		director(req)
+	}
+}
+
+// String lists the routes in the order they are tried
+func (routes pathRoutes) String() string {
+	var parts []string
+	for _, r := range routes {
+		part := r.String() + " -> " + r.target.Redacted()
+		if r.log.options != "" {
+			part += " (" + r.log.options + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseRouteLogOptions(t *testing.T) {
+	r, err := parseRoute("admin.local/api=http://localhost:8181 log=headers match-status=5xx redact-headers=X-Api-Key redact-json=pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.host != "admin.local" || r.prefix != "/api" || r.target.String() != "http://localhost:8181" {
+		t.Errorf("parsed %q %q %q", r.host, r.prefix, r.target)
+	}
+	if !r.log.modeSet || r.log.mode != routeLogHeaders {
+		t.Errorf("log mode %v (set %v), want headers", r.log.mode, r.log.modeSet)
+	}
+	if r.log.filter == nil || r.log.filter.matchStatus(200) || !r.log.filter.matchStatus(503) {
+		t.Error("match-status=5xx does not filter the statuses")
+	}
+	if r.log.redact == nil || !r.log.redact.MasksHeader("X-Api-Key") || !r.log.redact.MasksField("pin") {
+		t.Errorf("redactor %+v, want X-Api-Key and pin", r.log.redact)
+	}
+	if routes := (pathRoutes{&r}).String(); !strings.HasSuffix(routes, "(log=headers match-status=5xx redact-headers=X-Api-Key redact-json=pin)") {
+		t.Errorf("routes line %q does not list the options", routes)
+	}
+
+	plain, err := parseRoute("/api=http://localhost:8181")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.log.modeSet || plain.log.filter != nil || plain.log.redact != nil {
+		t.Errorf("a route without options has %+v", plain.log)
+	}
+
+	for _, spec := range []string{
+		"/api=http://localhost:8181 log=verbose",
+		"/api=http://localhost:8181 log",
+		"/api=http://localhost:8181 color=red",
+		"/api=http://localhost:8181 match-path=(",
+		"/api=http://localhost:8181 match-status=6xx",
+	} {
+		if _, err := parseRoute(spec); err == nil {
+			t.Errorf("parseRoute(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRouteLogOptions(t *testing.T) {
+	backend := echoBackend(t)
+	routes, err := parseRoutes([]string{
+		"/quiet=" + backend.URL + " log=headers redact-headers=X-Api-Key",
+		"/failures=" + backend.URL + " match-status=5xx",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, logs := newTestDumper()
+	proxy := startRoutedProxy(t, d, routes, backend.URL)
+	for _, path := range []string{"/quiet/orders", "/failures/orders", "/other"} {
+		req, err := http.NewRequest(http.MethodPost, proxy.URL+path, strings.NewReader("order 42"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "k-"+strings.TrimPrefix(path, "/"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	proxy.Close()
+
+	for _, want := range []string{
+		"POST /quiet/orders HTTP/1.1",
+		"X-Api-Key: [REDACTED]",
+		"----- REQUEST BODY (8 bytes, not logged by -route-log) -----",
+		"POST /other HTTP/1.1",
+		"X-Api-Key: k-other",
+		"----- REQUEST BODY -----\norder 42",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, logs)
+		}
+	}
+	for _, unwanted := range []string{"k-quiet/orders", "/failures/orders", "k-failures"} {
+		if strings.Contains(logs.String(), unwanted) {
+			t.Errorf("log has %q:\n%s", unwanted, logs)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultSanitizedParams are the query parameters redacted from logged URLs
+// unless -sanitize-urls is given
+const defaultSanitizedParams = "api_key,apikey,token,access_token,refresh_token,client_secret,password"
+
+// querySanitizer redacts the values of secret query parameters, matched by
+// name case-insensitively, in URLs written to the log. Forwarded requests are
+// never changed. A nil sanitizer leaves URLs as they are.
+type querySanitizer map[string]bool
+
+func parseQuerySanitizer(names string) querySanitizer {
+	var s querySanitizer
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if s == nil {
+				s = querySanitizer{}
+			}
+			s[strings.ToLower(name)] = true
+		}
+	}
+	return s
+}
+
+// query redacts a raw query string, keeping the order and encoding of the
+// other parameters
+func (s querySanitizer) query(raw string) string {
+	if len(s) == 0 || raw == "" {
+		return raw
+	}
+	pairs := strings.Split(raw, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && s[strings.ToLower(name)] {
+			pairs[i] = key + "=[REDACTED]"
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uri redacts a request URI such as "/path?token=x"
+func (s querySanitizer) uri(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	return path + "?" + s.query(query)
+}
+
+// url returns a copy of u with its query redacted
+func (s querySanitizer) url(u *url.URL) *url.URL {
+	c := *u
+	c.RawQuery = s.query(u.RawQuery)
+	return &c
+}
+
+// request returns a shallow copy of req with its URL and request URI
+// redacted, for dumping the request head
+func (s querySanitizer) request(req *http.Request) *http.Request {
+	if len(s) == 0 {
+		return req
+	}
+	c := *req
+	c.URL = s.url(req.URL)
+	c.RequestURI = s.uri(req.RequestURI)
+	return &c
+}
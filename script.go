@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+	"go.starlark.net/starlark"
+)
+
+// maxScriptSteps stops a hook stuck in a loop
+const maxScriptSteps = 10_000_000
+
+// scriptHooks runs the on_request(req) and on_response(resp) functions of a
+// Starlark script. The hooks get a dict they may modify in place; a hook
+// that fails is logged and the exchange passes through unchanged.
+type scriptHooks struct {
+	onRequest  starlark.Callable
+	onResponse starlark.Callable
+}
+
+func loadScript(path string, logger *log.Logger) (*scriptHooks, error) {
+	thread := &starlark.Thread{Name: "load", Print: scriptPrint(logger)}
+	globals, err := starlark.ExecFile(thread, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	h := &scriptHooks{}
+	for name, hook := range map[string]*starlark.Callable{"on_request": &h.onRequest, "on_response": &h.onResponse} {
+		v, ok := globals[name]
+		if !ok {
+			continue
+		}
+		fn, ok := v.(starlark.Callable)
+		if !ok {
+			return nil, fmt.Errorf("%s is a %s, not a function", name, v.Type())
+		}
+		*hook = fn
+	}
+	if h.onRequest == nil && h.onResponse == nil {
+		return nil, fmt.Errorf("%s defines neither on_request nor on_response", path)
+	}
+	return h, nil
+}
+
+func scriptPrint(logger *log.Logger) func(*starlark.Thread, string) {
+	return func(_ *starlark.Thread, msg string) {
+		logger.Printf("Script: %s", msg)
+	}
+}
+
+func callHook(fn starlark.Callable, arg *starlark.Dict, logger *log.Logger) error {
+	thread := &starlark.Thread{Name: fn.Name(), Print: scriptPrint(logger)}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	_, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+	return err
+}
+
+// director runs on_request after director, on the request as it will be sent
+func (h *scriptHooks) director(director func(*http.Request), d *dumper) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		if h.onRequest != nil {
+			h.rewriteRequest(req, d)
+		}
+	}
+}
+
+func (h *scriptHooks) rewriteRequest(req *http.Request, d *dumper) {
+	logger := d.loggerFor(req.Context())
+	var raw, body []byte
+	// reading these bodies now would defeat 100-continue and streaming
+	hasBody := req.Body != nil && req.Body != http.NoBody && !expectsContinue(req) && !d.streamsUpload(req)
+	if hasBody {
+		var err error
+		if raw, err = readScriptBody(&req.Body); err != nil {
+			logger.Printf("Script: error reading request body, on_request skipped: %v", err)
+			return
+		}
+		body = debugproxy.DecodeContentEncoding(raw, req.Header.Get("Content-Encoding"))
+	}
+	headers, before := headerDict(req.Header)
+	dict := starlark.NewDict(4)
+	_ = dict.SetKey(starlark.String("method"), starlark.String(req.Method))
+	_ = dict.SetKey(starlark.String("url"), starlark.String(req.URL.String()))
+	_ = dict.SetKey(starlark.String("headers"), headers)
+	_ = dict.SetKey(starlark.String("body"), bodyValue(body, hasBody))
+	if err := callHook(h.onRequest, dict, logger); err != nil {
+		logger.Printf("Script: on_request failed, request passed through unchanged: %v", err)
+		return
+	}
+	method, err := dictString(dict, "method")
+	if err != nil {
+		logger.Printf("Script: on_request result ignored: %v", err)
+		return
+	}
+	rawURL, err := dictString(dict, "url")
+	if err != nil {
+		logger.Printf("Script: on_request result ignored: %v", err)
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		logger.Printf("Script: on_request result ignored: invalid url: %v", err)
+		return
+	}
+	after, err := dictHeaders(dict)
+	if err != nil {
+		logger.Printf("Script: on_request result ignored: %v", err)
+		return
+	}
+	newBody, bodyChanged, err := dictBody(dict, body, hasBody)
+	if err != nil {
+		logger.Printf("Script: on_request result ignored: %v", err)
+		return
+	}
+	req.Method = method
+	if u.String() != req.URL.String() {
+		req.URL = u
+		req.Host = ""
+	}
+	applyHeaders(req.Header, before, after)
+	if bodyChanged {
+		req.Header.Del("Content-Encoding")
+		req.Body = io.NopCloser(bytes.NewReader(newBody))
+		req.ContentLength = int64(len(newBody))
+		req.TransferEncoding = nil
+	}
+}
+
+// rewriteResponse runs on_response. Streamed responses are passed without
+// their body, which is left alone.
+func (h *scriptHooks) rewriteResponse(resp *http.Response, d *dumper, streamed bool) {
+	if h.onResponse == nil {
+		return
+	}
+	logger := d.loggerFor(resp.Request.Context())
+	var raw, body []byte
+	hasBody := !streamed && resp.Body != nil && resp.Body != http.NoBody
+	if hasBody {
+		var err error
+		if raw, err = readScriptBody(&resp.Body); err != nil {
+			logger.Printf("Script: error reading response body, on_response skipped: %v", err)
+			return
+		}
+		body = debugproxy.DecodeContentEncoding(raw, resp.Header.Get("Content-Encoding"))
+	}
+	request := starlark.NewDict(2)
+	_ = request.SetKey(starlark.String("method"), starlark.String(resp.Request.Method))
+	_ = request.SetKey(starlark.String("url"), starlark.String(resp.Request.URL.String()))
+	headers, before := headerDict(resp.Header)
+	dict := starlark.NewDict(4)
+	_ = dict.SetKey(starlark.String("status"), starlark.MakeInt(resp.StatusCode))
+	_ = dict.SetKey(starlark.String("headers"), headers)
+	_ = dict.SetKey(starlark.String("body"), bodyValue(body, hasBody))
+	_ = dict.SetKey(starlark.String("request"), request)
+	if err := callHook(h.onResponse, dict, logger); err != nil {
+		logger.Printf("Script: on_response failed, response passed through unchanged: %v", err)
+		return
+	}
+	v, _, _ := dict.Get(starlark.String("status"))
+	status, err := starlark.AsInt32(v)
+	if err != nil || status < 100 || status > 999 {
+		logger.Printf("Script: on_response result ignored: status must be an int between 100 and 999")
+		return
+	}
+	after, err := dictHeaders(dict)
+	if err != nil {
+		logger.Printf("Script: on_response result ignored: %v", err)
+		return
+	}
+	newBody, bodyChanged, err := dictBody(dict, body, hasBody)
+	if err != nil {
+		logger.Printf("Script: on_response result ignored: %v", err)
+		return
+	}
+	if status != resp.StatusCode {
+		resp.StatusCode = status
+		resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	applyHeaders(resp.Header, before, after)
+	if bodyChanged {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Set("Content-Length", strconv.Itoa(len(newBody)))
+		resp.Body = io.NopCloser(bytes.NewReader(newBody))
+		resp.ContentLength = int64(len(newBody))
+		resp.TransferEncoding = nil
+	}
+}
+
+// readScriptBody reads *body whole and replaces it with a reader over the
+// bytes, so it can still be sent. On error the bytes read are put back in
+// front of the rest so the failure reaches the transport.
+func readScriptBody(body *io.ReadCloser) ([]byte, error) {
+	orig := *body
+	raw, err := io.ReadAll(orig)
+	if err != nil {
+		*body = readCloser{io.MultiReader(bytes.NewReader(raw), orig), orig}
+		return nil, err
+	}
+	orig.Close()
+	*body = io.NopCloser(bytes.NewReader(raw))
+	return raw, nil
+}
+
+func bodyValue(body []byte, hasBody bool) starlark.Value {
+	if !hasBody {
+		return starlark.None
+	}
+	return starlark.String(body)
+}
+
+// headerDict maps each header name to its values joined by ", "; the plain
+// map is kept to tell later which headers the script changed
+func headerDict(h http.Header) (*starlark.Dict, map[string]string) {
+	dict := starlark.NewDict(len(h))
+	values := make(map[string]string, len(h))
+	for name, v := range h {
+		joined := strings.Join(v, ", ")
+		values[name] = joined
+		_ = dict.SetKey(starlark.String(name), starlark.String(joined))
+	}
+	return dict, values
+}
+
+func dictString(dict *starlark.Dict, key string) (string, error) {
+	v, _, _ := dict.Get(starlark.String(key))
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func dictHeaders(dict *starlark.Dict) (map[string]string, error) {
+	v, _, _ := dict.Get(starlark.String("headers"))
+	headers, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("headers must be a dict")
+	}
+	out := make(map[string]string, headers.Len())
+	for _, item := range headers.Items() {
+		name, ok1 := starlark.AsString(item[0])
+		value, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("header names and values must be strings")
+		}
+		out[http.CanonicalHeaderKey(name)] = value
+	}
+	return out, nil
+}
+
+// dictBody returns the body set by the script and whether it differs from body
+func dictBody(dict *starlark.Dict, body []byte, hasBody bool) ([]byte, bool, error) {
+	v, _, _ := dict.Get(starlark.String("body"))
+	if v == nil || v == starlark.None {
+		return nil, false, nil
+	}
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return nil, false, fmt.Errorf("body must be a string or None")
+	}
+	if !hasBody {
+		return []byte(s), s != "", nil
+	}
+	return []byte(s), s != string(body), nil
+}
+
+// applyHeaders sets the headers the script changed or added and removes the
+// ones it deleted; untouched headers keep their separate values
+func applyHeaders(h http.Header, before, after map[string]string) {
+	for name := range before {
+		if _, ok := after[http.CanonicalHeaderKey(name)]; !ok {
+			h.Del(name)
+		}
+	}
+	for name, value := range after {
+		if old, ok := before[name]; !ok || old != value {
+			h.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// selfTestRequest and selfTestResponse are the bodies the self-test sends
+// gzipped through the proxy in each direction
+const (
+	selfTestRequest  = `{"selftest":"request body"}`
+	selfTestResponse = `{"selftest":"response body"}`
+)
+
+// runSelfTest sends a gzipped request through a proxy on loopback to a
+// backend answering with a gzipped response, and checks that both bodies
+// arrive intact and are logged decoded. It returns the log on failure.
+func runSelfTest() (string, error) {
+	var logs bytes.Buffer
+	sink := &logSink{out: &logs}
+	d := &dumper{logger: sink.logger(nil, ""), sink: sink}
+	d.redact.Store(&debugproxy.Redactor{})
+
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	backendErr := make(chan error, 1)
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body, err := gunzip(raw)
+		if err != nil || string(body) != selfTestRequest {
+			backendErr <- fmt.Errorf("backend got request body %q (%v)", body, err)
+		}
+		gz, _ := gzipBytes([]byte(selfTestResponse))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gz)
+	})}
+	go func() { _ = backend.Serve(backendLn) }()
+	defer backend.Close()
+
+	target := &url.URL{Scheme: "http", Host: backendLn.Addr().String()}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	proxy, err := debugproxy.New(debugproxy.Options{
+		Target:    target,
+		Transport: &loggingTransport{rt: transport, dumper: d},
+		Dumper:    d,
+		MaxBody:   -1,
+	})
+	if err != nil {
+		return "", err
+	}
+	proxyLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	server := &http.Server{Handler: proxy}
+	go func() { _ = server.Serve(proxyLn) }()
+	defer server.Close()
+
+	gz, err := gzipBytes([]byte(selfTestRequest))
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+proxyLn.Addr().String()+"/selftest", bytes.NewReader(gz))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return logs.String(), fmt.Errorf("request through the proxy failed: %w", err)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return logs.String(), fmt.Errorf("reading the response failed: %w", err)
+	}
+	select {
+	case err := <-backendErr:
+		return logs.String(), err
+	default:
+	}
+	if body, err := gunzip(raw); err != nil || string(body) != selfTestResponse {
+		return logs.String(), fmt.Errorf("client got response body %q (%v)", body, err)
+	}
+	var failed []string
+	for _, want := range []string{
+		"----- REQUEST BODY (gzip: ", selfTestRequest,
+		"----- RESPONSE BODY (gzip: ", selfTestResponse,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			failed = append(failed, fmt.Sprintf("%q", want))
+		}
+	}
+	if len(failed) > 0 {
+		return logs.String(), errors.New("log is missing " + strings.Join(failed, ", "))
+	}
+	return logs.String(), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// serialTransport lets one request at a time through to the backend, in
+// arrival order. A request holds its turn until its response body is
+// closed, so the backend never sees two exchanges overlap.
+type serialTransport struct {
+	rt     http.RoundTripper
+	dumper *dumper
+
+	mu sync.Mutex
+	// busy is set while a request has its turn; waiting are the turns of
+	// the queued requests, oldest first
+	busy    bool
+	waiting []chan struct{}
+}
+
+func (t *serialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.acquire(req); err != nil {
+		return nil, err
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok {
+		// an upgraded connection, which the reverse proxy needs to write to
+		resp.Body = &releaseConn{ReadWriteCloser: conn, release: t.release}
+	} else {
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: t.release}
+	}
+	return resp, nil
+}
+
+// acquire waits for the turn of req, giving up when its client goes away
+func (t *serialTransport) acquire(req *http.Request) error {
+	t.mu.Lock()
+	if !t.busy {
+		t.busy = true
+		t.mu.Unlock()
+		return nil
+	}
+	turn := make(chan struct{})
+	t.waiting = append(t.waiting, turn)
+	depth := len(t.waiting)
+	t.mu.Unlock()
+	t.dumper.at(levelInfo, t.dumper.loggerFor(req.Context())).Printf("Serialized: %s %s queued, %d waiting", req.Method, t.dumper.sanitize.url(req.URL), depth)
+	select {
+	case <-turn:
+		return nil
+	case <-req.Context().Done():
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for i, c := range t.waiting {
+			if c == turn {
+				t.waiting = append(t.waiting[:i], t.waiting[i+1:]...)
+				return req.Context().Err()
+			}
+		}
+		// the turn was handed over just as the client left; pass it on
+		t.next()
+		return req.Context().Err()
+	}
+}
+
+func (t *serialTransport) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next()
+}
+
+// next hands the turn to the oldest waiting request, with t.mu held
+func (t *serialTransport) next() {
+	if len(t.waiting) == 0 {
+		t.busy = false
+		return
+	}
+	close(t.waiting[0])
+	t.waiting = t.waiting[1:]
+}
+
+// releaseBody calls release once, when the body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// releaseConn is releaseBody for the body of a 101 Switching Protocols response
+type releaseConn struct {
+	io.ReadWriteCloser
+	release func()
+	once    sync.Once
+}
+
+func (c *releaseConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.release)
+	return err
+}
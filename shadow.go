@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// shadowTimeout bounds each request sent to the -shadow backend
+const shadowTimeout = 30 * time.Second
+
+// maxShadowBody bounds the bodies kept to compare; larger ones are compared
+// by status and headers only
+const maxShadowBody = 8 << 20
+
+// maxShadowDiffs bounds the differences listed per exchange
+const maxShadowDiffs = 50
+
+// shadowTransport sends every request to the backend through rt and a copy
+// to a shadow backend, returns the primary response, and once both are read
+// logs the differences in status, headers and bodies, JSON compared field by
+// field. The comparison is logged through the exchange's logger once the
+// shadow answered, so the client never waits for the shadow; a -group-logs
+// exchange holds its block until the comparison is in it.
+type shadowTransport struct {
+	rt     http.RoundTripper
+	target *url.URL
+	client *http.Client
+	dumper *dumper
+	// ignore are the canonical names of headers left out of the comparison
+	ignore map[string]bool
+}
+
+func newShadowTransport(rt http.RoundTripper, target *url.URL, transport http.RoundTripper, ignore string, d *dumper) *shadowTransport {
+	t := &shadowTransport{
+		rt:     rt,
+		target: target,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   shadowTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		dumper: d,
+		ignore: map[string]bool{},
+	}
+	for _, name := range strings.Split(ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			t.ignore[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return t
+}
+
+// shadowResponse is what a backend answered, with its decoded body
+type shadowResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	truncated bool
+	err       error
+}
+
+func (t *shadowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	shadow := make(chan shadowResponse, 1)
+	if err := t.send(req, shadow); err != nil {
+		t.logf(req, "shadow not sent: %v", err)
+		return t.rt.RoundTrip(req)
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := resp.Body.(io.ReadWriteCloser); ok {
+		// an upgraded connection has no body to compare
+		return resp, nil
+	}
+	// as the backend sent it, before the proxy changes the response
+	status, header := resp.StatusCode, resp.Header.Clone()
+	resp.Body = &keptBody{ReadCloser: resp.Body, limit: maxShadowBody, done: func(body []byte, truncated bool) {
+		primary := shadowResponse{status: status, header: header, body: body, truncated: truncated}
+		ex := exchangeFrom(req.Context())
+		if ex != nil {
+			ex.shadows.Add(1)
+		}
+		go func() {
+			if ex != nil {
+				defer ex.shadows.Done()
+			}
+			t.compare(req, primary, shadow)
+		}()
+	}}
+	return resp, nil
+}
+
+// send sends a copy of req to the shadow target and delivers its response
+// to shadow. Bodies that are not buffered cannot be read twice.
+func (t *shadowTransport) send(req *http.Request, shadow chan<- shadowResponse) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if !bodyBuffered(req) {
+			return fmt.Errorf("streamed request body")
+		}
+		var err error
+		if body, err = readScriptBody(&req.Body); err != nil {
+			return err
+		}
+	}
+	u := *t.target
+	u.Path, u.RawPath = req.URL.Path, req.URL.RawPath
+	u.RawQuery = req.URL.RawQuery
+	ctx := context.WithoutCancel(req.Context())
+	r, err := http.NewRequestWithContext(ctx, req.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header = req.Header.Clone()
+	// both backends get the same request
+	r.Host = req.Host
+	if body == nil {
+		r.Body, r.ContentLength = http.NoBody, 0
+		r.Header.Del("Content-Length")
+	}
+	go func() {
+		resp, err := t.client.Do(r)
+		if err != nil {
+			shadow <- shadowResponse{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxShadowBody+1))
+		if err != nil {
+			shadow <- shadowResponse{err: err}
+			return
+		}
+		shadow <- shadowResponse{
+			status:    resp.StatusCode,
+			header:    resp.Header,
+			body:      body[:min(len(body), maxShadowBody)],
+			truncated: len(body) > maxShadowBody,
+		}
+	}()
+	return nil
+}
+
+// compare logs how the shadow response differs from the primary one
+func (t *shadowTransport) compare(req *http.Request, primary shadowResponse, shadow <-chan shadowResponse) {
+	other := <-shadow
+	if other.err != nil {
+		t.logf(req, "shadow failed: %v", other.err)
+		return
+	}
+	var diffs []string
+	if primary.status != other.status {
+		diffs = append(diffs, fmt.Sprintf("status: %d != %d", primary.status, other.status))
+	}
+	redact := t.dumper.redactor(exchangeFrom(req.Context()))
+	diffs = append(diffs, t.headerDiffs(redact.Header(primary.header), redact.Header(other.header))...)
+	if primary.truncated || other.truncated {
+		diffs = append(diffs, fmt.Sprintf("body: over %d bytes or not read whole, not compared", maxShadowBody))
+	} else {
+		a := redact.JSONBody(debugproxy.DecodeContentEncoding(primary.body, primary.header.Get("Content-Encoding")), primary.header.Get("Content-Type"))
+		b := redact.JSONBody(debugproxy.DecodeContentEncoding(other.body, other.header.Get("Content-Encoding")), other.header.Get("Content-Type"))
+		diffs = append(diffs, bodyDiffs(a, b)...)
+	}
+	if len(diffs) == 0 {
+		t.logf(req, "identical")
+		return
+	}
+	count, more := fmt.Sprintf("%d differences", len(diffs)), ""
+	if len(diffs) == 1 {
+		count = "1 difference"
+	}
+	if len(diffs) > maxShadowDiffs {
+		more = fmt.Sprintf("\n  ... %d more", len(diffs)-maxShadowDiffs)
+		diffs = diffs[:maxShadowDiffs]
+	}
+	t.logf(req, "%s (primary != shadow)\n  %s%s", count, strings.Join(diffs, "\n  "), more)
+}
+
+// logf logs a SHADOW line through the logger the exchange has now, so it is
+// filtered and grouped as the exchange's other lines
+func (t *shadowTransport) logf(req *http.Request, format string, args ...any) {
+	logger := t.dumper.at(levelInfo, t.dumper.loggerFor(req.Context()))
+	id, method, uri := "", req.Method, req.URL.RequestURI()
+	if ex := exchangeFrom(req.Context()); ex != nil {
+		id, method, uri = " #"+ex.idString(), ex.method, ex.clientURI
+	}
+	logger.Printf("SHADOW%s %s %s: %s", id, method, t.dumper.sanitize.uri(uri), fmt.Sprintf(format, args...))
+}
+
+// headerDiffs lists the headers missing from either side or with other values
+func (t *shadowTransport) headerDiffs(a, b http.Header) []string {
+	names := map[string]bool{}
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	var diffs []string
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		if t.ignore[name] {
+			continue
+		}
+		av, bv := strings.Join(a[name], ", "), strings.Join(b[name], ", ")
+		switch {
+		case a[name] == nil:
+			diffs = append(diffs, fmt.Sprintf("header %s: only in shadow: %s", name, bv))
+		case b[name] == nil:
+			diffs = append(diffs, fmt.Sprintf("header %s: missing in shadow: %s", name, av))
+		case av != bv:
+			diffs = append(diffs, fmt.Sprintf("header %s: %s != %s", name, av, bv))
+		}
+	}
+	return diffs
+}
+
+// bodyDiffs compares JSON bodies field by field and other bodies byte by
+// byte
+func bodyDiffs(a, b []byte) []string {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if docA, ok := parseJSONDoc(a); ok {
+		if docB, ok := parseJSONDoc(b); ok {
+			return jsonDiffs("$", docA, docB, nil)
+		}
+	}
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return []string{fmt.Sprintf("body: %d bytes != %d bytes, first difference at byte %d", len(a), len(b), i)}
+}
+
+// jsonDiffs appends the differences between the JSON values a and b at path
+func jsonDiffs(path string, a, b any, diffs []string) []string {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := map[string]bool{}
+			for k := range a {
+				keys[k] = true
+			}
+			for k := range b {
+				keys[k] = true
+			}
+			for _, k := range slices.Sorted(maps.Keys(keys)) {
+				av, inA := a[k]
+				bv, inB := b[k]
+				switch {
+				case !inA:
+					diffs = append(diffs, fmt.Sprintf("%s.%s: only in shadow: %s", path, k, jsonText(bv)))
+				case !inB:
+					diffs = append(diffs, fmt.Sprintf("%s.%s: missing in shadow: %s", path, k, jsonText(av)))
+				default:
+					diffs = jsonDiffs(path+"."+k, av, bv, diffs)
+				}
+			}
+			return diffs
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			for i := range min(len(a), len(b)) {
+				diffs = jsonDiffs(fmt.Sprintf("%s[%d]", path, i), a[i], b[i], diffs)
+			}
+			if len(a) != len(b) {
+				diffs = append(diffs, fmt.Sprintf("%s: %d items != %d items", path, len(a), len(b)))
+			}
+			return diffs
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		diffs = append(diffs, fmt.Sprintf("%s: %s != %s", path, jsonText(a), jsonText(b)))
+	}
+	return diffs
+}
+
+// jsonText shows a JSON value in a difference, objects and arrays by size
+func jsonText(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return fmt.Sprintf("{%d fields}", len(v))
+	case []any:
+		return fmt.Sprintf("[%d items]", len(v))
+	case string:
+		return fmt.Sprintf("%q", v)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+// keptBody keeps up to limit bytes of a response body as it is read, and
+// hands them to done on close, truncated when the body was not read whole
+type keptBody struct {
+	io.ReadCloser
+	limit int
+	done  func(body []byte, truncated bool)
+
+	buf       bytes.Buffer
+	truncated bool
+	eof       bool
+	once      sync.Once
+}
+
+func (b *keptBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.limit - b.buf.Len(); n > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.buf.Write(p[:n])
+	}
+	b.eof = b.eof || err == io.EOF
+	return n, err
+}
+
+func (b *keptBody) Close() error {
+	// a body left unread, when the client went away, counts as truncated
+	b.once.Do(func() { b.done(b.buf.Bytes(), b.truncated || !b.eof) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+)
+
+// jsonBackend answers every request with body as JSON
+func jsonBackend(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// startShadowProxy proxies to primary and compares with shadow, as -shadow
+func startShadowProxy(t *testing.T, d *dumper, primary, shadow string) *httptest.Server {
+	t.Helper()
+	shadowURL, err := url.Parse(shadow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return startProxy(t, d, primary, func(o *debugproxy.Options) {
+		lt := o.Transport.(*loggingTransport)
+		lt.rt = newShadowTransport(lt.rt, shadowURL, http.DefaultTransport, "Date", d)
+	})
+}
+
+func get(t *testing.T, u string) {
+	t.Helper()
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// waitForLog waits for the comparisons logged apart from the exchange
+func waitForLog(t *testing.T, logs *syncBuffer, want string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(logs.String(), want); {
+		if time.Now().After(deadline) {
+			t.Fatalf("log is missing %q:\n%s", want, logs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const shadowDiff = "1 difference (primary != shadow)\n  $.total: 2 != 3"
+
+func TestShadowLogsTheDifferingField(t *testing.T) {
+	primary := jsonBackend(t, `{"id": 7, "total": 2}`)
+	shadow := jsonBackend(t, `{"id": 7, "total": 3}`)
+	d, logs := newTestDumper()
+	filter, err := parseExchangeFilter([]string{"exclude-path=^/hidden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.logFilter.Store(filter)
+	proxy := startShadowProxy(t, d, primary.URL, shadow.URL)
+
+	get(t, proxy.URL+"/hidden")
+	get(t, proxy.URL+"/orders/7")
+	proxy.Close()
+
+	waitForLog(t, logs, "SHADOW #2 GET /orders/7: "+shadowDiff)
+	if strings.Contains(logs.String(), "/hidden") {
+		t.Errorf("the comparison of an exchange left out by -exclude-path was logged:\n%s", logs)
+	}
+}
+
+func TestShadowComparisonIsPartOfTheGroup(t *testing.T) {
+	primary := jsonBackend(t, `{"id": 7, "total": 2}`)
+	// answering late, after the client got the primary response
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id": 7, "total": 3}`)
+	}))
+	defer shadow.Close()
+	d, logs := newTestDumper()
+	d.groupLogs = true
+	proxy := startShadowProxy(t, d, primary.URL, shadow.URL)
+
+	get(t, proxy.URL+"/orders/7")
+	// closing waits for the exchange, whose block holds the comparison
+	proxy.Close()
+
+	out := logs.String()
+	dump, comparison := strings.Index(out, "----- RESPONSE BODY -----"), strings.Index(out, "SHADOW #1 GET /orders/7: "+shadowDiff)
+	if dump < 0 || comparison < 0 {
+		t.Fatalf("log is missing the dump or the comparison:\n%s", out)
+	}
+	if comparison < dump {
+		t.Errorf("the comparison was logged before the grouped dump:\n%s", out)
+	}
+}
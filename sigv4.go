@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// sigV4Auth is the parsed form of an AWS Signature Version 4 Authorization
+// header such as "AWS4-HMAC-SHA256 Credential=AKID/20260101/us-east-1/s3/aws4_request,
+// SignedHeaders=host;x-amz-date, Signature=..."
+type sigV4Auth struct {
+	algorithm     string
+	accessKey     string
+	scope         []string
+	signedHeaders []string
+	signature     string
+}
+
+// parseSigV4 parses a SigV4 Authorization header; it does not check the signature
+func parseSigV4(header string) (*sigV4Auth, bool) {
+	algorithm, params, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.HasPrefix(algorithm, "AWS4-") {
+		return nil, false
+	}
+	a := &sigV4Auth{algorithm: algorithm}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "Credential":
+			a.accessKey, _, _ = strings.Cut(value, "/")
+			if _, scope, ok := strings.Cut(value, "/"); ok {
+				a.scope = strings.Split(scope, "/")
+			}
+		case "SignedHeaders":
+			a.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			a.signature = value
+		}
+	}
+	if a.accessKey == "" || a.signature == "" {
+		return nil, false
+	}
+	return a, true
+}
+
+// logSigV4 logs the parts of a SigV4 Authorization header on separate lines,
+// marking the signed headers the forwarded request does not carry
+func logSigV4(logger *log.Logger, req *http.Request) {
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		return
+	}
+	a, ok := parseSigV4(header)
+	if !ok {
+		if strings.HasPrefix(header, "AWS4-") {
+			logger.Printf("SigV4: malformed Authorization header")
+		}
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Algorithm:      %s\n", a.algorithm)
+	fmt.Fprintf(&b, "Access key:     %s\n", a.accessKey)
+	scope := strings.Join(a.scope, "/")
+	if len(a.scope) == 4 {
+		scope += fmt.Sprintf(" (date=%s region=%s service=%s)", a.scope[0], a.scope[1], a.scope[2])
+	}
+	fmt.Fprintf(&b, "Scope:          %s\n", scope)
+	fmt.Fprintf(&b, "Signed headers:\n")
+	for _, name := range a.signedHeaders {
+		value, present := signedHeaderValue(req, name)
+		if !present {
+			fmt.Fprintf(&b, "  %s (missing from request)\n", name)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", name, value)
+	}
+	fmt.Fprintf(&b, "Signature:      %s\n", a.signature)
+	logger.Printf("----- SIGV4 AUTHORIZATION -----\n%s", b.String())
+}
+
+// signedHeaderValue returns the value of a signed header as the backend
+// receives it; Host is not kept in the header map
+func signedHeaderValue(req *http.Request, name string) (string, bool) {
+	if strings.EqualFold(name, "host") {
+		if req.Host != "" {
+			return req.Host, true
+		}
+		return req.URL.Host, req.URL.Host != ""
+	}
+	values, ok := req.Header[http.CanonicalHeaderKey(name)]
+	return strings.Join(values, ","), ok
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// logSink is where all log output ends up. Loggers created by the sink render
+// timestamps as configured; out receives fully formatted lines, so output
+// that was held back (see exchange) can be copied to it as is.
+type logSink struct {
+	out io.Writer
+	// layout is a custom timestamp layout; empty keeps the log package format
+	layout string
+	utc    bool
+	// level is the least important level of messages shown
+	level logLevel
+}
+
+// logger returns a logger writing to w, or to the sink output when w is nil,
+// with prefix placed right before each message
+func (s *logSink) logger(w io.Writer, prefix string) *log.Logger {
+	if w == nil {
+		w = s.out
+	}
+	flags := log.LstdFlags
+	if s.layout != "" {
+		flags = 0
+		w = &timestampWriter{w: w, layout: s.layout, utc: s.utc}
+	} else if s.utc {
+		flags |= log.LUTC
+	}
+	if prefix != "" {
+		flags |= log.Lmsgprefix
+	}
+	return log.New(w, prefix, flags)
+}
+
+// timestampWriter prefixes every log message with the current time in a
+// custom layout
+type timestampWriter struct {
+	w      io.Writer
+	layout string
+	utc    bool
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if t.utc {
+		now = now.UTC()
+	}
+	line := make([]byte, 0, len(t.layout)+1+len(p))
+	line = now.AppendFormat(line, t.layout)
+	line = append(line, ' ')
+	if _, err := t.w.Write(append(line, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineCapWriter truncates every line written through it to max characters,
+// marking cut lines with an ellipsis. The log package issues one Write per
+// message, so multi-line messages (header and body dumps) are capped line by
+// line.
+type lineCapWriter struct {
+	w   io.Writer
+	max int
+}
+
+func newLineCapWriter(w io.Writer, max int) *lineCapWriter {
+	return &lineCapWriter{w: w, max: max}
+}
+
+func (c *lineCapWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	rest := p
+	for len(rest) > 0 {
+		line, tail, found := bytes.Cut(rest, []byte("\n"))
+		// keep the CR of CRLF-terminated header lines out of the count
+		cr := bytes.HasSuffix(line, []byte("\r"))
+		out.Write(c.capLine(bytes.TrimSuffix(line, []byte("\r"))))
+		if cr {
+			out.WriteByte('\r')
+		}
+		if found {
+			out.WriteByte('\n')
+		}
+		rest = tail
+	}
+	if _, err := c.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *lineCapWriter) capLine(line []byte) []byte {
+	if utf8.RuneCount(line) <= c.max {
+		return line
+	}
+	// find the byte offset of the max-th character
+	cut, n := 0, 0
+	for n < c.max {
+		_, size := utf8.DecodeRune(line[cut:])
+		cut += size
+		n++
+	}
+	capped := make([]byte, 0, cut+len("…"))
+	capped = append(capped, line[:cut]...)
+	return append(capped, "…"...)
+}
+
+// logLevel ranks log messages; a sink shows the messages at or above its
+// level. The zero value shows everything, as before levels existed.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[string]logLevel{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+func parseLogLevel(s string) (logLevel, error) {
+	l, ok := levelNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, expected error, warn, info or debug", s)
+	}
+	return l, nil
+}
+
+// enabled reports whether the sink shows messages at level l
+func (s *logSink) enabled(l logLevel) bool {
+	return s == nil || l >= s.level
+}
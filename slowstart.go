@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// slowStart paces requests to the backend at a rate growing linearly from a
+// tenth of the target rate to the target over the ramp; afterwards requests
+// are no longer throttled.
+type slowStart struct {
+	start    time.Time
+	duration time.Duration
+	target   float64
+
+	mu sync.Mutex
+	// next is the earliest time the next request may go out
+	next time.Time
+}
+
+func newSlowStart(duration time.Duration, target float64) *slowStart {
+	return &slowStart{start: time.Now(), duration: duration, target: target}
+}
+
+// rate is the allowed requests per second at t
+func (s *slowStart) rate(t time.Time) float64 {
+	progress := float64(t.Sub(s.start)) / float64(s.duration)
+	initial := s.target / 10
+	return initial + (s.target-initial)*min(progress, 1)
+}
+
+// wait blocks until the request may be sent, returning how long it waited.
+// It fails when ctx ends first.
+func (s *slowStart) wait(ctx context.Context) (time.Duration, error) {
+	now := time.Now()
+	if now.Sub(s.start) >= s.duration {
+		return 0, nil
+	}
+	s.mu.Lock()
+	slot := s.next
+	if slot.Before(now) {
+		slot = now
+	}
+	s.next = slot.Add(time.Duration(float64(time.Second) / s.rate(slot)))
+	s.mu.Unlock()
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return delay, ctx.Err()
+	}
+}
+
+// logProgress logs the allowed rate at every tenth of the ramp, then its end
+func (s *slowStart) logProgress(logger *log.Logger) {
+	logger.Printf("Slow start: ramping from %.2f to %.2f req/s over %s", s.rate(s.start), s.target, s.duration)
+	for step := 1; step <= 10; step++ {
+		time.Sleep(time.Until(s.start.Add(s.duration * time.Duration(step) / 10)))
+		if step < 10 {
+			logger.Printf("Slow start: %d%% of ramp, limit %.2f req/s", step*10, s.rate(time.Now()))
+		}
+	}
+	logger.Printf("Slow start: ramp complete, requests are no longer throttled")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds the latencies kept for percentile estimation
+const maxLatencySamples = 10000
+
+// sessionStats accumulates transaction counters for the shutdown summary
+type sessionStats struct {
+	mu         sync.Mutex
+	started    time.Time
+	total      int64
+	byStatus   map[int]int64
+	latencySum time.Duration
+	// latencies is a uniform reservoir sample of all observed latencies
+	latencies []time.Duration
+	bytesIn   int64
+	bytesOut  int64
+	rng       *rand.Rand
+}
+
+func newSessionStats() *sessionStats {
+	return &sessionStats{
+		started:  time.Now(),
+		byStatus: make(map[int]int64),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// record accounts one completed transaction
+func (s *sessionStats) record(status int, latency time.Duration, bytesIn, bytesOut int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.byStatus[status]++
+	s.latencySum += latency
+	s.bytesIn += bytesIn
+	s.bytesOut += bytesOut
+	if len(s.latencies) < maxLatencySamples {
+		s.latencies = append(s.latencies, latency)
+	} else if i := s.rng.Int63n(s.total); i < maxLatencySamples {
+		s.latencies[i] = latency
+	}
+}
+
+// logSummary logs the session summary block
+func (s *sessionStats) logSummary(logger *log.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Duration: %s\n", time.Since(s.started).Round(time.Millisecond))
+	fmt.Fprintf(&b, "Requests: %d\n", s.total)
+	if s.total > 0 {
+		statuses := make([]int, 0, len(s.byStatus))
+		for status := range s.byStatus {
+			statuses = append(statuses, status)
+		}
+		slices.Sort(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(&b, "  %d: %d\n", status, s.byStatus[status])
+		}
+		sorted := slices.Clone(s.latencies)
+		slices.Sort(sorted)
+		fmt.Fprintf(&b, "Latency: avg=%s p50=%s p90=%s p99=%s max=%s\n",
+			(s.latencySum / time.Duration(s.total)).Round(time.Microsecond),
+			percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), sorted[len(sorted)-1].Round(time.Microsecond))
+	}
+	fmt.Fprintf(&b, "Bytes: in=%d out=%d", s.bytesIn, s.bytesOut)
+	logger.Printf("----- SESSION SUMMARY -----\n%s", b.String())
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
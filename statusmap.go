@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseStatusMap parses -transform-status values, each "from=to"
+func parseStatusMap(specs []string) (map[int]int, error) {
+	m := make(map[int]int, len(specs))
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		f, err1 := strconv.Atoi(strings.TrimSpace(from))
+		t, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if !ok || err1 != nil || err2 != nil || f < 100 || f > 999 || t < 100 || t > 999 {
+			return nil, fmt.Errorf("invalid status mapping %q, expected from=to such as 418=503", spec)
+		}
+		m[f] = t
+	}
+	return m, nil
+}
+
+// transformStatus rewrites the status of resp when it is remapped, leaving the body alone
+func (d *dumper) transformStatus(resp *http.Response, statuses map[int]int) {
+	to, ok := statuses[resp.StatusCode]
+	if !ok {
+		return
+	}
+	original := resp.Status
+	resp.StatusCode = to
+	resp.Status = fmt.Sprintf("%d %s", to, http.StatusText(to))
+	d.at(levelInfo, d.loggerFor(resp.Request.Context())).Printf("Status %s remapped to %s (-transform-status)", original, resp.Status)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gitbhut.com/nopcoder/http-debug-proxy/debugproxy"
+	"gopkg.in/yaml.v3"
+)
+
+// stubRule is a canned response from a -stubs file. Method and path select
+// the requests it answers; an empty method matches any.
+type stubRule struct {
+	Method   string            `yaml:"method"`
+	Path     string            `yaml:"path"`
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+
+	path     *regexp.Regexp
+	body     []byte
+	template *template.Template
+}
+
+// stubTransport answers the requests matching a stub rule itself, the first
+// matching rule winning, and sends the others on to rt. Stub bodies holding
+// {{ are rendered as -replay-template bodies are.
+type stubTransport struct {
+	rt     http.RoundTripper
+	dumper *dumper
+	rules  []*stubRule
+}
+
+// loadStubs reads a YAML or JSON list of stub rules; body_file paths are
+// relative to the stub file
+func loadStubs(path string, rt http.RoundTripper, d *dumper) (*stubTransport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*stubRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, r := range rules {
+		name := fmt.Sprintf("stub %d", i+1)
+		if r.Path == "" {
+			return nil, fmt.Errorf("%s: path is required", name)
+		}
+		if r.path, err = regexp.Compile(r.Path); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if r.Status == 0 {
+			r.Status = http.StatusOK
+		}
+		if r.Status < 100 || r.Status > 999 {
+			return nil, fmt.Errorf("%s: invalid status %d", name, r.Status)
+		}
+		r.Method = strings.ToUpper(r.Method)
+		r.body = []byte(r.Body)
+		if r.BodyFile != "" {
+			file := r.BodyFile
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(path), file)
+			}
+			if r.body, err = os.ReadFile(file); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if r.template, err = parseBodyTemplate(name, r.body); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return &stubTransport{rt: rt, dumper: d, rules: rules}, nil
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if ex := exchangeFrom(req.Context()); ex != nil {
+		path = ex.clientPath()
+	}
+	for i, r := range t.rules {
+		if r.Method != "" && r.Method != req.Method || !r.path.MatchString(path) {
+			continue
+		}
+		body := r.body
+		if r.template != nil {
+			var reqBody []byte
+			if req.Body != nil && req.Body != http.NoBody {
+				raw, err := readScriptBody(&req.Body)
+				if err != nil {
+					return nil, err
+				}
+				reqBody = debugproxy.DecodeContentEncoding(raw, req.Header.Get("Content-Encoding"))
+			}
+			rendered, err := executeBodyTemplate(r.template, req, reqBody)
+			if err != nil {
+				t.dumper.loggerFor(req.Context()).Printf("Stub: error rendering the body of stub %d, serving it as is: %v", i+1, err)
+			} else {
+				body = rendered
+			}
+		}
+		t.dumper.at(levelInfo, t.dumper.loggerFor(req.Context())).Printf("Stub: %s %s answered by stub %d (%q), status %d, without contacting the backend", req.Method, t.dumper.sanitize.uri(req.URL.RequestURI()), i+1, r.Path, r.Status)
+		h := http.Header{}
+		for name, value := range r.Headers {
+			h.Set(name, value)
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return fixtureResponse(fixtureExchange{Status: r.Status, ResponseHeader: h, ResponseBody: body}, req), nil
+	}
+	return t.rt.RoundTrip(req)
+}
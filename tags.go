@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tagRule labels requests whose path matches re
+type tagRule struct {
+	re    *regexp.Regexp
+	label string
+}
+
+// parseTagRule parses "regex=label". The label is taken after the last '='
+// so the regular expression itself may contain '='.
+func parseTagRule(s string) (tagRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return tagRule{}, fmt.Errorf("invalid tag %q, expected regex=label", s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return tagRule{}, fmt.Errorf("invalid tag regex %q: %w", s[:i], err)
+	}
+	return tagRule{re: re, label: s[i+1:]}, nil
+}
+
+// tagPrefix returns the log prefix made of the labels of all rules matching path
+func tagPrefix(rules []tagRule, path string) string {
+	var b strings.Builder
+	for _, r := range rules {
+		if r.re.MatchString(path) {
+			b.WriteString("[" + r.label + "] ")
+		}
+	}
+	return b.String()
+}
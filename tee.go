@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// teeQueueSize bounds the mirrored requests waiting to be sent
+const teeQueueSize = 256
+
+// teeTimeout bounds each mirrored request
+const teeTimeout = 10 * time.Second
+
+// teeSender sends a copy of every forwarded request to a shadow target, from
+// a goroutine. Responses are discarded; when the queue is full copies are
+// dropped, so the shadow never holds back proxying. With headersOnly the
+// request line and headers are mirrored without the body.
+type teeSender struct {
+	target      *url.URL
+	headersOnly bool
+	client      *http.Client
+	logger      *log.Logger
+	queue       chan *http.Request
+}
+
+func newTeeSender(target *url.URL, headersOnly bool, transport http.RoundTripper, logger *log.Logger) *teeSender {
+	s := &teeSender{
+		target:      target,
+		headersOnly: headersOnly,
+		client:      &http.Client{Transport: transport, Timeout: teeTimeout},
+		logger:      logger,
+		queue:       make(chan *http.Request, teeQueueSize),
+	}
+	go s.run()
+	return s
+}
+
+// mirror queues a copy of req. Bodies that are not buffered, streamed
+// uploads or 100-continue bodies, cannot be read twice and are left out.
+func (s *teeSender) mirror(req *http.Request) {
+	var body []byte
+	if !s.headersOnly && req.Body != nil && req.Body != http.NoBody && bodyBuffered(req) {
+		var err error
+		if body, err = readScriptBody(&req.Body); err != nil {
+			return
+		}
+	}
+	u := *s.target
+	u.Path, u.RawPath = req.URL.Path, req.URL.RawPath
+	u.RawQuery = req.URL.RawQuery
+	shadow, err := http.NewRequestWithContext(context.Background(), req.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		s.logger.Printf("Tee: error creating mirrored request: %v", err)
+		return
+	}
+	shadow.Header = req.Header.Clone()
+	if body == nil {
+		shadow.Body, shadow.ContentLength = http.NoBody, 0
+		shadow.Header.Del("Content-Length")
+	}
+	select {
+	case s.queue <- shadow:
+	default:
+		s.logger.Printf("Tee: queue full, copy of %s %s dropped", req.Method, req.URL.Path)
+	}
+}
+
+func (s *teeSender) run() {
+	for req := range s.queue {
+		resp, err := s.client.Do(req)
+		if err != nil {
+			s.logger.Printf("Tee: %s %s failed: %v", req.Method, req.URL.Path, err)
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
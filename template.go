@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// bodyTemplateFuncs are the helpers available to templated bodies
+var bodyTemplateFuncs = template.FuncMap{
+	"now":     time.Now,
+	"unix":    func() int64 { return time.Now().Unix() },
+	"uuid":    newUUID,
+	"randInt": func(lo, hi int) int { return lo + rand.IntN(max(hi-lo, 1)) },
+}
+
+// bodyTemplateData is what a templated body sees of the request it answers,
+// e.g. {{.Path}} or {{.Header.Get "X-Request-Id"}}
+type bodyTemplateData struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   string
+}
+
+// parseBodyTemplate parses body as a text/template. Bodies without "{{" are
+// not templates and get a nil template.
+func parseBodyTemplate(name string, body []byte) (*template.Template, error) {
+	if !bytes.Contains(body, []byte("{{")) {
+		return nil, nil
+	}
+	return template.New(name).Funcs(bodyTemplateFuncs).Option("missingkey=error").Parse(string(body))
+}
+
+func executeBodyTemplate(t *template.Template, req *http.Request, body []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := t.Execute(&out, bodyTemplateData{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.RawQuery,
+		Header: req.Header,
+		Body:   string(body),
+	})
+	return out.Bytes(), err
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
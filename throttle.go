@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthUnits are the -throttle units, in bytes per second
+var bandwidthUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	// longest first, so kbps is not read as k + bps
+	{"kbps", 1000.0 / 8}, {"mbps", 1000 * 1000.0 / 8}, {"gbps", 1000 * 1000 * 1000.0 / 8}, {"bps", 1.0 / 8},
+	{"kb/s", 1024}, {"mb/s", 1024 * 1024}, {"gb/s", 1024 * 1024 * 1024}, {"b/s", 1},
+}
+
+// parseBandwidth parses a -throttle rate such as 256kbps, 2mbps (bits) or
+// 64KB/s (bytes) into bytes per second
+func parseBandwidth(s string) (float64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	for _, u := range bandwidthUnits {
+		if number, ok := strings.CutSuffix(lower, u.suffix); ok {
+			v, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil || v <= 0 {
+				break
+			}
+			return v * u.scale, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rate %q, expected a number with kbps, mbps, bps, KB/s, MB/s or B/s", s)
+}
+
+// formatBandwidth shows a rate in bytes per second as kbps
+func formatBandwidth(rate float64) string {
+	return strconv.FormatFloat(rate*8/1000, 'f', -1, 64) + "kbps"
+}
+
+// pacer spaces out bytes so they average rate bytes per second since the
+// first one, as a link of that speed would
+type pacer struct {
+	rate  float64
+	start time.Time
+	sent  int64
+}
+
+// chunk is the most bytes sent at once, a twentieth of a second's worth
+func (p *pacer) chunk() int {
+	return max(512, int(p.rate/20))
+}
+
+// wait blocks until n more bytes are due, failing when ctx ends first
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	p.sent += int64(n)
+	delay := time.Until(p.start.Add(time.Duration(float64(p.sent) / p.rate * float64(time.Second))))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledWriter sends the response body to the client at the pace of p
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	p   pacer
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), w.p.chunk())
+		if err := w.p.wait(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledBody reads the request body from the client at the pace of p
+type throttledBody struct {
+	io.ReadCloser
+	ctx context.Context
+	p   pacer
+}
+
+func (b *throttledBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf[:min(len(buf), b.p.chunk())])
+	if n > 0 {
+		if werr := b.p.wait(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// rateLimiter is a token bucket admitting rps requests per second, with
+// bursts of up to rps at once
+type rateLimiter struct {
+	rps float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{rps: rps, tokens: max(rps, 1), last: time.Now()}
+}
+
+// allow takes a token, or reports how long until the next one
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(max(l.rps, 1), l.tokens+now.Sub(l.last).Seconds()*l.rps)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rps * float64(time.Second))
+}
+
+// clientLimiter caps the requests in flight per client IP address
+type clientLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newClientLimiter(max int) *clientLimiter {
+	return &clientLimiter{max: max, inFlight: map[string]int{}}
+}
+
+// clientIP is the host part of a request's RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// acquire counts a request of ip in, returning false when ip is at the cap
+func (l *clientLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[ip] >= l.max {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+func (l *clientLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[ip]--; l.inFlight[ip] <= 0 {
+		delete(l.inFlight, ip)
+	}
+}
+
+// networkLimits simulates a constrained network for requests whose path
+// matches paths (all when empty): bandwidth in bytes per second each way,
+// a request rate and a cap on concurrent requests per client, the last two
+// answered with 429 as an API gateway would
+type networkLimits struct {
+	paths     pathBlocklist
+	bandwidth float64
+	rate      *rateLimiter
+	clients   *clientLimiter
+}
+
+func (n *networkLimits) applies(path string) bool {
+	return len(n.paths) == 0 || n.paths.match(path) != nil
+}
+
+// admit checks the request rate and client concurrency caps. It returns the
+// function releasing an admitted request, or answers w with 429 and returns
+// nil and the flag of the cap reached.
+func (n *networkLimits) admit(w http.ResponseWriter, ip string) (func(), string) {
+	if n.rate != nil {
+		if ok, retry := n.rate.allow(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, "429 Too Many Requests (injected by http-debug-proxy)", http.StatusTooManyRequests)
+			return nil, "-rps-limit"
+		}
+	}
+	if n.clients == nil {
+		return func() {}, ""
+	}
+	if !n.clients.acquire(ip) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "429 Too Many Requests (injected by http-debug-proxy)", http.StatusTooManyRequests)
+		return nil, "-max-client-concurrency"
+	}
+	return func() { n.clients.release(ip) }, ""
+}
+
+// throttle paces the request body and the response of an admitted request
+func (n *networkLimits) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if n.bandwidth <= 0 {
+		return w
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &throttledBody{ReadCloser: r.Body, ctx: r.Context(), p: pacer{rate: n.bandwidth}}
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), p: pacer{rate: n.bandwidth}}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// phaseTiming holds how long the phases of the backend request took. The
+// dial hooks may run on another goroutine, hence the mutex.
+type phaseTiming struct {
+	mu                      sync.Mutex
+	dnsStart, connectStart  time.Time
+	tlsStart, wroteRequest  time.Time
+	firstByte               time.Time
+	dns, connect, tls, ttfb time.Duration
+}
+
+// withTimingTrace records the DNS, connect, TLS and time to first byte
+// phases of req in t. A reused connection has no DNS, connect or TLS phase.
+func withTimingTrace(req *http.Request, t *phaseTiming) *http.Request {
+	set := func(f func()) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { set(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(func() { t.dns = time.Since(t.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			set(func() { t.connectStart = time.Now() })
+		},
+		ConnectDone: func(_, _ string, err error) {
+			set(func() {
+				if err == nil {
+					t.connect = time.Since(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() { set(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			set(func() {
+				if err == nil {
+					t.tls = time.Since(t.tlsStart)
+				}
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { set(func() { t.wroteRequest = time.Now() }) },
+		GotFirstResponseByte: func() {
+			set(func() {
+				t.firstByte = time.Now()
+				t.ttfb = t.firstByte.Sub(t.wroteRequest)
+			})
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// breakdown describes where the time of an exchange that started at start
+// and ended at end went, for -log-timing: transfer is the time from the
+// first response byte to the end of the response to the client
+func (t *phaseTiming) breakdown(start, end time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	phase := func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return millis(d) + "ms"
+	}
+	var transfer time.Duration
+	if !t.firstByte.IsZero() {
+		transfer = end.Sub(t.firstByte)
+	}
+	line := fmt.Sprintf("dns=%s connect=%s tls=%s ttfb=%s transfer=%s total=%sms", phase(t.dns), phase(t.connect), phase(t.tls), phase(t.ttfb), phase(transfer), millis(end.Sub(start)))
+	if t.connectStart.IsZero() && !t.wroteRequest.IsZero() {
+		line += " (reused connection)"
+	}
+	return line
+}
+
+// timingCSV appends a row per exchange to the -record-timing-csv file.
+// Rows are buffered and flushed on close.
+type timingCSV struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+var timingColumns = []string{"timestamp", "id", "method", "path", "status", "latency_ms", "dns_ms", "connect_ms", "tls_ms", "ttfb_ms"}
+
+// openTimingCSV opens path for appending, writing the header row when the
+// file is new or empty
+func openTimingCSV(path string) (*timingCSV, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c := &timingCSV{file: f, w: csv.NewWriter(f)}
+	if info.Size() == 0 {
+		_ = c.w.Write(timingColumns)
+	}
+	return c, nil
+}
+
+func (c *timingCSV) record(ex *exchange, path string, status int) {
+	latency := time.Since(ex.start)
+	row := []string{ex.start.UTC().Format(time.RFC3339Nano), ex.idString(), ex.method, path, strconv.Itoa(status), millis(latency)}
+	if t := ex.timing; t != nil {
+		t.mu.Lock()
+		row = append(row, millis(t.dns), millis(t.connect), millis(t.tls), millis(t.ttfb))
+		t.mu.Unlock()
+	} else {
+		row = append(row, "", "", "", "")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.w.Write(row)
+}
+
+// millis formats a duration in milliseconds; a phase that did not happen is empty
+func millis(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
+
+func (c *timingCSV) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
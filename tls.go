@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certPollInterval is how often the listener certificate files are checked for changes
+const certPollInterval = 10 * time.Second
+
+// listenerTLSConfig returns the TLS configuration terminating client
+// connections with the given certificate. With logSNI, the server name each
+// client asks for in its hello is logged, also for handshakes that fail.
+// The certificate is served by certs, so rotated files are picked up by new
+// handshakes.
+func listenerTLSConfig(certs *certReloader, logSNI bool, logger *log.Logger) *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if logSNI {
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			logger.Printf("TLS client hello from %s: SNI=%q", hello.Conn.RemoteAddr(), hello.ServerName)
+			// nil keeps using cfg
+			return nil, nil
+		}
+	}
+	return cfg
+}
+
+// certReloader keeps the listener certificate loaded from disk. It is loaded
+// again on reload, or by watch when either file changes; a certificate that
+// fails to load is logged and the previous one stays in use.
+type certReloader struct {
+	certFile, keyFile string
+	logger            *log.Logger
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *log.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := r.load(r.filesModTime()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	first := r.cert == nil
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	if !first {
+		subject, expires := "", ""
+		if cert.Leaf != nil {
+			subject, expires = cert.Leaf.Subject.String(), cert.Leaf.NotAfter.Format(time.RFC3339)
+		}
+		r.logger.Printf("Reloaded TLS certificate from %s: subject=%q expires=%s", r.certFile, subject, expires)
+	}
+	return nil
+}
+
+// reload loads the certificate again, as on SIGHUP
+func (r *certReloader) reload(reason string) {
+	if err := r.load(r.filesModTime()); err != nil {
+		r.logger.Printf("Error reloading TLS certificate (%s), keeping the current one: %v", reason, err)
+	}
+}
+
+// filesModTime returns the later modification time of the two files
+func (r *certReloader) filesModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// watch reloads the certificate whenever the files change, checking every
+// certPollInterval
+func (r *certReloader) watch() {
+	for range time.Tick(certPollInterval) {
+		modTime := r.filesModTime()
+		r.mu.Lock()
+		changed := !modTime.Equal(r.modTime)
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := r.load(modTime); err != nil {
+			r.logger.Printf("Error reloading changed TLS certificate, keeping the current one: %v", err)
+			// retry once the files change again, not on every tick
+			r.mu.Lock()
+			r.modTime = modTime
+			r.mu.Unlock()
+		}
+	}
+}
+
+// loadClientCertificate loads the certificate presented to mutual TLS backends
+func loadClientCertificate(certFile, keyFile string) (tls.Certificate, *x509.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return cert, leaf, nil
+}
+
+// loadCABundle returns the system roots plus the PEM certificates of file,
+// for verifying backends signed by a private CA, and how many it added
+func loadCABundle(file string) (*x509.CertPool, int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	n := 0
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", file, err)
+		}
+		pool.AddCert(cert)
+		n++
+	}
+	if n == 0 {
+		return nil, 0, fmt.Errorf("%s: no PEM certificates found", file)
+	}
+	return pool, n, nil
+}
+
+// selfSignedCertificate generates a throwaway certificate for -tls-self-signed,
+// valid for a week for localhost, the machine's host name and the loopback
+// addresses, plus host when the listener is bound to a name or address
+func selfSignedCertificate(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "http-debug-proxy self-signed"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(7 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if name, err := os.Hostname(); err == nil && name != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, name)
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	} else if ip == nil && host != "" && !slices.Contains(tmpl.DNSNames, host) {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// certificateSummary names the hosts a certificate is valid for and its
+// SHA-256 fingerprint, for clients to pin or trust it
+func certificateSummary(leaf *x509.Certificate) string {
+	names := slices.Clone(leaf.DNSNames)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	sum := sha256.Sum256(leaf.Raw)
+	return fmt.Sprintf("%s (SHA-256 fingerprint %X, expires %s)", strings.Join(names, ", "), sum, leaf.NotAfter.Format(time.RFC3339))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCertificate generates a self-signed certificate for host into the
+// PEM files and returns it
+func writeCertificate(t *testing.T, certFile, keyFile, host string) *tls.Certificate {
+	t.Helper()
+	cert, err := selfSignedCertificate(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeCertificate(t, certFile, keyFile, "first.test")
+	var logs syncBuffer
+	r, err := newCertReloader(certFile, keyFile, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() []byte {
+		cert, _ := r.getCertificate(nil)
+		return cert.Certificate[0]
+	}
+	if !bytes.Equal(served(), first.Certificate[0]) {
+		t.Fatal("the loaded certificate is not the one on disk")
+	}
+	if logs.String() != "" {
+		t.Errorf("the first load logged %q", logs.String())
+	}
+
+	second := writeCertificate(t, certFile, keyFile, "second.test")
+	r.reload("SIGHUP")
+	if !bytes.Equal(served(), second.Certificate[0]) {
+		t.Error("reload kept the old certificate")
+	}
+	if want := "Reloaded TLS certificate from " + certFile + ": subject=\"CN=http-debug-proxy self-signed\""; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs.String())
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.reload("SIGHUP")
+	if !bytes.Equal(served(), second.Certificate[0]) {
+		t.Error("a broken certificate file replaced the loaded one")
+	}
+	if want := "Error reloading TLS certificate (SIGHUP), keeping the current one: "; !strings.Contains(logs.String(), want) {
+		t.Errorf("log is missing %q:\n%s", want, logs.String())
+	}
+}
+
+func TestNewCertReloaderFailsOnMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), log.New(&syncBuffer{}, "", 0)); err == nil {
+		t.Error("loading missing files succeeded")
+	}
+}
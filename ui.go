@@ -0,0 +1,276 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed ui/index.html
+var uiIndex []byte
+
+// exchangeSummary is the JSON form of a captured exchange in listings
+type exchangeSummary struct {
+	ID         uint64    `json:"id"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status"`
+	Tags       string    `json:"tags,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// exchangeDetail is the JSON form of a captured exchange with headers and bodies
+type exchangeDetail struct {
+	exchangeSummary
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    string      `json:"request_body"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   string      `json:"response_body"`
+	Streamed       bool        `json:"streamed,omitempty"`
+	// the trailers and the 1xx responses, when there were any
+	RequestTrailer  http.Header       `json:"request_trailer,omitempty"`
+	ResponseTrailer http.Header       `json:"response_trailer,omitempty"`
+	Interim         []interimResponse `json:"interim,omitempty"`
+}
+
+func (c *capturedExchange) summary() exchangeSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.summaryLocked()
+}
+
+func (c *capturedExchange) summaryLocked() exchangeSummary {
+	return exchangeSummary{
+		ID:         c.id,
+		Start:      c.start,
+		DurationMs: float64(c.duration) / float64(time.Millisecond),
+		Method:     c.method,
+		URL:        c.url,
+		Status:     c.status,
+		Tags:       c.tags,
+		Error:      c.err,
+	}
+}
+
+// detail renders the bodies the way they are logged
+func (c *capturedExchange) detail(d *dumper) exchangeDetail {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	redact := c.redactor(d)
+	var interim []interimResponse
+	for _, r := range c.interim {
+		interim = append(interim, interimResponse{Status: r.Status, Header: redact.Header(r.Header)})
+	}
+	return exchangeDetail{
+		exchangeSummary: c.summaryLocked(),
+		RequestHeader:   redact.Header(c.requestHeader),
+		RequestBody:     string(d.bodyForLog(redact, c.requestBody, c.requestHeader.Get("Content-Type"))),
+		ResponseHeader:  redact.Header(c.responseHeader),
+		ResponseBody:    string(d.bodyForLog(redact, c.responseBody, c.responseHeader.Get("Content-Type"))),
+		Streamed:        c.streamed,
+		RequestTrailer:  redact.Header(c.requestTrailer),
+		ResponseTrailer: redact.Header(c.responseTrailer),
+		Interim:         interim,
+	}
+}
+
+// newUIHandler serves the transaction browser page and the JSON API it polls;
+// captured requests are replayed through serve
+func newUIHandler(store *captureStore, d *dumper, serve http.HandlerFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(uiIndex)
+	})
+	listExchanges := func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseExchangeQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var matched []*capturedExchange
+		list := store.list()
+		for i := len(list) - 1; i >= 0; i-- {
+			if q.match(list[i]) {
+				matched = append(matched, list[i])
+			}
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(matched)))
+		if q.detail {
+			out := make([]exchangeDetail, 0, len(matched))
+			for _, c := range matched {
+				out = append(out, c.detail(d))
+			}
+			writeJSON(w, out)
+			return
+		}
+		out := make([]exchangeSummary, 0, len(matched))
+		for _, c := range matched {
+			out = append(out, c.summary())
+		}
+		writeJSON(w, out)
+	}
+	clearExchanges := func(w http.ResponseWriter, r *http.Request) {
+		n := store.clear()
+		d.at(levelInfo, d.logger).Printf("Web UI: cleared %d captured exchanges", n)
+		w.WriteHeader(http.StatusNoContent)
+	}
+	getExchange := func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid exchange id", http.StatusBadRequest)
+			return
+		}
+		c := store.get(id)
+		if c == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, c.detail(d))
+	}
+	exchangeCurl := func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid exchange id", http.StatusBadRequest)
+			return
+		}
+		c := store.get(id)
+		if c == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, c.curl(c.redactor(d))+"\n")
+	}
+	// /admin/exchanges serves the same API for test suites asserting on the
+	// captured traffic
+	for _, prefix := range []string{"/api/exchanges", "/admin/exchanges"} {
+		mux.HandleFunc("GET "+prefix, listExchanges)
+		mux.HandleFunc("DELETE "+prefix, clearExchanges)
+		mux.HandleFunc("GET "+prefix+"/{id}", getExchange)
+		mux.HandleFunc("GET "+prefix+"/{id}/curl", exchangeCurl)
+	}
+	mux.HandleFunc("GET /api/export", func(w http.ResponseWriter, r *http.Request) {
+		list := store.list()
+		out := make([]fixtureExchange, 0, len(list))
+		for _, c := range list {
+			out = append(out, c.fixture(d))
+		}
+		writeJSON(w, out)
+	})
+	if d.metrics != nil {
+		mux.Handle("GET /metrics", d.metrics)
+	}
+	if d.intercept != nil {
+		d.intercept.handle(mux)
+	}
+	replayExchange := func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid exchange id", http.StatusBadRequest)
+			return
+		}
+		c := store.get(id)
+		if c == nil {
+			http.NotFound(w, r)
+			return
+		}
+		count, concurrency, err := resendCounts(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		with, err := readResendBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.at(levelInfo, d.logger).Printf("REPLAY of exchange #%d: %d requests, %d at a time%s", id, count, concurrency, with.describe())
+		results, err := resend(r.Context(), serve, c, count, concurrency, with)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, results)
+	}
+	for _, pattern := range []string{"/api/exchanges/{id}/replay", "/admin/exchanges/{id}/replay", "/admin/replay/{id}"} {
+		mux.HandleFunc("POST "+pattern, replayExchange)
+	}
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// exchangeQuery selects the exchanges GET /api/exchanges lists: by the
+// method=, path=, exclude-path= and status= terms of -capture-filter, and by
+// header=Name (present) or header=Name:value on the request headers, all of
+// which must match. With detail=1 the exchanges come with headers and bodies.
+type exchangeQuery struct {
+	filter  *exchangeFilter
+	headers [][2]string
+	detail  bool
+}
+
+func parseExchangeQuery(values url.Values) (*exchangeQuery, error) {
+	q := &exchangeQuery{}
+	var terms []string
+	for key, list := range values {
+		for _, value := range list {
+			switch key {
+			case "method", "path", "exclude-path", "status":
+				terms = append(terms, key+"="+value)
+			case "header":
+				name, want, _ := strings.Cut(value, ":")
+				if name = strings.TrimSpace(name); name == "" {
+					return nil, fmt.Errorf("invalid header %q, expected Name or Name:value", value)
+				}
+				q.headers = append(q.headers, [2]string{name, strings.TrimSpace(want)})
+			case "detail":
+				q.detail = value == "1" || value == "true"
+			default:
+				return nil, fmt.Errorf("unknown query parameter %q", key)
+			}
+		}
+	}
+	if len(terms) > 0 {
+		f, err := parseExchangeFilter(terms)
+		if err != nil {
+			return nil, err
+		}
+		q.filter = f
+	}
+	return q, nil
+}
+
+func (q *exchangeQuery) match(c *capturedExchange) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if q.filter != nil {
+		path := c.url
+		if u, err := url.Parse(c.url); err == nil {
+			path = u.Path
+		}
+		if !q.filter.match(c.method, path, c.status) {
+			return false
+		}
+	}
+	for _, h := range q.headers {
+		values := c.requestHeader.Values(h[0])
+		if len(values) == 0 || h[1] != "" && !slices.Contains(values, h[1]) {
+			return false
+		}
+	}
+	return true
+}
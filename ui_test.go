@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// captureTraffic sends three POSTs, two of them with X-Tenant: acme, and a
+// GET through a proxy keeping them for the web UI, and serves its API
+func captureTraffic(t *testing.T) *httptest.Server {
+	t.Helper()
+	d, _ := newTestDumper()
+	d.captures = newCaptureStore(10)
+	proxy := startProxy(t, d, echoBackend(t).URL)
+	for i, tenant := range []string{"acme", "", "acme"} {
+		req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/orders", strings.NewReader("order "+strconv.Itoa(i)))
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(proxy.URL + "/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	proxy.Close()
+	ui := httptest.NewServer(newUIHandler(d.captures, d, nil))
+	t.Cleanup(ui.Close)
+	return ui
+}
+
+func call(t *testing.T, method, u string, v any) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, u, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, u, err)
+		}
+	}
+	return resp
+}
+
+func TestExchangesAPI(t *testing.T) {
+	for _, prefix := range []string{"/api/exchanges", "/admin/exchanges"} {
+		t.Run(prefix, func(t *testing.T) {
+			ui := captureTraffic(t)
+
+			var all []exchangeSummary
+			resp := call(t, http.MethodGet, ui.URL+prefix, &all)
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("listing answered %s as %q", resp.Status, resp.Header.Get("Content-Type"))
+			}
+			if resp.Header.Get("X-Total-Count") != "4" || len(all) != 4 {
+				t.Fatalf("listed %d exchanges, X-Total-Count %q, want 4", len(all), resp.Header.Get("X-Total-Count"))
+			}
+			if all[0].Method != http.MethodGet || all[0].ID < all[1].ID {
+				t.Errorf("listing is not newest first: %+v", all)
+			}
+
+			var posts []exchangeDetail
+			resp = call(t, http.MethodGet, ui.URL+prefix+"?method=POST&header=X-Tenant:acme&detail=1", &posts)
+			if resp.Header.Get("X-Total-Count") != "2" || len(posts) != 2 {
+				t.Fatalf("selected %d exchanges, X-Total-Count %q, want the 2 acme POSTs", len(posts), resp.Header.Get("X-Total-Count"))
+			}
+			if posts[0].RequestBody != "order 2" || posts[0].ResponseBody != "POST order 2" || posts[0].RequestHeader.Get("X-Tenant") != "acme" {
+				t.Errorf("detail=1 gave %+v", posts[0])
+			}
+
+			var one exchangeDetail
+			if resp = call(t, http.MethodGet, ui.URL+prefix+"/"+strconv.FormatUint(all[3].ID, 10), &one); resp.StatusCode != http.StatusOK || one.RequestBody != "order 0" {
+				t.Errorf("GET of exchange %d answered %s with %+v", all[3].ID, resp.Status, one)
+			}
+			curl, err := http.Get(ui.URL + prefix + "/" + strconv.FormatUint(all[3].ID, 10) + "/curl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			command, _ := io.ReadAll(curl.Body)
+			curl.Body.Close()
+			if curl.StatusCode != http.StatusOK || !strings.HasPrefix(string(command), "curl ") || !strings.Contains(string(command), "order 0") {
+				t.Errorf("GET of the curl command answered %s with %q", curl.Status, command)
+			}
+			for path, want := range map[string]int{
+				prefix + "/999/curl": http.StatusNotFound,
+				prefix + "/999":      http.StatusNotFound,
+				prefix + "/x":        http.StatusBadRequest,
+				prefix + "?foo=1":    http.StatusBadRequest,
+			} {
+				if resp = call(t, http.MethodGet, ui.URL+path, nil); resp.StatusCode != want {
+					t.Errorf("GET %s answered %s, want %d", path, resp.Status, want)
+				}
+			}
+			if resp = call(t, http.MethodPost, ui.URL+prefix, nil); resp.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("POST %s answered %s, want 405", prefix, resp.Status)
+			}
+
+			if resp = call(t, http.MethodDelete, ui.URL+prefix, nil); resp.StatusCode != http.StatusNoContent {
+				t.Errorf("DELETE %s answered %s, want 204", prefix, resp.Status)
+			}
+			var left []exchangeSummary
+			if resp = call(t, http.MethodGet, ui.URL+prefix, &left); resp.Header.Get("X-Total-Count") != "0" || len(left) != 0 {
+				t.Errorf("%d exchanges left after DELETE", len(left))
+			}
+		})
+	}
+}
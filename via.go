@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// viaPseudonym names the proxy in the Via headers it adds
+const viaPseudonym = "http-debug-proxy"
+
+// viaEntry returns the Via entry for a message received with the given
+// protocol version, e.g. "1.1 http-debug-proxy" or "2 http-debug-proxy"
+func viaEntry(major, minor int) string {
+	version := strconv.Itoa(major)
+	if major < 2 {
+		version += "." + strconv.Itoa(minor)
+	}
+	return version + " " + viaPseudonym
+}
+
+// viaDirector adds the proxy to the Via header of forwarded requests
+func viaDirector(director func(*http.Request)) func(*http.Request) {
+	return func(req *http.Request) {
+		director(req)
+		req.Header.Add("Via", viaEntry(req.ProtoMajor, req.ProtoMinor))
+	}
+}
+
+// rewriteServerHeader adds the proxy to the Via header of a response and,
+// with server set, replaces its Server header; "-" removes it
+func rewriteServerHeader(resp *http.Response, addVia bool, server string) {
+	if addVia {
+		resp.Header.Add("Via", viaEntry(resp.ProtoMajor, resp.ProtoMinor))
+	}
+	switch server {
+	case "":
+	case "-":
+		resp.Header.Del("Server")
+	default:
+		resp.Header.Set("Server", server)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxWSMessage bounds the payload kept to log a WebSocket message; longer
+// messages are logged by size only
+const maxWSMessage = 1 << 20
+
+// wsDeflateTail ends every permessage-deflate message once the sender
+// stripped it (RFC 7692 section 7.2.1)
+var wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// wsWindow is the largest LZ77 window a permessage-deflate sender may refer back to
+const wsWindow = 32 << 10
+
+// dumpUpgradeResponse logs a 101 Switching Protocols response. For WebSocket
+// the backend connection is tapped so frames are logged in both directions
+// while the reverse proxy copies them untouched.
+func (d *dumper) dumpUpgradeResponse(resp *http.Response) {
+	if !d.releaseExchange(resp) {
+		return
+	}
+	d.flushGroup(resp.Request.Context())
+	logger := d.at(levelDebug, d.loggerFor(resp.Request.Context()))
+	headerDump, err := d.dumpResponseHead(resp)
+	if err != nil {
+		logger.Printf("Error dumping response headers: %v", err)
+	} else {
+		logger.Printf("----- RESPONSE HEADERS%s-----\n%s", d.elapsed(resp.Request.Context()), headerDump)
+	}
+	captureResponse(resp, nil, true)
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return
+	}
+	deflate, serverTakeover, clientTakeover := parseWSDeflate(resp.Header.Values("Sec-WebSocket-Extensions"))
+	if deflate && !d.wsInflate {
+		logger.Printf("WebSocket: permessage-deflate negotiated, compressed messages are logged as is (see -ws-inflate)")
+	}
+	inflate := deflate && d.wsInflate
+	resp.Body = &wsTap{
+		ReadWriteCloser: conn,
+		fromBackend:     &wsFrameLogger{logger: logger, direction: "server->client", inflate: inflate, takeover: serverTakeover},
+		toBackend:       &wsFrameLogger{logger: logger, direction: "client->server", inflate: inflate, takeover: clientTakeover},
+	}
+}
+
+// parseWSDeflate reports whether the handshake response negotiated
+// permessage-deflate, and whether each side keeps its compression context
+// between messages
+func parseWSDeflate(extensions []string) (deflate, serverTakeover, clientTakeover bool) {
+	for _, header := range extensions {
+		for _, ext := range strings.Split(header, ",") {
+			params := strings.Split(ext, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+				continue
+			}
+			deflate, serverTakeover, clientTakeover = true, true, true
+			for _, p := range params[1:] {
+				name, _, _ := strings.Cut(strings.TrimSpace(p), "=")
+				switch strings.ToLower(name) {
+				case "server_no_context_takeover":
+					serverTakeover = false
+				case "client_no_context_takeover":
+					clientTakeover = false
+				}
+			}
+			return
+		}
+	}
+	return false, false, false
+}
+
+// wsTap logs the frames read from and written to the backend connection
+type wsTap struct {
+	io.ReadWriteCloser
+	fromBackend *wsFrameLogger
+	toBackend   *wsFrameLogger
+}
+
+func (t *wsTap) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	t.fromBackend.feed(p[:n])
+	return n, err
+}
+
+func (t *wsTap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	t.toBackend.feed(p[:n])
+	return n, err
+}
+
+// wsFrameLogger parses the frames of one direction of a WebSocket connection
+// from the bytes as they pass, and logs each message once complete. Only one
+// goroutine feeds a given direction.
+type wsFrameLogger struct {
+	logger    *log.Logger
+	direction string
+	// inflate decompresses permessage-deflate messages; takeover keeps the
+	// sender's window between messages
+	inflate  bool
+	takeover bool
+	window   []byte
+	// broken stops parsing after a malformed frame
+	broken bool
+	buf    []byte
+	// message is the data message being reassembled from fragments
+	opcode     byte
+	compressed bool
+	message    []byte
+	size       int64
+}
+
+func (l *wsFrameLogger) feed(p []byte) {
+	if l.broken || len(p) == 0 {
+		return
+	}
+	l.buf = append(l.buf, p...)
+	for {
+		n, ok := l.frame(l.buf)
+		if !ok {
+			break
+		}
+		l.buf = l.buf[n:]
+	}
+	if len(l.buf) == 0 {
+		l.buf = nil
+	}
+}
+
+// frame handles the first frame of b when it is complete, returning its length
+func (l *wsFrameLogger) frame(b []byte) (int, bool) {
+	if len(b) < 2 {
+		return 0, false
+	}
+	fin, rsv1, opcode := b[0]&0x80 != 0, b[0]&0x40 != 0, b[0]&0x0f
+	masked := b[1]&0x80 != 0
+	length, header := uint64(b[1]&0x7f), 2
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return 0, false
+		}
+		length, header = uint64(binary.BigEndian.Uint16(b[2:])), 4
+	case 127:
+		if len(b) < 10 {
+			return 0, false
+		}
+		length, header = binary.BigEndian.Uint64(b[2:]), 10
+	}
+	var mask []byte
+	if masked {
+		if len(b) < header+4 {
+			return 0, false
+		}
+		mask, header = b[header:header+4], header+4
+	}
+	if length > maxWSMessage {
+		// too large to keep; no frame this size is expected from browsers
+		l.logger.Printf("----- WS %s frame of %d bytes, not logged; frame logging stopped for this direction -----", l.direction, length)
+		l.broken = true
+		return 0, false
+	}
+	total := header + int(length)
+	if len(b) < total {
+		return 0, false
+	}
+	payload := b[header:total]
+	if mask != nil {
+		payload = append([]byte(nil), payload...)
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	if opcode >= 0x8 {
+		l.control(opcode, payload)
+		return total, true
+	}
+	if opcode != 0 {
+		l.opcode, l.compressed, l.message, l.size = opcode, rsv1, nil, 0
+	}
+	l.size += int64(len(payload))
+	if l.size <= maxWSMessage {
+		l.message = append(l.message, payload...)
+	}
+	if fin {
+		l.logMessage()
+	}
+	return total, true
+}
+
+func (l *wsFrameLogger) control(opcode byte, payload []byte) {
+	switch opcode {
+	case 0x8:
+		if len(payload) >= 2 {
+			l.logger.Printf("----- WS %s CLOSE code=%d reason=%q -----", l.direction, binary.BigEndian.Uint16(payload), payload[2:])
+		} else {
+			l.logger.Printf("----- WS %s CLOSE -----", l.direction)
+		}
+	case 0x9:
+		l.logger.Printf("----- WS %s PING (%d bytes) -----", l.direction, len(payload))
+	case 0xa:
+		l.logger.Printf("----- WS %s PONG (%d bytes) -----", l.direction, len(payload))
+	default:
+		l.logger.Printf("----- WS %s control frame opcode %#x (%d bytes) -----", l.direction, opcode, len(payload))
+	}
+}
+
+func (l *wsFrameLogger) logMessage() {
+	kind := "BINARY"
+	if l.opcode == 0x1 {
+		kind = "TEXT"
+	}
+	if l.size > maxWSMessage {
+		l.logger.Printf("----- WS %s %s (%d bytes, too large to log) -----", l.direction, kind, l.size)
+		if l.compressed && l.takeover {
+			// later messages may refer to the window this one would have filled
+			l.inflate = false
+		}
+		return
+	}
+	payload, note := l.message, ""
+	if l.compressed {
+		note = ", compressed"
+		if l.inflate {
+			inflated, err := l.inflateMessage(payload)
+			if err != nil {
+				l.logger.Printf("WebSocket: inflating %s message failed (%v), logging compressed messages as is", l.direction, err)
+				l.inflate = false
+			} else {
+				payload, note = inflated, fmt.Sprintf(", %d bytes compressed", len(l.message))
+			}
+		}
+	}
+	l.message = nil
+	if kind == "TEXT" && (!l.compressed || l.inflate) {
+		l.logger.Printf("----- WS %s %s (%d bytes%s) -----\n%s", l.direction, kind, len(payload), note, payload)
+		return
+	}
+	l.logger.Printf("----- WS %s %s (%d bytes%s) -----\n%s", l.direction, kind, len(payload), note, hex.Dump(payload))
+}
+
+// inflateMessage decompresses a permessage-deflate message. With context
+// takeover the sender may refer back to earlier messages, so the last
+// wsWindow bytes of output are kept as the dictionary of the next one.
+func (l *wsFrameLogger) inflateMessage(payload []byte) ([]byte, error) {
+	var dict []byte
+	if l.takeover {
+		dict = l.window
+	}
+	r := flate.NewReaderDict(io.MultiReader(bytes.NewReader(payload), bytes.NewReader(wsDeflateTail)), dict)
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	// the message ends with a sync flush, not a final block
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if l.takeover {
+		l.window = append(l.window, out...)
+		if len(l.window) > wsWindow {
+			l.window = append([]byte(nil), l.window[len(l.window)-wsWindow:]...)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"log"
+	"strings"
+	"testing"
+)
+
+// wsFrame builds a WebSocket frame, masked when mask is set
+func wsFrame(fin, compressed bool, opcode byte, payload, mask []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if compressed {
+		b0 |= 0x40
+	}
+	frame := []byte{b0}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, mask...)
+	for i, c := range payload {
+		frame = append(frame, c^mask[i%4])
+	}
+	return frame
+}
+
+func TestParseWSDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		header                  string
+		deflate, server, client bool
+	}{
+		{"", false, false, false},
+		{"permessage-deflate", true, true, true},
+		{"x-webkit-deflate-frame, permessage-deflate; server_no_context_takeover", true, false, true},
+		{"permessage-deflate; client_no_context_takeover; client_max_window_bits=15", true, true, false},
+	} {
+		deflate, server, client := parseWSDeflate([]string{tc.header})
+		if deflate != tc.deflate || server != tc.server || client != tc.client {
+			t.Errorf("parseWSDeflate(%q) = %v, %v, %v, want %v, %v, %v", tc.header, deflate, server, client, tc.deflate, tc.server, tc.client)
+		}
+	}
+}
+
+func TestWSFrameLoggerReassemblesMessages(t *testing.T) {
+	var logs bytes.Buffer
+	l := &wsFrameLogger{logger: log.New(&logs, "", 0), direction: "client->server"}
+	mask := []byte{1, 2, 3, 4}
+	stream := append(wsFrame(false, false, 0x1, []byte("hello, "), mask), wsFrame(true, false, 0x0, []byte("world"), mask)...)
+	stream = append(stream, wsFrame(true, false, 0x9, nil, mask)...)
+	stream = append(stream, wsFrame(true, false, 0x8, append([]byte{0x03, 0xe8}, "bye"...), mask)...)
+	// fed in small pieces, as reads split frames anywhere
+	for len(stream) > 0 {
+		n := min(3, len(stream))
+		l.feed(stream[:n])
+		stream = stream[n:]
+	}
+	want := "----- WS client->server TEXT (12 bytes) -----\nhello, world\n" +
+		"----- WS client->server PING (0 bytes) -----\n" +
+		"----- WS client->server CLOSE code=1000 reason=\"bye\" -----\n"
+	if logs.String() != want {
+		t.Errorf("log:\n%s\nwant:\n%s", logs.String(), want)
+	}
+}
+
+func TestWSFrameLoggerInflatesWithContextTakeover(t *testing.T) {
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	var frames []byte
+	message := strings.Repeat("status: ok; ", 8)
+	for range 2 {
+		start := compressed.Len()
+		_, _ = w.Write([]byte(message))
+		_ = w.Flush()
+		payload := bytes.TrimSuffix(compressed.Bytes()[start:], wsDeflateTail)
+		frames = append(frames, wsFrame(true, true, 0x1, payload, nil)...)
+	}
+
+	var logs bytes.Buffer
+	l := &wsFrameLogger{logger: log.New(&logs, "", 0), direction: "server->client", inflate: true, takeover: true}
+	l.feed(frames)
+	// the second message only refers back to the first one
+	if got := strings.Count(logs.String(), "\n"+message+"\n"); got != 2 {
+		t.Errorf("%d messages logged inflated, want 2:\n%s", got, logs.String())
+	}
+	if strings.Contains(logs.String(), "failed") {
+		t.Errorf("inflating failed:\n%s", logs.String())
+	}
+}
+
+func TestWSFrameLoggerKeepsCompressedMessagesWithoutInflate(t *testing.T) {
+	var logs bytes.Buffer
+	l := &wsFrameLogger{logger: log.New(&logs, "", 0), direction: "server->client"}
+	l.feed(wsFrame(true, true, 0x1, []byte{0xf2, 0x48, 0xcd, 0xc9}, nil))
+	if !strings.Contains(logs.String(), "TEXT (4 bytes, compressed)") || !strings.Contains(logs.String(), "f2 48 cd c9") {
+		t.Errorf("log:\n%s\nwant the compressed payload hex-dumped", logs.String())
+	}
+}
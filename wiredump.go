@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// wireDumper tees the raw bytes of client and backend connections to files,
+// one pair of files (in and out) per connection
+type wireDumper struct {
+	dir    string
+	logger *log.Logger
+	seq    atomic.Uint64
+}
+
+func newWireDumper(dir string, logger *log.Logger) (*wireDumper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &wireDumper{dir: dir, logger: logger}, nil
+}
+
+// wrap returns conn teeing what is read to <kind>-<n>-in.raw and what is
+// written to <kind>-<n>-out.raw. When the files cannot be created the error
+// is logged and conn is returned as is.
+func (w *wireDumper) wrap(conn net.Conn, kind string) net.Conn {
+	base := filepath.Join(w.dir, fmt.Sprintf("%s-%06d", kind, w.seq.Add(1)))
+	in, err := os.Create(base + "-in.raw")
+	if err != nil {
+		w.logger.Printf("Error creating wire dump: %v", err)
+		return conn
+	}
+	out, err := os.Create(base + "-out.raw")
+	if err != nil {
+		in.Close()
+		w.logger.Printf("Error creating wire dump: %v", err)
+		return conn
+	}
+	w.logger.Printf("Wire dump: %s connection %s -> %s, %s-{in,out}.raw", kind, conn.LocalAddr(), conn.RemoteAddr(), base)
+	return &wireConn{Conn: conn, in: in, out: out}
+}
+
+// listener wraps every accepted client connection
+func (w *wireDumper) listener(ln net.Listener) net.Listener {
+	return &wireListener{Listener: ln, dumper: w}
+}
+
+// dialer wraps every connection dialed to the backend
+func (w *wireDumper) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return w.wrap(conn, "backend"), nil
+	}
+}
+
+type wireListener struct {
+	net.Listener
+	dumper *wireDumper
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.dumper.wrap(conn, "client"), nil
+}
+
+// wireConn copies the bytes read from and written to the connection
+type wireConn struct {
+	net.Conn
+	in, out   *os.File
+	closeOnce sync.Once
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		_, _ = c.in.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *wireConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		_, _ = c.out.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *wireConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.in.Close()
+		c.out.Close()
+	})
+	return c.Conn.Close()
+}